@@ -0,0 +1,13 @@
+package main
+
+import (
+	"event-processor/internal/app"
+	"log"
+)
+
+func main() {
+	err := app.StartOutboxRelayApp()
+	if err != nil {
+		log.Fatalf("Failed to start outbox relay app: %v", err)
+	}
+}
@@ -0,0 +1,579 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize    = 50
+	maxPageSize        = 200
+	workerHistoryLimit = 50
+)
+
+// pageResponse is the envelope of paginated list endpoints
+type pageResponse struct {
+	Data     interface{} `json:"data"`
+	Page     int         `json:"page"`
+	PageSize int         `json:"page_size"`
+	Total    int64       `json:"total"`
+}
+
+// registerApiV1 registers the versioned REST API of the worker manager
+func registerApiV1(service *services.WorkerManagerService, auth *services.AuthService) {
+	http.HandleFunc("GET /api/v1/openapi.json", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, "./static/openapi.json")
+	}))
+
+	// Actions
+	http.HandleFunc("GET /api/v1/actions", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		page, pageSize, err := parsePage(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pagination", err.Error())
+			return
+		}
+
+		filter := models.ActionListFilter{
+			Statuses: splitList(r.URL.Query().Get("status")),
+			Type:     r.URL.Query().Get("type"),
+			Limit:    pageSize,
+			Offset:   (page - 1) * pageSize,
+		}
+		if filter.TriggeredFrom, err = parseTime(r, "triggered_from"); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid triggered_from", err.Error())
+			return
+		}
+		if filter.TriggeredTo, err = parseTime(r, "triggered_to"); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid triggered_to", err.Error())
+			return
+		}
+
+		actions, total, err := service.ListActions(filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch actions", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, pageResponse{Data: actions, Page: page, PageSize: pageSize, Total: total})
+	}))
+
+	http.HandleFunc("GET /api/v1/actions/{id}", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		actionID, ok := pathID(w, r, "Invalid action ID")
+		if !ok {
+			return
+		}
+
+		action, err := service.GetAction(actionID)
+		if err != nil {
+			writeLookupError(w, err, "Action not found", "Failed to fetch action")
+			return
+		}
+		writeJSON(w, http.StatusOK, action)
+	}))
+
+	http.HandleFunc("GET /api/v1/actions/{id}/events", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		actionID, ok := pathID(w, r, "Invalid action ID")
+		if !ok {
+			return
+		}
+
+		events, err := service.GetActionEvents(actionID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch action events", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, events)
+	}))
+
+	http.HandleFunc("POST /api/v1/actions/{id}/pause", requireRole(auth, services.RoleOperator, actionStatusHandler(service.PauseAction)))
+	http.HandleFunc("POST /api/v1/actions/{id}/resume", requireRole(auth, services.RoleOperator, actionStatusHandler(service.ResumeAction)))
+	http.HandleFunc("POST /api/v1/actions/{id}/cancel", requireRole(auth, services.RoleOperator, actionStatusHandler(service.CancelAction)))
+
+	// Batches
+	http.HandleFunc("GET /api/v1/batches/{id}", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		batchID, ok := pathID(w, r, "Invalid batch ID")
+		if !ok {
+			return
+		}
+
+		batch, err := service.GetBatch(batchID)
+		if err != nil {
+			writeLookupError(w, err, "Batch not found", "Failed to fetch batch")
+			return
+		}
+		writeJSON(w, http.StatusOK, batch)
+	}))
+
+	// Pending batches no idle or processing worker has the required labels for
+	http.HandleFunc("GET /api/v1/batches/unroutable", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		groups, err := service.GetUnroutableBatches()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to check pending batches", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, groups)
+	}))
+
+	// Workers
+	http.HandleFunc("GET /api/v1/workers", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		page, pageSize, err := parsePage(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pagination", err.Error())
+			return
+		}
+
+		workers, total, err := service.ListWorkers(splitList(r.URL.Query().Get("status")), pageSize, (page-1)*pageSize)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch workers", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, pageResponse{Data: workers, Page: page, PageSize: pageSize, Total: total})
+	}))
+
+	http.HandleFunc("GET /api/v1/workers/{worker_id}", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		workerID, ok := workerPathID(w, r)
+		if !ok {
+			return
+		}
+
+		worker, err := service.GetWorker(workerID, workerHistoryLimit)
+		if err != nil {
+			writeLookupError(w, err, "Worker not found", "Failed to fetch worker")
+			return
+		}
+		writeJSON(w, http.StatusOK, worker)
+	}))
+
+	http.HandleFunc("GET /api/v1/workers/{worker_id}/commands", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		workerID, ok := workerPathID(w, r)
+		if !ok {
+			return
+		}
+
+		commands, err := service.ListWorkerCommands(workerID, workerHistoryLimit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch worker commands", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, commands)
+	}))
+
+	http.HandleFunc("GET /api/v1/workers/{worker_id}/events", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		workerID, ok := workerPathID(w, r)
+		if !ok {
+			return
+		}
+
+		events, err := service.ListWorkerEvents(workerID, workerHistoryLimit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch worker events", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, events)
+	}))
+
+	// Queues a drain, pause, resume, shutdown or set_concurrency command for a worker
+	http.HandleFunc("POST /api/v1/workers/{worker_id}/commands", requireRole(auth, services.RoleOperator, func(w http.ResponseWriter, r *http.Request) {
+		workerID, ok := workerPathID(w, r)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Command     string `json:"command"`
+			Concurrency *int   `json:"concurrency"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+
+		command, err := service.SendWorkerCommand(workerID, body.Command, body.Concurrency, identityFrom(r).Name)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			writeError(w, http.StatusNotFound, "Worker not found", err.Error())
+		case errors.Is(err, services.ErrInvalidWorkerCommand):
+			writeError(w, http.StatusBadRequest, "Invalid worker command", err.Error())
+		case errors.Is(err, services.ErrWorkerNotRunning):
+			writeError(w, http.StatusConflict, "Worker not running", err.Error())
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "Failed to send worker command", err.Error())
+		default:
+			writeJSON(w, http.StatusAccepted, command)
+		}
+	}))
+
+	// Subscriptions
+	http.HandleFunc("GET /api/v1/subscriptions", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		uid := r.URL.Query().Get("uid")
+		if _, err := uuid.Parse(uid); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid uid", "A valid uid query parameter is required")
+			return
+		}
+
+		appID := 0
+		if value := r.URL.Query().Get("app_id"); value != "" {
+			var err error
+			if appID, err = strconv.Atoi(value); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid app_id", err.Error())
+				return
+			}
+		}
+
+		subscriptions, err := service.FindSubscriptions(uid, appID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch subscriptions", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, subscriptions)
+	}))
+
+	http.HandleFunc("GET /api/v1/subscriptions/{id}", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		subscriptionID, ok := pathID(w, r, "Invalid subscription ID")
+		if !ok {
+			return
+		}
+
+		subscription, err := service.GetSubscription(subscriptionID)
+		if err != nil {
+			writeLookupError(w, err, "Subscription not found", "Failed to fetch subscription")
+			return
+		}
+		writeJSON(w, http.StatusOK, subscription)
+	}))
+
+	// Status transitions of subscriptions
+	http.HandleFunc("GET /api/v1/subscription-history", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		page, pageSize, err := parsePage(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pagination", err.Error())
+			return
+		}
+
+		filter := models.SubscriptionHistoryFilter{
+			UID:     r.URL.Query().Get("uid"),
+			Sources: splitList(r.URL.Query().Get("source")),
+			Limit:   pageSize,
+			Offset:  (page - 1) * pageSize,
+		}
+		if value := r.URL.Query().Get("subscription_id"); value != "" {
+			if filter.SubscriptionID, err = strconv.ParseInt(value, 10, 64); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid subscription_id", err.Error())
+				return
+			}
+		}
+		if filter.UID != "" {
+			if _, err := uuid.Parse(filter.UID); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid uid", err.Error())
+				return
+			}
+		}
+		if filter.SubscriptionID == 0 && filter.UID == "" {
+			writeError(w, http.StatusBadRequest, "Missing subscription", "A subscription_id or uid query parameter is required")
+			return
+		}
+		if value := r.URL.Query().Get("app_id"); value != "" {
+			if filter.AppID, err = strconv.Atoi(value); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid app_id", err.Error())
+				return
+			}
+		}
+		if filter.From, err = parseTime(r, "from"); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid from", err.Error())
+			return
+		}
+		if filter.To, err = parseTime(r, "to"); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid to", err.Error())
+			return
+		}
+
+		history, total, err := service.ListSubscriptionHistory(filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch subscription history", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, pageResponse{Data: history, Page: page, PageSize: pageSize, Total: total})
+	}))
+
+	// Failed subscriptions waiting for a retry
+	http.HandleFunc("GET /api/v1/subscription-failures", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		page, pageSize, err := parsePage(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pagination", err.Error())
+			return
+		}
+
+		failures, total, err := service.ListSubscriptionFailures(pageSize, (page-1)*pageSize)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch subscription failures", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, pageResponse{Data: failures, Page: page, PageSize: pageSize, Total: total})
+	}))
+
+	// Receipts that failed too often
+	http.HandleFunc("GET /api/v1/quarantine", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		page, pageSize, err := parsePage(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pagination", err.Error())
+			return
+		}
+
+		receipts, total, err := service.ListQuarantine(splitList(r.URL.Query().Get("status")), pageSize, (page-1)*pageSize)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch quarantined receipts", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, pageResponse{Data: receipts, Page: page, PageSize: pageSize, Total: total})
+	}))
+
+	http.HandleFunc("POST /api/v1/quarantine/{id}/requeue", requireRole(auth, services.RoleOperator, quarantineReviewHandler(service.RequeueQuarantined)))
+	http.HandleFunc("POST /api/v1/quarantine/{id}/dismiss", requireRole(auth, services.RoleOperator, quarantineReviewHandler(service.DismissQuarantined)))
+
+	// When sweeps re-validate the subscriptions of each app
+	http.HandleFunc("GET /api/v1/validation-windows", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		windows, err := service.ListValidationWindows()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch validation windows", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, windows)
+	}))
+
+	http.HandleFunc("GET /api/v1/apps/{id}/validation-window", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		appID, ok := pathID(w, r, "Invalid app ID")
+		if !ok {
+			return
+		}
+
+		window, err := service.GetValidationWindow(int(appID))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch validation window", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, window)
+	}))
+
+	http.HandleFunc("PUT /api/v1/apps/{id}/validation-window", requireRole(auth, services.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		appID, ok := pathID(w, r, "Invalid app ID")
+		if !ok {
+			return
+		}
+
+		window := models.DefaultValidationWindow
+		if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+		window.AppID = int(appID)
+
+		window, err := service.SaveValidationWindow(window, identityFrom(r).Name)
+		switch {
+		case errors.Is(err, models.ErrInvalidValidationWindow):
+			writeError(w, http.StatusBadRequest, "Invalid validation window", err.Error())
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "Failed to save validation window", err.Error())
+		default:
+			writeJSON(w, http.StatusOK, window)
+		}
+	}))
+
+	http.HandleFunc("DELETE /api/v1/apps/{id}/validation-window", requireRole(auth, services.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		appID, ok := pathID(w, r, "Invalid app ID")
+		if !ok {
+			return
+		}
+
+		window, err := service.ResetValidationWindow(int(appID), identityFrom(r).Name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to reset validation window", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, window)
+	}))
+
+	// Differences to the store found by reconciliations
+	http.HandleFunc("GET /api/v1/reconciliation-discrepancies", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		page, pageSize, err := parsePage(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pagination", err.Error())
+			return
+		}
+
+		filter := models.DiscrepancyFilter{
+			Kinds:  splitList(r.URL.Query().Get("kind")),
+			Limit:  pageSize,
+			Offset: (page - 1) * pageSize,
+		}
+		if value := r.URL.Query().Get("action_id"); value != "" {
+			if filter.ActionID, err = strconv.ParseInt(value, 10, 64); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid action_id", err.Error())
+				return
+			}
+		}
+		if value := r.URL.Query().Get("app_id"); value != "" {
+			if filter.AppID, err = strconv.Atoi(value); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid app_id", err.Error())
+				return
+			}
+		}
+		if value := r.URL.Query().Get("fixed"); value != "" {
+			fixed, err := strconv.ParseBool(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid fixed", err.Error())
+				return
+			}
+			filter.Fixed = &fixed
+		}
+
+		discrepancies, total, err := service.ListDiscrepancies(filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch discrepancies", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, pageResponse{Data: discrepancies, Page: page, PageSize: pageSize, Total: total})
+	}))
+
+	// Identity of the caller
+	http.HandleFunc("GET /api/v1/me", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, identityFrom(r))
+	}))
+
+	// Audit log of authenticated mutations
+	http.HandleFunc("GET /api/v1/audit", requireRole(auth, services.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		page, pageSize, err := parsePage(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pagination", err.Error())
+			return
+		}
+
+		entries, total, err := auth.ListAuditLogs(r.URL.Query().Get("actor"), pageSize, (page-1)*pageSize)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch audit logs", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, pageResponse{Data: entries, Page: page, PageSize: pageSize, Total: total})
+	}))
+
+	// Unknown API paths and methods get the same error body as everything else
+	http.HandleFunc("/api/v1/", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "Not found", fmt.Sprintf("No endpoint for %s %s", r.Method, r.URL.Path))
+	}))
+}
+
+// quarantineReviewHandler re-queues or dismisses the quarantined receipt in the path with the note in the body
+func quarantineReviewHandler(review func(id int64, actor string, note string) (*models.QuarantinedReceipt, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r, "Invalid quarantine ID")
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+
+		receipt, err := review(id, identityFrom(r).Name, body.Note)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			writeError(w, http.StatusNotFound, "Quarantined receipt not found", err.Error())
+		case errors.Is(err, models.ErrQuarantineConflict):
+			writeError(w, http.StatusConflict, "Receipt already reviewed", err.Error())
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "Failed to review quarantined receipt", err.Error())
+		default:
+			writeJSON(w, http.StatusOK, receipt)
+		}
+	}
+}
+
+// parsePage reads the page and page_size query parameters
+func parsePage(r *http.Request) (int, int, error) {
+	page, pageSize := 1, defaultPageSize
+
+	if value := r.URL.Query().Get("page"); value != "" {
+		var err error
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			return 0, 0, errors.New("page must be a positive integer")
+		}
+	}
+
+	if value := r.URL.Query().Get("page_size"); value != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(value); err != nil || pageSize < 1 || pageSize > maxPageSize {
+			return 0, 0, fmt.Errorf("page_size must be between 1 and %d", maxPageSize)
+		}
+	}
+
+	return page, pageSize, nil
+}
+
+// parseTime reads an optional RFC 3339 query parameter
+func parseTime(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// splitList splits a comma separated query parameter, dropping empty values
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// pathID reads the numeric id path value, writing a 400 response when it is invalid
+func pathID(w http.ResponseWriter, r *http.Request, message string) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, message, err.Error())
+		return 0, false
+	}
+	return id, true
+}
+
+// workerPathID reads the worker_id path value, writing a 400 response when it is not a UUID
+func workerPathID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	workerID := r.PathValue("worker_id")
+	if _, err := uuid.Parse(workerID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid worker ID", err.Error())
+		return "", false
+	}
+	return workerID, true
+}
+
+// writeLookupError writes a 404 response for missing records and a 500 response otherwise
+func writeLookupError(w http.ResponseWriter, err error, notFound string, failed string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, notFound, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, failed, err.Error())
+}
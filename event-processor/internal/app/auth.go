@@ -0,0 +1,193 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+)
+
+const sessionCookieName = "manager_session"
+
+type identityContextKey struct{}
+
+// authenticate resolves the caller from an API key header or a session cookie, nil when anonymous
+func authenticate(auth *services.AuthService, r *http.Request) *services.Identity {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			key = bearer
+		}
+	}
+	if key != "" {
+		return auth.Authenticate(key)
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+	identity, err := auth.VerifySession(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	return identity
+}
+
+// identityFrom returns the caller stored on the request by requireRole
+func identityFrom(r *http.Request) *services.Identity {
+	identity, _ := r.Context().Value(identityContextKey{}).(*services.Identity)
+	return identity
+}
+
+// originAllowed accepts requests without an Origin header, same-origin requests and the configured origins
+func originAllowed(auth *services.AuthService, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return auth.OriginAllowed(origin)
+}
+
+// isMutation reports whether a request changes state and is audited
+func isMutation(r *http.Request) bool {
+	return r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+}
+
+// statusRecorder keeps the status code written by a handler for the audit log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// requireRole rejects callers without at least the given role and audits their mutations
+func requireRole(auth *services.AuthService, role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity := authenticate(auth, r)
+		if identity == nil {
+			writeError(w, http.StatusUnauthorized, "Unauthorized", "A valid API key or session is required")
+			return
+		}
+
+		if isMutation(r) {
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			w = recorder
+			defer func() {
+				auth.RecordAudit(&models.AuditLog{
+					Actor:      identity.Name,
+					Role:       identity.Role,
+					AuthMethod: identity.AuthMethod,
+					Method:     r.Method,
+					Path:       r.URL.Path,
+					StatusCode: recorder.status,
+					RemoteAddr: r.RemoteAddr,
+				})
+			}()
+
+			// Browsers send the session cookie along with cross-site requests, API keys are never sent implicitly
+			if identity.AuthMethod == "session" && (r.Header.Get("Origin") == "" || !originAllowed(auth, r)) {
+				writeError(w, http.StatusForbidden, "Forbidden", "The request origin is not allowed")
+				return
+			}
+		}
+
+		if !identity.HasRole(role) {
+			writeError(w, http.StatusForbidden, "Forbidden", "The "+role+" role is required")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity)))
+	}
+}
+
+// registerAuthRoutes registers the dashboard login and logout endpoints
+func registerAuthRoutes(auth *services.AuthService) {
+	// Exchanges an API key for a session cookie, accepts a form post or a JSON body
+	http.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		// A cross-site form could otherwise log the browser into an attacker's session
+		if !originAllowed(auth, r) {
+			writeError(w, http.StatusForbidden, "Forbidden", "The request origin is not allowed")
+			return
+		}
+
+		key := r.PostFormValue("api_key")
+		fromForm := key != ""
+		if !fromForm && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			var body struct {
+				ApiKey string `json:"api_key"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+				return
+			}
+			key = body.ApiKey
+		}
+
+		identity := auth.Authenticate(key)
+		if identity == nil {
+			writeError(w, http.StatusUnauthorized, "Unauthorized", "Invalid API key")
+			return
+		}
+
+		token, expiresAt, err := auth.IssueSession(identity)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to create session", err.Error())
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    token,
+			Path:     "/",
+			Expires:  expiresAt,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+
+		if fromForm {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		writeJSON(w, http.StatusOK, identity)
+	})
+
+	http.HandleFunc("POST /logout", func(w http.ResponseWriter, r *http.Request) {
+		if !originAllowed(auth, r) {
+			writeError(w, http.StatusForbidden, "Forbidden", "The request origin is not allowed")
+			return
+		}
+
+		// Revoke the session itself, clearing the cookie alone leaves copies of the token valid until they expire
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			if err := auth.RevokeSession(cookie.Value); err != nil && !errors.Is(err, services.ErrInvalidSession) {
+				writeError(w, http.StatusInternalServerError, "Failed to revoke session", err.Error())
+				return
+			}
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+}
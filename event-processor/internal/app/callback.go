@@ -4,6 +4,7 @@ import (
 	"log"
 
 	"event-processor/internal/config"
+	"event-processor/internal/models"
 	"event-processor/internal/rabbitmq"
 	"event-processor/internal/services"
 )
@@ -17,7 +18,7 @@ func StartCallbackApp() error {
 		defer ch.Close()
 
 		// Queue to consume messages from
-		queue := "subscription_events"
+		queue := models.SubscriptionEventsQueue
 		log.Printf("Listening to queue: %s", queue)
 
 		// Start consuming messages
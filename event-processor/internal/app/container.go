@@ -3,6 +3,8 @@ package app
 import (
 	"event-processor/internal/config"
 	"event-processor/internal/models"
+	"event-processor/internal/pgnotify"
+	"event-processor/internal/rabbitmq"
 	"event-processor/internal/services"
 
 	"go.uber.org/dig"
@@ -16,12 +18,35 @@ func BuildContainer() *dig.Container {
 	container.Provide(models.NewWebhookRepository)
 	container.Provide(services.NewWebhookService)
 	container.Provide(models.NewManagerActionRepository)
+	container.Provide(models.NewActionEventRepository)
 	container.Provide(models.NewBatchRepository)
 	container.Provide(services.NewWorkerManagerService)
 	container.Provide(models.NewSubscriptionRepository)
 	container.Provide(models.NewWorkerRepository)
+	container.Provide(models.NewWorkerCommandRepository)
+	container.Provide(models.NewWorkerEventRepository)
+	container.Provide(models.NewSubscriptionFailureRepository)
+	container.Provide(models.NewSubscriptionHistoryRepository)
+	container.Provide(models.NewValidationWindowRepository)
+	container.Provide(models.NewReconciliationRepository)
 	container.Provide(services.NewWorkerService)
 	container.Provide(services.NewStoreApiService)
+	container.Provide(services.NewRenewalJob)
+	container.Provide(services.NewReconciliationJob)
+	container.Provide(models.NewOutboxRepository)
+	container.Provide(rabbitmq.NewPublisher)
+	container.Provide(services.NewOutboxRelay)
+	container.Provide(services.NewJobRegistry)
+	container.Provide(models.NewScheduleRepository)
+	container.Provide(services.NewSchedulerService)
+	container.Provide(models.NewLeaderLeaseRepository)
+	container.Provide(services.NewLeaderService)
+	container.Provide(models.NewAuditLogRepository)
+	container.Provide(models.NewRevokedSessionRepository)
+	container.Provide(services.NewAuthService)
+	container.Provide(pgnotify.NewListener)
+	container.Provide(services.NewDashboardHub)
+	container.Provide(services.NewScalingService)
 
 	return container
 }
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"event-processor/internal/services"
+)
+
+// registerScalingRoutes registers the autoscaling signal as Prometheus gauges and as JSON for the KEDA metrics-api scaler
+func registerScalingRoutes(scaling *services.ScalingService, auth *services.AuthService) {
+	http.HandleFunc("GET /metrics", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		signal, err := scaling.GetScalingSignal()
+		if err != nil {
+			http.Error(w, "Failed to calculate scaling signal: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(formatScalingMetrics(signal)))
+	}))
+
+	http.HandleFunc("GET /api/v1/scaling", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		signal, err := scaling.GetScalingSignal()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to calculate scaling signal", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, signal)
+	}))
+}
+
+// formatScalingMetrics writes the scaling signal in the Prometheus text exposition format
+func formatScalingMetrics(signal *services.ScalingSignal) string {
+	var b strings.Builder
+	gauge := func(name string, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP subscription_manager_%s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE subscription_manager_%s gauge\n", name)
+		fmt.Fprintf(&b, "subscription_manager_%s %g\n", name, value)
+	}
+
+	gauge("pending_batches", "Pending batches of actions that are not paused.", float64(signal.PendingBatches))
+	gauge("processing_batches", "Batches locked by a worker.", float64(signal.ProcessingBatches))
+	gauge("active_workers", "Running workers, including paused and draining ones.", float64(signal.ActiveWorkers))
+	gauge("worker_slots", "Batches the idle and processing workers can run at once.", float64(signal.WorkerSlots))
+	gauge("average_batch_duration_seconds", "Average duration of recently completed batches.", signal.AverageBatchSeconds)
+	gauge("backlog_duration_seconds", "Estimated time to finish the backlog with the current worker slots.", signal.BacklogSeconds)
+	gauge("desired_workers", "Workers needed to finish the backlog within the target time.", float64(signal.DesiredWorkers))
+	gauge("min_workers", "Lower bound of the desired worker count.", float64(signal.MinWorkers))
+	gauge("max_workers", "Upper bound of the desired worker count.", float64(signal.MaxWorkers))
+
+	return b.String()
+}
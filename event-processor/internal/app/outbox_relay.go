@@ -0,0 +1,29 @@
+package app
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"event-processor/internal/pgnotify"
+	"event-processor/internal/services"
+)
+
+func StartOutboxRelayApp() error {
+	container := BuildContainer()
+
+	err := container.Invoke(func(relay *services.OutboxRelay, listener *pgnotify.Listener) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// Listen for outbox notifications so new messages are relayed immediately
+		go listener.Run(ctx)
+
+		log.Println("Starting outbox relay...")
+		relay.Run(ctx)
+	})
+
+	return err
+}
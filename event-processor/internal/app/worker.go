@@ -1,8 +1,10 @@
 package app
 
 import (
+	"context"
 	"log"
 
+	"event-processor/internal/pgnotify"
 	"event-processor/internal/services"
 )
 
@@ -10,7 +12,10 @@ func StartWorkerApp() error {
 	container := BuildContainer()
 
 	// Invoke the application logic
-	err := container.Invoke(func(workerService *services.WorkerService) {
+	err := container.Invoke(func(workerService *services.WorkerService, listener *pgnotify.Listener) {
+		// Listen for batch notifications so idle workers wake up immediately
+		go listener.Run(context.Background())
+
 		// Start the worker service
 		log.Println("Starting worker service...")
 		workerService.Start()
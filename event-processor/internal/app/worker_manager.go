@@ -3,54 +3,95 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"event-processor/internal/pgnotify"
 	"event-processor/internal/services"
 
 	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
-
 func StartWorkerManagerApp() error {
 	container := BuildContainer()
 
-	container.Invoke(func(workerManagerService *services.WorkerManagerService) {
+	container.Invoke(func(workerManagerService *services.WorkerManagerService, schedulerService *services.SchedulerService, leaderService *services.LeaderService, dashboardHub *services.DashboardHub, listener *pgnotify.Listener) {
 		ctx := context.Background()
-		go workerManagerService.Heartbeat(ctx, 5*time.Second)
+
+		// Every replica pushes changes to its own dashboard clients
+		go listener.Run(ctx)
+		go dashboardHub.Run(ctx)
+
+		// Only the elected replica runs the heartbeat and the scheduler
+		go leaderService.Run(ctx, func(leaderCtx context.Context) {
+			go workerManagerService.Heartbeat(leaderCtx, 5*time.Second)
+			go workerManagerService.PruneWorkers(leaderCtx, 10*time.Minute)
+			go workerManagerService.MaintainHistoryPartitions(leaderCtx, 24*time.Hour)
+
+			if err := schedulerService.SyncConfiguredSchedules(); err != nil {
+				log.Printf("Failed to sync configured schedules: %v", err)
+			}
+			go schedulerService.Start(leaderCtx, 30*time.Second)
+		})
 	})
 
 	return container.Invoke(listenHttp)
 }
 
-func listenHttp(config *config.Config, service *services.WorkerManagerService) {
-	// Dynamically render the HTML file
+func listenHttp(config *config.Config, service *services.WorkerManagerService, schedulerService *services.SchedulerService, leaderService *services.LeaderService, auth *services.AuthService, dashboardHub *services.DashboardHub, scaling *services.ScalingService) {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return originAllowed(auth, r) },
+	}
+
+	// Dynamically render the HTML file, anonymous visitors get the login page
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		identity := authenticate(auth, r)
+		if identity == nil {
+			http.ServeFile(w, r, "./static/login.html")
+			return
+		}
+
 		tmpl, err := template.ParseFiles("./static/index.html")
 		if err != nil {
 			http.Error(w, "Could not load template", http.StatusInternalServerError)
 			return
 		}
 
-		// Inject the WebSocket URL dynamically
+		// Inject the WebSocket URL and the caller dynamically
 		data := struct {
 			WebSocketURL string
+			Identity     *services.Identity
 		}{
 			WebSocketURL: fmt.Sprintf("ws://%s:%d/ws", config.ManagerHost, config.ManagerPort),
+			Identity:     identity,
 		}
 
 		tmpl.Execute(w, data)
 	})
 
-	// WebSocket endpoint
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+	registerAuthRoutes(auth)
+
+	// WebSocket endpoint, pushes a snapshot and then deltas of all active actions or of the action_id query parameter.
+	// Clients switch with a {"subscribe": <action_id>} message, 0 for all active actions.
+	http.HandleFunc("/ws", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		var actionID int64
+		if value := r.URL.Query().Get("action_id"); value != "" {
+			var err error
+			if actionID, err = strconv.ParseInt(value, 10, 64); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid action ID", err.Error())
+				return
+			}
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Println("WebSocket Upgrade Error:", err)
@@ -58,61 +99,268 @@ func listenHttp(config *config.Config, service *services.WorkerManagerService) {
 		}
 		defer conn.Close()
 
-		for {
-			data := map[string]interface{}{}
-			actions, err := service.GetActiveActions()
-			if err == nil {
-				data["actions"] = actions
-			}
+		subscriber, err := dashboardHub.Subscribe(actionID)
+		if err != nil {
+			conn.WriteJSON(map[string]string{"type": "error", "error": "Failed to subscribe", "message": err.Error()})
+			return
+		}
+		defer func() { dashboardHub.Unsubscribe(subscriber) }()
 
-			workers, err := service.GetActiveWorkers()
-			if err == nil {
-				data["workers"] = workers
+		// Read subscription changes until the client goes away
+		done := make(chan struct{})
+		defer close(done)
+		requests := make(chan int64)
+		go func() {
+			defer close(requests)
+			for {
+				var request struct {
+					Subscribe *int64 `json:"subscribe"`
+				}
+				if err := conn.ReadJSON(&request); err != nil {
+					return
+				}
+				if request.Subscribe == nil {
+					continue
+				}
+				select {
+				case requests <- *request.Subscribe:
+				case <-done:
+					return
+				}
 			}
+		}()
 
-			err = conn.WriteJSON(data)
-			if err != nil {
-				log.Println("WebSocket Write Error:", err)
-				break
-			}
+		for {
+			select {
+			case message, ok := <-subscriber.Messages():
+				if !ok {
+					log.Println("WebSocket client fell behind, closing the connection")
+					return
+				}
+				if err := conn.WriteJSON(message); err != nil {
+					log.Println("WebSocket Write Error:", err)
+					return
+				}
+
+			case requested, ok := <-requests:
+				if !ok {
+					return
+				}
 
-			// Publish data every 5 seconds
-			time.Sleep(5 * time.Second)
+				// Keep the current subscription when the requested action cannot be loaded
+				next, err := dashboardHub.Subscribe(requested)
+				if err != nil {
+					conn.WriteJSON(map[string]string{"type": "error", "error": "Failed to subscribe", "message": err.Error()})
+					continue
+				}
+				dashboardHub.Unsubscribe(subscriber)
+				subscriber = next
+			}
 		}
-	})
+	}))
 
 	// Trigger endpoint
-	http.HandleFunc("/trigger", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/trigger", requireRole(auth, services.RoleOperator, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error":   "Invalid request method",
-				"message": "Only POST method is allowed",
-			})
+			writeError(w, http.StatusMethodNotAllowed, "Invalid request method", "Only POST method is allowed")
+			return
+		}
+
+		// Actions are only created by the leader, followers serve read endpoints
+		if !leaderService.IsLeader() {
+			writeError(w, http.StatusServiceUnavailable, "Not the leader", "This worker manager replica is a follower, retry against the leader.")
+			return
+		}
+
+		// The body is optional, an empty trigger runs a renewal sweep over every expired subscription
+		var body struct {
+			Type   string                     `json:"type"`
+			Params models.JSON                `json:"params"`
+			Filter *models.SubscriptionFilter `json:"filter"` // Shorthand for the params of a renewal sweep
+			// Labels workers need to claim the batches, added to those required by the job type
+			RequiredLabels models.Labels `json:"required_labels"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err.Error())
 			return
 		}
+		if body.Type == "" {
+			body.Type = services.JobTypeRenewalSweep
+		}
+		if body.Filter != nil {
+			if body.Type != services.JobTypeRenewalSweep || len(body.Params) > 0 {
+				writeError(w, http.StatusBadRequest, "Invalid request body", "filter can only be given for a renewal sweep without params")
+				return
+			}
+			body.Params, _ = json.Marshal(body.Filter)
+		}
 
-		err := service.HandleTrigger()
+		action, err := service.TriggerAction(body.Type, body.Params, body.RequiredLabels)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			status := http.StatusInternalServerError
+			if errors.Is(err, services.ErrInvalidJobParams) {
+				status = http.StatusBadRequest
+			}
+			writeError(w, status, "Failed to handle trigger", err.Error())
+			return
+		}
+
+		if action == nil {
+			w.WriteHeader(http.StatusOK)
 			json.NewEncoder(w).Encode(map[string]string{
-				"error":   "Failed to handle trigger",
-				"message": err.Error(),
+				"status":  "skipped",
+				"message": "An active action of this type already exists.",
 			})
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"status":  "success",
-			"message": "Worker Manager triggered successfully.",
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "success",
+			"message":   "Worker Manager triggered successfully.",
+			"action_id": action.ID,
 		})
-	})
+	}))
+
+	// Versioned REST API
+	registerApiV1(service, auth)
+	registerScalingRoutes(scaling, auth)
+
+	// Leader endpoint
+	http.HandleFunc("/leader", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		lease, err := leaderService.GetLeader()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch leader", err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"instance_id": leaderService.InstanceID(),
+			"is_leader":   leaderService.IsLeader(),
+			"lease":       lease,
+		})
+	}))
+
+	// Schedule endpoints
+	http.HandleFunc("/schedules", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			schedules, err := schedulerService.GetSchedules()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "Failed to fetch schedules", err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, schedules)
+
+		case http.MethodPost:
+			if !identityFrom(r).HasRole(services.RoleAdmin) {
+				writeError(w, http.StatusForbidden, "Forbidden", "The admin role is required")
+				return
+			}
+
+			var body struct {
+				Name     string      `json:"name"`
+				JobType  string      `json:"job_type"`
+				CronExpr string      `json:"cron_expr"`
+				RunAt    *time.Time  `json:"run_at"`
+				Params   models.JSON `json:"params"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+				return
+			}
+			if body.JobType == "" {
+				body.JobType = services.JobTypeRenewalSweep
+			}
+
+			var schedule *models.Schedule
+			var err error
+			switch {
+			case body.CronExpr != "" && body.RunAt != nil:
+				err = errors.New("only one of cron_expr and run_at can be given")
+			case body.CronExpr != "":
+				schedule, err = schedulerService.CreateCronSchedule(body.Name, body.JobType, body.CronExpr, body.Params)
+			case body.RunAt != nil:
+				schedule, err = schedulerService.ScheduleOnce(body.JobType, *body.RunAt, body.Params)
+			default:
+				err = errors.New("either cron_expr or run_at is required")
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Failed to create schedule", err.Error())
+				return
+			}
+			writeJSON(w, http.StatusCreated, schedule)
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Invalid request method", "Only GET and POST methods are allowed")
+		}
+	}))
+
+	http.HandleFunc("/schedules/runs", requireRole(auth, services.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Invalid request method", "Only GET method is allowed")
+			return
+		}
+
+		runs, err := schedulerService.GetRecentRuns(100)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to fetch schedule runs", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, runs)
+	}))
 
 	// Start the server
 	port := fmt.Sprintf(":%d", config.ManagerPort)
 	log.Println("Worker Manager is running on " + port)
 	log.Fatal(http.ListenAndServe(port, nil))
 }
+
+// actionStatusHandler handles a pause, resume or cancel request for the action in the path.
+// It runs behind requireRole, which provides the actor.
+func actionStatusHandler(change func(actionID int64, actor string, reason string) (*models.ManagerAction, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actionID, ok := pathID(w, r, "Invalid action ID")
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+
+		// The change is attributed to the authenticated caller
+		action, err := change(actionID, identityFrom(r).Name, body.Reason)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			writeError(w, http.StatusNotFound, "Action not found", err.Error())
+		case errors.Is(err, models.ErrActionStatusConflict):
+			writeError(w, http.StatusConflict, "Invalid action status", err.Error())
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "Failed to change action status", err.Error())
+		default:
+			writeJSON(w, http.StatusOK, action)
+		}
+	}
+}
+
+// writeError writes the error body shared by all endpoints
+func writeError(w http.ResponseWriter, status int, title string, message string) {
+	writeJSON(w, status, map[string]string{
+		"error":   title,
+		"message": message,
+	})
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -17,6 +17,41 @@ type Config struct {
 	RedisDB       int
 	ManagerPort   int
 	ManagerHost   string
+	// Schedules holds cron expressions per job type, e.g. "renewal_sweep=0 * * * *;reconciliation=0 3 * * *"
+	Schedules      string
+	LeaderLeaseTTL int // Seconds before a leader that stopped renewing is replaced
+	// ApiKeys holds the manager API keys as name:role:key entries, e.g. "alice:admin:s3cret,ci:operator:t0ken"
+	ApiKeys        string
+	SessionSecret  string // Signs dashboard session cookies, shared by all manager replicas
+	SessionTTL     int    // Minutes a dashboard session stays valid
+	AllowedOrigins string // Comma separated origins allowed to open the websocket and post with a session
+	// WorkerPollInterval is the number of seconds an idle worker waits for a wake-up notification before checking for batches anyway
+	WorkerPollInterval int
+	WorkerConcurrency  int // Number of batches a worker processes at once until changed by a command
+	// WorkerLabels describes what a worker can serve, e.g. "store=ios,region=eu-west-1,apps=1|2|3"
+	WorkerLabels  string
+	WorkerVersion string // Reported as the version label of the worker
+	// WorkerCheckpointInterval is the number of items after which a worker writes its updates and a batch checkpoint
+	WorkerCheckpointInterval int
+	// Failed attempts before a receipt is quarantined, and the seconds before its first retry, doubled for every further one
+	SubscriptionMaxAttempts  int
+	SubscriptionRetryBackoff int
+	// Seconds without a heartbeat before the manager marks a worker stale
+	WorkerStaleTimeout int
+	// Hours stopped and stale workers are kept, and days their status history is kept
+	WorkerRetentionHours     int
+	WorkerEventRetentionDays int
+	// Messages an outbox relay publishes per round, the seconds it waits for a notification before
+	// looking anyway, the seconds before a failed message is retried, doubled for every further
+	// attempt, and the hours sent messages are kept
+	OutboxBatchSize      int
+	OutboxPollInterval   int
+	OutboxRetryBackoff   int
+	OutboxRetentionHours int
+	// Bounds of the desired worker count and the time the backlog should be finished in
+	ScalingMinWorkers    int
+	ScalingMaxWorkers    int
+	ScalingTargetSeconds int
 }
 
 // TODO: check required configs
@@ -29,6 +64,36 @@ func LoadConfig() *Config {
 		StoreApiHost: getEnv("STORE_API_HOST", "http://localhost:8080"),
 		ManagerPort:  getEnvAsInt("MANAGER_PORT", 9090),
 		ManagerHost:  getEnv("MANAGER_HOST", "localhost"),
+
+		Schedules:      getEnv("MANAGER_SCHEDULES", ""),
+		LeaderLeaseTTL: getEnvAsInt("LEADER_LEASE_TTL", 10),
+
+		ApiKeys:        getEnv("MANAGER_API_KEYS", ""),
+		SessionSecret:  getEnv("MANAGER_SESSION_SECRET", ""),
+		SessionTTL:     getEnvAsInt("MANAGER_SESSION_TTL", 720),
+		AllowedOrigins: getEnv("MANAGER_ALLOWED_ORIGINS", ""),
+
+		WorkerPollInterval: getEnvAsInt("WORKER_POLL_INTERVAL", 60),
+		WorkerConcurrency:  getEnvAsInt("WORKER_CONCURRENCY", 1),
+		WorkerLabels:       getEnv("WORKER_LABELS", ""),
+		WorkerVersion:      getEnv("WORKER_VERSION", "dev"),
+
+		WorkerCheckpointInterval: getEnvAsInt("WORKER_CHECKPOINT_INTERVAL", 100),
+		SubscriptionMaxAttempts:  getEnvAsInt("SUBSCRIPTION_MAX_ATTEMPTS", 5),
+		SubscriptionRetryBackoff: getEnvAsInt("SUBSCRIPTION_RETRY_BACKOFF", 300),
+
+		WorkerStaleTimeout:       getEnvAsInt("WORKER_STALE_TIMEOUT", 60),
+		WorkerRetentionHours:     getEnvAsInt("WORKER_RETENTION_HOURS", 24),
+		WorkerEventRetentionDays: getEnvAsInt("WORKER_EVENT_RETENTION_DAYS", 30),
+
+		OutboxBatchSize:      getEnvAsInt("OUTBOX_BATCH_SIZE", 100),
+		OutboxPollInterval:   getEnvAsInt("OUTBOX_POLL_INTERVAL", 5),
+		OutboxRetryBackoff:   getEnvAsInt("OUTBOX_RETRY_BACKOFF", 5),
+		OutboxRetentionHours: getEnvAsInt("OUTBOX_RETENTION_HOURS", 24),
+
+		ScalingMinWorkers:    getEnvAsInt("SCALING_MIN_WORKERS", 1),
+		ScalingMaxWorkers:    getEnvAsInt("SCALING_MAX_WORKERS", 10),
+		ScalingTargetSeconds: getEnvAsInt("SCALING_TARGET_SECONDS", 300),
 	}
 }
 
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ActionEvent records a status change of a manager action, made by a user or by the manager
+type ActionEvent struct {
+	ID         int64     `gorm:"primaryKey" json:"id"`
+	ActionID   int64     `gorm:"not null" json:"action_id"`                   // Related action ID
+	FromStatus string    `gorm:"size:20;not null" json:"from_status"`         // Status before the change
+	ToStatus   string    `gorm:"size:20;not null" json:"to_status"`           // Status after the change
+	Actor      string    `gorm:"size:255;not null" json:"actor"`              // Who made the change
+	Reason     string    `gorm:"type:text;not null;default:''" json:"reason"` // Why the change was made
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`            // Creation timestamp
+}
+
+// ActionEventRepository manages action event database operations
+type ActionEventRepository struct {
+	db *gorm.DB
+}
+
+// NewActionEventRepository creates a new instance of ActionEventRepository
+func NewActionEventRepository(db *gorm.DB) *ActionEventRepository {
+	return &ActionEventRepository{db: db}
+}
+
+// GetEventsByActionID fetches the status history of an action, oldest first
+func (r *ActionEventRepository) GetEventsByActionID(actionID int64) ([]ActionEvent, error) {
+	var events []ActionEvent
+	err := r.db.Where("action_id = ?", actionID).
+		Order("id ASC").
+		Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditLog records an authenticated mutation on the manager HTTP server
+type AuditLog struct {
+	ID         int64     `gorm:"primaryKey" json:"id"`
+	Actor      string    `gorm:"size:255;not null" json:"actor"`       // Name of the API key or session
+	Role       string    `gorm:"size:20;not null" json:"role"`         // Role of the actor at the time of the request
+	AuthMethod string    `gorm:"size:20;not null" json:"auth_method"`  // "api_key" or "session"
+	Method     string    `gorm:"size:10;not null" json:"method"`       // HTTP method
+	Path       string    `gorm:"size:1024;not null" json:"path"`       // Request path
+	StatusCode int       `gorm:"not null" json:"status_code"`          // Response status code
+	RemoteAddr string    `gorm:"size:255;not null" json:"remote_addr"` // Client address
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`     // Creation timestamp
+}
+
+// AuditLogRepository manages audit log database operations
+type AuditLogRepository struct {
+	db *gorm.DB
+}
+
+// NewAuditLogRepository creates a new instance of AuditLogRepository
+func NewAuditLogRepository(db *gorm.DB) *AuditLogRepository {
+	return &AuditLogRepository{db: db}
+}
+
+func (r *AuditLogRepository) CreateAuditLog(entry *AuditLog) error {
+	return r.db.Create(entry).Error
+}
+
+// ListAuditLogs fetches a page of audit records, newest first, and the total count
+func (r *AuditLogRepository) ListAuditLogs(actor string, limit int, offset int) ([]AuditLog, int64, error) {
+	query := r.db.Model(&AuditLog{})
+	if actor != "" {
+		query = query.Where("actor = ?", actor)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []AuditLog
+	err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&entries).Error
+	return entries, total, err
+}
@@ -1,23 +1,127 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Batch struct {
-	ID         int64      `gorm:"primaryKey" json:"id"`             // Primary key
-	ActionID   int64      `gorm:"not null" json:"action_id"`        // Related action ID
-	StartIndex int64      `gorm:"not null" json:"start_index"`      // Start index for the batch
-	EndIndex   int64      `gorm:"not null" json:"end_index"`        // End index for the batch
-	Status     string     `gorm:"default:pending" json:"status"`    // Batch status
-	TryCount   int        `gorm:"not null" json:"try_count"`        // Number of processing attempts
-	LockedBy   *string    `gorm:"default:null" json:"locked_by"`    // Worker that locked this batch
-	LockedAt   *time.Time `gorm:"default:null" json:"locked_at"`    // When the batch was locked
-	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"` // Creation timestamp
-	UpdatedAt  time.Time  `gorm:"autoUpdateTime" json:"updated_at"` // Update timestamp
+	ID         int64      `gorm:"primaryKey" json:"id"`                // Primary key
+	ActionID   int64      `gorm:"not null" json:"action_id"`           // Related action ID
+	StartIndex int64      `gorm:"not null" json:"start_index"`         // Start index for the batch
+	EndIndex   int64      `gorm:"not null" json:"end_index"`           // End index for the batch
+	AfterKey   int64      `gorm:"not null;default:0" json:"after_key"` // Items of the batch follow this key, 0 for the first batch
+	EndKey     int64      `gorm:"not null;default:0" json:"end_key"`   // Items of the batch end with this key, 0 for the last batch
+	Status     string     `gorm:"default:pending" json:"status"`       // Batch status
+	TryCount   int        `gorm:"not null" json:"try_count"`           // Number of processing attempts
+	LockedBy   *string    `gorm:"default:null" json:"locked_by"`       // Worker that locked this batch
+	LockedAt   *time.Time `gorm:"default:null" json:"locked_at"`       // When the batch was locked
+	WorkerID   *string    `gorm:"default:null" json:"worker_id"`       // Worker that last processed this batch, kept after unlocking
+	// Labels a worker needs to process this batch, empty when any worker can
+	RequiredLabels Labels     `gorm:"type:jsonb;not null;default:'{}'" json:"required_labels"`
+	StartedAt      *time.Time `gorm:"default:null" json:"started_at"`  // When the batch was first locked
+	FinishedAt     *time.Time `gorm:"default:null" json:"finished_at"` // When the batch was completed, canceled or marked stale
+	// Progress of the attempts so far, a retry resumes from it
+	Checkpoint *BatchCheckpoint `gorm:"type:jsonb;default:null" json:"checkpoint"`
+	BatchResult
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"` // Creation timestamp
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"` // Update timestamp
+}
+
+// Size returns the number of items covered by the batch
+func (b Batch) Size() int64 {
+	return b.EndIndex - b.StartIndex + 1
+}
+
+// maxBatchTries is the number of failed attempts after which a batch is marked stale instead of retried
+const maxBatchTries = 5
+
+// ErrBatchLockLost is returned when a worker writes to a batch that was reclaimed from it
+var ErrBatchLockLost = errors.New("batch is no longer locked by this worker")
+
+// BatchResult holds the outcome counters of a batch, reported by the worker after every attempt
+type BatchResult struct {
+	ProcessedCount int64 `gorm:"not null;default:0" json:"processed_count"` // Items looked at
+	RenewedCount   int64 `gorm:"not null;default:0" json:"renewed_count"`   // Items renewed
+	ExpiredCount   int64 `gorm:"not null;default:0" json:"expired_count"`   // Items expired
+	FailedCount    int64 `gorm:"not null;default:0" json:"failed_count"`    // Items that could not be processed
+	SkippedCount   int64 `gorm:"not null;default:0" json:"skipped_count"`   // Items left untouched
+	ConflictCount  int64 `gorm:"not null;default:0" json:"conflict_count"`  // Updates skipped because the subscription changed meanwhile
+}
+
+// CountUpdate counts an update to the status as renewed when the status grants access and as expired otherwise
+func (r *BatchResult) CountUpdate(status SubscriptionStatus) {
+	if status.Entitled() {
+		r.RenewedCount++
+	} else {
+		r.ExpiredCount++
+	}
+}
+
+// countConflict moves an update counted by CountUpdate to the conflict counter
+func (r *BatchResult) countConflict(status SubscriptionStatus) {
+	if status.Entitled() {
+		r.RenewedCount--
+	} else {
+		r.ExpiredCount--
+	}
+	r.ConflictCount++
+}
+
+// BatchTotals aggregates the batches of an action
+type BatchTotals struct {
+	BatchResult
+	CompletedBatchCount  int64
+	StaleBatchCount      int64
+	CanceledBatchCount   int64
+	UnfinishedBatchCount int64 // Pending or processing batches
+}
+
+// BatchRange is the inclusive range of items covered by a batch
+type BatchRange struct {
+	Start    int64
+	End      int64
+	AfterKey int64 // Key of the item before Start, set by jobs that read their items by key
+	EndKey   int64 // Key of the item at End, 0 when the range reads up to its size
+}
+
+// SplitRange divides items 1..total into consecutive ranges of at most batchSize items
+func SplitRange(total int64, batchSize int64) []BatchRange {
+	var ranges []BatchRange
+	if batchSize <= 0 {
+		return ranges
+	}
+
+	for start := int64(1); start <= total; start += batchSize {
+		end := start + batchSize - 1
+		if end > total {
+			end = total
+		}
+		ranges = append(ranges, BatchRange{Start: start, End: end})
+	}
+	return ranges
+}
+
+// SplitKeyedRange divides items 1..total into ranges like SplitRange, ends every range but the last with its
+// key and starts every range after the key that ends the previous one, keys holding the last key of every
+// range but the last. When items went away since they were counted there are fewer keys, and the ranges
+// without a key are dropped.
+func SplitKeyedRange(total int64, batchSize int64, keys []int64) []BatchRange {
+	ranges := SplitRange(total, batchSize)
+	if len(keys) < len(ranges)-1 {
+		log.Printf("Only %d of %d batches left to split", len(keys)+1, len(ranges))
+		ranges = ranges[:len(keys)+1]
+	}
+	for i := 0; i < len(ranges)-1; i++ {
+		ranges[i].EndKey = keys[i]
+		ranges[i+1].AfterKey = keys[i]
+	}
+	return ranges
 }
 
 func NewBatchRepository(db *gorm.DB) *BatchRepository {
@@ -28,27 +132,31 @@ type BatchRepository struct {
 	db *gorm.DB
 }
 
-func (r *BatchRepository) CreateBatches(actionID int64, batchSize int64, totalRecords int64) error {
+// CreateBatches inserts the pending batches of an action, requiredLabels restricts which workers may claim them
+func (r *BatchRepository) CreateBatches(actionID int64, ranges []BatchRange, requiredLabels Labels) error {
+	return createBatches(r.db, actionID, ranges, requiredLabels)
+}
+
+// createBatches inserts the pending batches of an action with tx
+func createBatches(tx *gorm.DB, actionID int64, ranges []BatchRange, requiredLabels Labels) error {
 	var batches []Batch
 
 	// Prepare batches for bulk insert
-	for start := int64(1); start <= totalRecords; start += batchSize {
-		end := start + batchSize - 1
-		if end > totalRecords {
-			end = totalRecords
-		}
-
+	for _, rng := range ranges {
 		batches = append(batches, Batch{
-			ActionID:   actionID,
-			StartIndex: start,
-			EndIndex:   end,
-			Status:     "pending",
+			ActionID:       actionID,
+			StartIndex:     rng.Start,
+			EndIndex:       rng.End,
+			AfterKey:       rng.AfterKey,
+			EndKey:         rng.EndKey,
+			Status:         "pending",
+			RequiredLabels: requiredLabels,
 		})
 	}
 
 	// Perform bulk insert
 	if len(batches) > 0 {
-		if err := r.db.Create(&batches).Error; err != nil {
+		if err := tx.Create(&batches).Error; err != nil {
 			return err
 		}
 	}
@@ -59,13 +167,14 @@ func (r *BatchRepository) CreateBatches(actionID int64, batchSize int64, totalRe
 
 func (r *BatchRepository) GetBatchesByActionIDs(actionIDs []int64) ([]Batch, error) {
 	var batches []Batch
-	query := r.db.Model(&Batch{})
-
-	if len(actionIDs) > 0 {
-		query = query.Where("action_id IN ?", actionIDs)
+	if len(actionIDs) == 0 {
+		return batches, nil
 	}
 
-	err := query.Find(&batches).Error
+	err := r.db.Model(&Batch{}).
+		Where("action_id IN ?", actionIDs).
+		Order("id ASC").
+		Find(&batches).Error
 	if err != nil {
 		return nil, err
 	}
@@ -73,11 +182,45 @@ func (r *BatchRepository) GetBatchesByActionIDs(actionIDs []int64) ([]Batch, err
 	return batches, nil
 }
 
-// LockNextBatch fetches and locks the next pending batch
-func (r *BatchRepository) LockNextBatch(workerID string) (*Batch, error) {
+// GetBatchByID fetches a batch by its ID
+func (r *BatchRepository) GetBatchByID(batchID int64) (*Batch, error) {
+	var batch Batch
+	err := r.db.First(&batch, batchID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// GetBatchesByIDs fetches the batches with the given IDs
+func (r *BatchRepository) GetBatchesByIDs(batchIDs []int64) ([]Batch, error) {
+	var batches []Batch
+	if len(batchIDs) == 0 {
+		return batches, nil
+	}
+	err := r.db.Where("id IN ?", batchIDs).Order("id ASC").Find(&batches).Error
+	return batches, err
+}
+
+// GetBatchesByWorkerID fetches the batches last processed by a worker, newest first
+func (r *BatchRepository) GetBatchesByWorkerID(workerID string, limit int) ([]Batch, error) {
+	var batches []Batch
+	err := r.db.Where("worker_id = ?", workerID).
+		Order("locked_at DESC NULLS LAST, id DESC").
+		Limit(limit).
+		Find(&batches).Error
+	return batches, err
+}
+
+// LockNextBatch fetches and locks the next pending batch of an action that is not paused or canceled
+// and whose required labels are all among the worker's labels. It returns nil when no batch is available.
+func (r *BatchRepository) LockNextBatch(workerID string, labels Labels) (*Batch, error) {
 	var batch Batch
 	err := r.db.Transaction(func(tx *gorm.DB) error {
-		err := tx.Where("status = ?", "pending").
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", "pending").
+			Where("required_labels <@ ?::jsonb", labels).
+			Where("action_id IN (?)", tx.Model(&ManagerAction{}).Select("id").Where("status IN ?", []string{"pending", "running"})).
 			Order("id ASC").
 			First(&batch).Error
 		if err != nil {
@@ -85,65 +228,274 @@ func (r *BatchRepository) LockNextBatch(workerID string) (*Batch, error) {
 		}
 
 		// Lock the batch
-		return tx.Model(&batch).
+		now := time.Now()
+		if batch.StartedAt == nil {
+			batch.StartedAt = &now
+		}
+		batch.Status, batch.LockedBy, batch.LockedAt, batch.WorkerID = "processing", &workerID, &now, &workerID
+		err = tx.Model(&batch).
 			Updates(map[string]interface{}{
-				"status":    "processing",
-				"locked_by": workerID,
-				"locked_at": time.Now(),
+				"status":     "processing",
+				"locked_by":  workerID,
+				"locked_at":  now,
+				"worker_id":  workerID,
+				"started_at": gorm.Expr("COALESCE(started_at, ?)", now),
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		// The first claimed batch starts the action
+		return tx.Model(&ManagerAction{}).
+			Where("id = ? AND status = ?", batch.ActionID, "pending").
+			Updates(map[string]interface{}{
+				"status":     "running",
+				"started_at": now,
 			}).Error
 	})
 	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil // No available batches
+		}
 		return nil, err
 	}
 	return &batch, nil
 }
 
-// MarkBatchCompleted marks a batch as completed
-func (r *BatchRepository) MarkBatchCompleted(batchID int64) error {
-	return r.db.Model(&Batch{}).
-		Where("id = ?", batchID).
+// MarkBatchCompleted marks a batch locked by the worker as completed, or returns ErrBatchLockLost
+// when the batch was reclaimed from it
+func (r *BatchRepository) MarkBatchCompleted(batchID int64, workerID string) error {
+	return r.finishLockedBatch(batchID, workerID, "completed")
+}
+
+// MarkBatchCanceled marks a batch of a canceled action locked by the worker as canceled, or returns
+// ErrBatchLockLost when the batch was reclaimed from it
+func (r *BatchRepository) MarkBatchCanceled(batchID int64, workerID string) error {
+	return r.finishLockedBatch(batchID, workerID, "canceled")
+}
+
+// finishLockedBatch moves a batch the worker still holds to a final status and releases its lock
+func (r *BatchRepository) finishLockedBatch(batchID int64, workerID string, status string) error {
+	result := r.db.Model(&Batch{}).
+		Where("id = ? AND status = ? AND locked_by = ?", batchID, "processing", workerID).
 		Updates(map[string]interface{}{
-			"status":    "completed",
-			"locked_by": nil,
-			"locked_at": nil,
-		}).Error
+			"status":      status,
+			"locked_by":   nil,
+			"locked_at":   nil,
+			"finished_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: batch %d, worker %s", ErrBatchLockLost, batchID, workerID)
+	}
+	return nil
 }
 
 func (r *BatchRepository) MarkBatchAsStale(batchID int64) error {
 	return r.db.Model(&Batch{}).
 		Where("id = ?", batchID).
 		Updates(map[string]interface{}{
-			"status":    "stale",
-			"locked_by": nil,
-			"locked_at": nil,
+			"status":      "stale",
+			"locked_by":   nil,
+			"locked_at":   nil,
+			"finished_at": time.Now(),
 		}).Error
 }
 
-func (r *BatchRepository) IncrementBatchTryCount(batchID int64) (*Batch, error) {
+// RecordBatchResult stores the outcome counters of the latest attempt of a batch locked by the worker,
+// or returns ErrBatchLockLost when the batch was reclaimed from it
+func (r *BatchRepository) RecordBatchResult(batchID int64, workerID string, result BatchResult) error {
+	updated := r.db.Model(&Batch{}).
+		Where("id = ? AND status = ? AND locked_by = ?", batchID, "processing", workerID).
+		Select("processed_count", "renewed_count", "expired_count", "failed_count", "skipped_count", "conflict_count").
+		Updates(&Batch{BatchResult: result})
+	if updated.Error != nil {
+		return updated.Error
+	}
+	if updated.RowsAffected == 0 {
+		return fmt.Errorf("%w: batch %d, worker %s", ErrBatchLockLost, batchID, workerID)
+	}
+	return nil
+}
+
+// SaveCheckpoint writes the subscription updates, their history and events and the failures of the items processed
+// since the last checkpoint together with the new checkpoint, so a retry neither repeats nor loses them. Updates of
+// subscriptions that changed since they were read are skipped and moved from the renewed or expired counter to the
+// conflict counter. It returns the saved checkpoint, or ErrBatchLockLost when the batch is no longer locked by the worker.
+func (r *BatchRepository) SaveCheckpoint(batchID int64, workerID string, checkpoint BatchCheckpoint, writes CheckpointWrites) (BatchCheckpoint, error) {
+	checkpoint.SavedAt = time.Now()
+	err := copyTransaction(r.db, func(tx *gorm.DB, copyFrom copyFromFunc) error {
+		conflicts, err := bulkUpdateSubscriptions(tx, copyFrom, writes.Updates)
+		if err != nil {
+			return err
+		}
+		for _, sub := range conflicts {
+			checkpoint.Result.countConflict(sub.Status)
+		}
+
+		// Written last so the counters include the conflicts, a lost lock rolls the updates back
+		result := tx.Model(&Batch{}).
+			Where("id = ? AND status = ? AND locked_by = ?", batchID, "processing", workerID).
+			Update("checkpoint", checkpoint)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: batch %d, worker %s", ErrBatchLockLost, batchID, workerID)
+		}
+
+		if err := recordTransitions(tx, withoutConflicts(writes.Events, conflicts)); err != nil {
+			return err
+		}
+		if err := recordDiscrepancies(tx, writes.Discrepancies, conflicts); err != nil {
+			return err
+		}
+		if err := clearFailures(tx, writes.Updates); err != nil {
+			return err
+		}
+		if err := refreshDue(tx, writes.Skipped); err != nil {
+			return err
+		}
+		return recordFailures(tx, writes.ActionID, writes.Failures, writes.Retry)
+	})
+	return checkpoint, err
+}
+
+// retryUpdates puts a processing batch back to pending for another attempt, or marks it stale
+// once it failed maxBatchTries times. The CASE expressions see the try count before the update.
+func retryUpdates() map[string]interface{} {
+	return map[string]interface{}{
+		"try_count":   gorm.Expr("try_count + 1"),
+		"status":      gorm.Expr("CASE WHEN try_count >= ? THEN 'stale' ELSE 'pending' END", maxBatchTries),
+		"finished_at": gorm.Expr("CASE WHEN try_count >= ? THEN NOW() ELSE NULL END", maxBatchTries),
+		"locked_by":   nil,
+		"locked_at":   nil,
+	}
+}
+
+// RetryBatch releases a failed batch locked by the worker so it can be claimed again, keeping its checkpoint.
+// After too many attempts the batch is marked stale instead. It returns the updated batch.
+func (r *BatchRepository) RetryBatch(batchID int64, workerID string) (*Batch, error) {
 	var batch Batch
+	result := r.db.Model(&batch).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND status = ? AND locked_by = ?", batchID, "processing", workerID).
+		Updates(retryUpdates())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: batch %d, worker %s", ErrBatchLockLost, batchID, workerID)
+	}
+	return &batch, nil
+}
+
+// ReleaseOrphanedBatches reclaims the batches locked by workers that went stale, stopped or were pruned,
+// so other workers resume them from their checkpoints. It returns the number of batches released.
+func (r *BatchRepository) ReleaseOrphanedBatches() (int64, error) {
+	running := r.db.Model(&Worker{}).
+		Select("1").
+		Where("workers.worker_id::text = batches.locked_by::text AND workers.status IN ?", ActiveWorkerStatuses)
+	result := r.db.Model(&Batch{}).
+		Where("status = ? AND locked_by IS NOT NULL", "processing").
+		Where("NOT EXISTS (?)", running).
+		Updates(retryUpdates())
+	return result.RowsAffected, result.Error
+}
+
+// GetBatchTotals sums the outcome counters and counts the batches of an action by status
+func (r *BatchRepository) GetBatchTotals(actionID int64) (*BatchTotals, error) {
+	var totals BatchTotals
 	err := r.db.Model(&Batch{}).
-		Where("id = ?", batchID).
-		UpdateColumn("try_count", gorm.Expr("try_count + ?", 1)).
-		First(&batch).Error // Retrieve the updated batch record
+		Select(`
+			COALESCE(SUM(processed_count), 0) AS processed_count,
+			COALESCE(SUM(renewed_count), 0) AS renewed_count,
+			COALESCE(SUM(expired_count), 0) AS expired_count,
+			COALESCE(SUM(failed_count), 0) AS failed_count,
+			COALESCE(SUM(skipped_count), 0) AS skipped_count,
+			COALESCE(SUM(conflict_count), 0) AS conflict_count,
+			COUNT(*) FILTER (WHERE status = 'completed') AS completed_batch_count,
+			COUNT(*) FILTER (WHERE status = 'stale') AS stale_batch_count,
+			COUNT(*) FILTER (WHERE status = 'canceled') AS canceled_batch_count,
+			COUNT(*) FILTER (WHERE status IN ('pending', 'processing')) AS unfinished_batch_count`).
+		Where("action_id = ?", actionID).
+		Scan(&totals).Error
 	if err != nil {
 		return nil, err
 	}
-	return &batch, nil
+	return &totals, nil
 }
 
-// AreAllBatchesCompleted checks if all batches for an action are completed
-func (r *BatchRepository) AreAllBatchesFinish(actionID int64) (bool, error) {
+// GetProcessedCountSince sums the items processed by batches of an action that finished after since
+func (r *BatchRepository) GetProcessedCountSince(actionID int64, since time.Time) (int64, error) {
 	var count int64
 	err := r.db.Model(&Batch{}).
-		Where("action_id = ? AND status IN ('pending', 'processing')", actionID).
-		Count(&count).Error
-	return count == 0, err
+		Select("COALESCE(SUM(processed_count), 0)").
+		Where("action_id = ? AND finished_at > ?", actionID, since).
+		Scan(&count).Error
+	return count, err
 }
 
-func (r *BatchRepository) GetCompletedBatchCount(actionID int64) (int64, error) {
-	var count int64
+// BacklogCounts counts the batches workers still have to finish
+type BacklogCounts struct {
+	PendingBatchCount    int64 `json:"pending_batch_count"`    // Pending batches of actions that are not paused
+	ProcessingBatchCount int64 `json:"processing_batch_count"` // Batches locked by a worker
+}
+
+// GetBacklogCounts counts the claimable pending batches and the batches in progress across all actions
+func (r *BatchRepository) GetBacklogCounts() (*BacklogCounts, error) {
+	var counts BacklogCounts
 	err := r.db.Model(&Batch{}).
-		Where("action_id = ? AND status IN ('completed')", actionID).
-		Count(&count).Error
-	return count, err
+		Select(`
+			COUNT(*) FILTER (WHERE batches.status = 'pending' AND manager_actions.status IN ('pending', 'running')) AS pending_batch_count,
+			COUNT(*) FILTER (WHERE batches.status = 'processing') AS processing_batch_count`).
+		Joins("JOIN manager_actions ON manager_actions.id = batches.action_id").
+		Where("batches.status IN ?", []string{"pending", "processing"}).
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// GetAverageBatchDuration averages the seconds between the first lock and the completion of the
+// most recently completed batches. It also returns the number of batches the average is based on.
+func (r *BatchRepository) GetAverageBatchDuration(sampleSize int) (float64, int64, error) {
+	var result struct {
+		AverageSeconds float64
+		SampleCount    int64
+	}
+
+	recent := r.db.Model(&Batch{}).
+		Select("EXTRACT(EPOCH FROM finished_at - started_at) AS seconds").
+		Where("status = ? AND started_at IS NOT NULL AND finished_at IS NOT NULL", "completed").
+		Order("finished_at DESC").
+		Limit(sampleSize)
+	err := r.db.Table("(?) AS recent", recent).
+		Select("COALESCE(AVG(seconds), 0) AS average_seconds, COUNT(*) AS sample_count").
+		Scan(&result).Error
+	return result.AverageSeconds, result.SampleCount, err
+}
+
+// PendingLabelGroup counts the claimable pending batches of an action that need the same labels
+type PendingLabelGroup struct {
+	ActionID       int64  `json:"action_id"`
+	RequiredLabels Labels `json:"required_labels"`
+	BatchCount     int64  `json:"batch_count"`
+}
+
+// GetPendingLabelGroups groups the claimable pending batches with required labels by action and labels
+func (r *BatchRepository) GetPendingLabelGroups() ([]PendingLabelGroup, error) {
+	var groups []PendingLabelGroup
+	err := r.db.Model(&Batch{}).
+		Select("batches.action_id, batches.required_labels, COUNT(*) AS batch_count").
+		Joins("JOIN manager_actions ON manager_actions.id = batches.action_id").
+		Where("batches.status = ? AND batches.required_labels != '{}'::jsonb", "pending").
+		Where("manager_actions.status IN ?", []string{"pending", "running"}).
+		Group("batches.action_id, batches.required_labels").
+		Order("batches.action_id ASC").
+		Scan(&groups).Error
+	return groups, err
 }
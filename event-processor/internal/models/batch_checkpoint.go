@@ -0,0 +1,54 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// BatchCheckpoint records how far the attempts on a batch got. Items are processed in subscription ID
+// order, and the updates and failures of everything up to LastKey are written together with the
+// checkpoint. A retried or reclaimed batch continues after LastKey.
+type BatchCheckpoint struct {
+	LastKey  int64       `json:"last_key"` // Highest subscription ID processed in batch order
+	Position int64       `json:"position"` // Number of batch items up to and including LastKey
+	Result   BatchResult `json:"result"`   // Counters of the items up to LastKey
+	SavedAt  time.Time   `json:"saved_at"`
+}
+
+// CheckpointWrites are the item outcomes written together with a checkpoint
+type CheckpointWrites struct {
+	ActionID int64
+	Updates  []Subscription      // Renewed and expired subscriptions, their earlier failures are cleared
+	Failures []ItemFailure       // Subscriptions that could not be processed
+	Events   []SubscriptionEvent // Status transitions of the updates, written to the history and the outbox
+	Skipped  []int64             // Subscriptions the sweep skipped, their due queue rows are moved to their next check
+	// Differences to the store found by reconciliations, fixes that lost against a newer change are recorded as not fixed
+	Discrepancies []ReconciliationDiscrepancy
+	Retry         RetryPolicy // Schedules the retries of the failures
+}
+
+// Value implements driver.Valuer
+func (c BatchCheckpoint) Value() (driver.Value, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (c *BatchCheckpoint) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*c = BatchCheckpoint{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return fmt.Errorf("cannot scan %T into BatchCheckpoint", value)
+	}
+}
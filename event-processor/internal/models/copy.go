@@ -0,0 +1,44 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+)
+
+// copyFromFunc bulk loads rows into a table with COPY and returns the number of rows copied
+type copyFromFunc func(table string, columns []string, rows [][]interface{}) (int64, error)
+
+// copyTransaction runs fn in a transaction on a dedicated connection. The copyFrom passed to fn streams
+// rows with COPY through the pgx connection underneath the transaction, so they are visible to tx and
+// temporary tables created in tx can be loaded without the parameter limit of a single statement.
+func copyTransaction(db *gorm.DB, fn func(tx *gorm.DB, copyFrom copyFromFunc) error) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		sqlConn, ok := conn.Statement.ConnPool.(*sql.Conn)
+		if !ok {
+			return fmt.Errorf("copy needs a dedicated connection, got %T", conn.Statement.ConnPool)
+		}
+
+		ctx := conn.Statement.Context
+		copyFrom := func(table string, columns []string, rows [][]interface{}) (int64, error) {
+			var copied int64
+			err := sqlConn.Raw(func(driverConn interface{}) error {
+				pgxConn, ok := driverConn.(*stdlib.Conn)
+				if !ok {
+					return fmt.Errorf("copy needs the pgx driver, got %T", driverConn)
+				}
+				var err error
+				copied, err = pgxConn.Conn().CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(rows))
+				return err
+			})
+			return copied, err
+		}
+
+		return conn.Transaction(func(tx *gorm.DB) error {
+			return fn(tx, copyFrom)
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Labels describe what a worker can serve, or what a batch needs from the worker processing it.
+// Every label has a list of values, e.g. {"store": ["ios"], "apps": ["1", "2"]}. A worker can
+// serve a batch when its labels contain every required value, which is the jsonb @> operator.
+type Labels map[string][]string
+
+// Value implements driver.Valuer, storing no labels as an empty object
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (l *Labels) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Labels", value)
+	}
+	return json.Unmarshal(data, l)
+}
+
+// ParseLabels reads labels written as key=value entries separated by commas, several values of
+// one label are separated by |, e.g. "store=ios,region=eu-west-1,apps=1|2|3"
+func ParseLabels(value string) (Labels, error) {
+	labels := Labels{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, values, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", entry)
+		}
+		for _, v := range strings.Split(values, "|") {
+			if v = strings.TrimSpace(v); v != "" {
+				labels.Add(key, v)
+			}
+		}
+	}
+	return labels, nil
+}
+
+// Add adds a value to a label, keeping the values sorted and unique
+func (l Labels) Add(key string, value string) {
+	if slices.Contains(l[key], value) {
+		return
+	}
+	l[key] = append(l[key], value)
+	sort.Strings(l[key])
+}
+
+// Merge returns the labels of both l and other
+func (l Labels) Merge(other Labels) Labels {
+	merged := Labels{}
+	for _, labels := range []Labels{l, other} {
+		for key, values := range labels {
+			for _, value := range values {
+				merged.Add(key, value)
+			}
+		}
+	}
+	return merged
+}
+
+// Satisfies reports whether labels contain every required value
+func (l Labels) Satisfies(required Labels) bool {
+	for key, values := range required {
+		for _, value := range values {
+			if !slices.Contains(l[key], value) {
+				return false
+			}
+		}
+	}
+	return true
+}
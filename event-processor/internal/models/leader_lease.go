@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LeaderLease represents a named lease held by a single instance at a time
+type LeaderLease struct {
+	Name       string    `gorm:"primaryKey;size:100" json:"name"`              // Lease name, e.g. "worker_manager"
+	HolderID   string    `gorm:"size:100;not null" json:"holder_id"`           // Instance currently holding the lease
+	ExpiresAt  time.Time `gorm:"type:timestamptz;not null" json:"expires_at"`  // Lease is free after this time
+	AcquiredAt time.Time `gorm:"type:timestamptz;not null" json:"acquired_at"` // When the current holder took over
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`             // Last renewal
+}
+
+// LeaderLeaseRepository manages lease-related database operations
+type LeaderLeaseRepository struct {
+	db *gorm.DB
+}
+
+// NewLeaderLeaseRepository creates a new instance of LeaderLeaseRepository
+func NewLeaderLeaseRepository(db *gorm.DB) *LeaderLeaseRepository {
+	return &LeaderLeaseRepository{db: db}
+}
+
+// TryAcquire acquires or renews the lease for holderID. The lease is only taken over
+// when it is held by holderID already or has expired. Expiry uses the database clock
+// so instances with skewed clocks agree on it.
+func (r *LeaderLeaseRepository) TryAcquire(name string, holderID string, ttl time.Duration) (bool, error) {
+	var holders []string
+	err := r.db.Raw(`
+		INSERT INTO leader_leases (name, holder_id, expires_at, acquired_at, updated_at)
+		VALUES (?, ?, NOW() + make_interval(secs => ?), NOW(), NOW())
+		ON CONFLICT (name) DO UPDATE SET
+			holder_id = EXCLUDED.holder_id,
+			expires_at = EXCLUDED.expires_at,
+			acquired_at = CASE WHEN leader_leases.holder_id = EXCLUDED.holder_id THEN leader_leases.acquired_at ELSE NOW() END,
+			updated_at = NOW()
+		WHERE leader_leases.holder_id = EXCLUDED.holder_id OR leader_leases.expires_at < NOW()
+		RETURNING holder_id`,
+		name, holderID, ttl.Seconds(),
+	).Scan(&holders).Error
+	if err != nil {
+		return false, err
+	}
+	return len(holders) > 0, nil
+}
+
+// Release gives up the lease if holderID still holds it
+func (r *LeaderLeaseRepository) Release(name string, holderID string) error {
+	return r.db.Where("name = ? AND holder_id = ?", name, holderID).
+		Delete(&LeaderLease{}).Error
+}
+
+// GetLease fetches a lease by name, returning nil when nobody ever held it
+func (r *LeaderLeaseRepository) GetLease(name string) (*LeaderLease, error) {
+	var lease LeaderLease
+	err := r.db.Where("name = ?", name).First(&lease).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &lease, nil
+}
@@ -1,25 +1,62 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ManagerAction struct {
-	ID                   int64     `gorm:"primaryKey" json:"id"`
-	ExpectedCount        int64     `gorm:"not null" json:"expected_count"`
-	WillBeProcessedCount int64     `gorm:"not null" json:"will_be_processed_count"`
-	MaxBatch             int       `gorm:"not null" json:"max_batch"`
-	BatchCount           int64     `gorm:"not null;default:0" json:"batch_count"`           // Total number of batches
-	CompletedBatchCount  int       `gorm:"not null;default:0" json:"completed_batch_count"` // Number of completed batches
-	TriggeredAt          time.Time `gorm:"not null" json:"triggered_at"`
-	Status               string    `gorm:"size:20;default:pending" json:"status"` // "pending", "running", "completed"
-	CreatedAt            time.Time `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt            time.Time `gorm:"autoUpdateTime" json:"updated_at"`
-	Batches              []Batch   `gorm:"-" json:"batches"` // Non-Gorm field
+	ID                   int64           `gorm:"primaryKey" json:"id"`
+	Type                 string          `gorm:"size:50;not null;default:renewal_sweep" json:"type"` // Job type run by this action
+	Params               JSON            `gorm:"type:jsonb" json:"params"`                           // Job type specific parameters
+	ExpectedCount        int64           `gorm:"not null" json:"expected_count"`
+	WillBeProcessedCount int64           `gorm:"not null" json:"will_be_processed_count"`
+	MaxBatch             int             `gorm:"not null" json:"max_batch"`
+	BatchCount           int64           `gorm:"not null;default:0" json:"batch_count"`           // Total number of batches
+	CompletedBatchCount  int             `gorm:"not null;default:0" json:"completed_batch_count"` // Number of completed batches
+	FailedBatchCount     int             `gorm:"not null;default:0" json:"failed_batch_count"`    // Number of stale batches
+	BatchResult                          // Outcome counters summed over all batches
+	TriggeredAt          time.Time       `gorm:"not null" json:"triggered_at"`
+	StartedAt            *time.Time      `gorm:"default:null" json:"started_at"`        // When the first batch was claimed
+	FinishedAt           *time.Time      `gorm:"default:null" json:"finished_at"`       // When the last batch finished
+	Status               string          `gorm:"size:20;default:pending" json:"status"` // "pending", "running", "paused", "canceled", "completed", "partially_failed", "failed"
+	CreatedAt            time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt            time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+	Batches              []Batch         `gorm:"-" json:"batches"`  // Non-Gorm field
+	Progress             *ActionProgress `gorm:"-" json:"progress"` // Non-Gorm field
 }
 
+// ActionProgress is the live progress of an action, calculated when it is read
+type ActionProgress struct {
+	PercentComplete     float64  `json:"percent_complete"`      // Processed items out of the items to process
+	ThroughputPerSecond float64  `json:"throughput_per_second"` // Items processed per second over the sliding window
+	ETASeconds          *float64 `json:"eta_seconds"`           // Estimated seconds left, nil while unknown
+}
+
+// ActionListFilter narrows down and pages the actions returned by ListActions
+type ActionListFilter struct {
+	Statuses      []string   // Only actions in these statuses
+	Type          string     // Only actions of this job type
+	TriggeredFrom *time.Time // Only actions triggered at or after this time
+	TriggeredTo   *time.Time // Only actions triggered at or before this time
+	Limit         int
+	Offset        int
+}
+
+// ErrActiveActionExists is returned when an action is created while another action of its type is active
+var ErrActiveActionExists = errors.New("an active action of this type already exists")
+
+// ErrActionStatusConflict is returned when an action is not in a status the change is allowed from
+var ErrActionStatusConflict = errors.New("action status does not allow this change")
+
+// ActionActorManager is the actor recorded for the status changes the manager makes on its own
+const ActionActorManager = "manager"
+
 func NewManagerActionRepository(db *gorm.DB) *ManagerActionRepository {
 	return &ManagerActionRepository{db: db}
 }
@@ -37,32 +74,230 @@ func (r *ManagerActionRepository) HasPendingAction() (bool, error) {
 	return count > 0, err
 }
 
-// CreateNewAction creates a new manager action with initial values
-func (r *ManagerActionRepository) CreateNewAction(expectedCount, willBeProcessedCount int64, maxBatch int, batchCount int64) (*ManagerAction, error) {
+// CreateNewAction creates a new manager action with initial values and its pending batches in one transaction,
+// requiredLabels restricts which workers may claim them
+func (r *ManagerActionRepository) CreateNewAction(jobType string, params JSON, expectedCount, willBeProcessedCount int64, maxBatch int, ranges []BatchRange, requiredLabels Labels) (*ManagerAction, error) {
 	action := &ManagerAction{
+		Type:                 jobType,
+		Params:               params,
 		ExpectedCount:        expectedCount,
 		WillBeProcessedCount: willBeProcessedCount,
 		MaxBatch:             maxBatch,
-		BatchCount:           batchCount, // Default value
-		CompletedBatchCount:  0,          // Default value
+		BatchCount:           int64(len(ranges)),
+		CompletedBatchCount:  0, // Default value
 		TriggeredAt:          time.Now(),
 		Status:               "pending",
 	}
-	err := r.db.Create(action).Error
-	return action, err
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(action).Error; err != nil {
+			return err
+		}
+		return createBatches(tx, action.ID, ranges, requiredLabels)
+	})
+	if isUniqueViolation(err, "manager_actions_active_type_idx") {
+		return nil, fmt.Errorf("%w: %s", ErrActiveActionExists, jobType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+// isUniqueViolation reports whether err is a violation of the unique index
+func isUniqueViolation(err error, index string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == index
 }
 
-// GetActiveActions fetches all active (pending or running) manager actions
+// GetActiveActions fetches all active (pending, running or paused) manager actions
 func (r *ManagerActionRepository) GetActiveActions() ([]ManagerAction, error) {
 	var actions []ManagerAction
-	err := r.db.Where("status IN ('pending', 'running')").Find(&actions).Error
+	err := r.db.Where("status IN ('pending', 'running', 'paused')").Find(&actions).Error
+	return actions, err
+}
+
+// GetActionsInProgress fetches the active manager actions and the canceled ones whose progress still
+// changes: their workers finish the batches that were processing when they were canceled, and the
+// progress stored last does not count every finished batch yet
+func (r *ManagerActionRepository) GetActionsInProgress() ([]ManagerAction, error) {
+	var actions []ManagerAction
+	processing := r.db.Model(&Batch{}).
+		Select("1").
+		Where("batches.action_id = manager_actions.id AND batches.status = 'processing'")
+	finished := r.db.Model(&Batch{}).
+		Select("COUNT(*)").
+		Where("batches.action_id = manager_actions.id AND batches.status IN ('completed', 'stale')")
+	err := r.db.Where("status IN ('pending', 'running', 'paused')").
+		Or("status = 'canceled' AND (EXISTS (?) OR completed_batch_count + failed_batch_count < (?))", processing, finished).
+		Find(&actions).Error
 	return actions, err
 }
 
-// MarkActionAsCompleted updates the status of a manager action to "completed"
-func (r *ManagerActionRepository) MarkActionAsCompleted(actionID int64, completedBatchCount int) error {
+// GetActiveActionsByType fetches active (pending, running or paused) manager actions of a job type
+func (r *ManagerActionRepository) GetActiveActionsByType(jobType string) ([]ManagerAction, error) {
+	var actions []ManagerAction
+	err := r.db.Where("type = ? AND status IN ('pending', 'running', 'paused')", jobType).Find(&actions).Error
+	return actions, err
+}
+
+// ListActions fetches a page of manager actions matching the filter, newest first, and the total count
+func (r *ManagerActionRepository) ListActions(filter ActionListFilter) ([]ManagerAction, int64, error) {
+	query := r.db.Model(&ManagerAction{})
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.TriggeredFrom != nil {
+		query = query.Where("triggered_at >= ?", *filter.TriggeredFrom)
+	}
+	if filter.TriggeredTo != nil {
+		query = query.Where("triggered_at <= ?", *filter.TriggeredTo)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var actions []ManagerAction
+	err := query.Order("id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&actions).Error
+	return actions, total, err
+}
+
+// GetActionsByIDs fetches the manager actions with the given IDs
+func (r *ManagerActionRepository) GetActionsByIDs(actionIDs []int64) ([]ManagerAction, error) {
+	var actions []ManagerAction
+	if len(actionIDs) == 0 {
+		return actions, nil
+	}
+	err := r.db.Where("id IN ?", actionIDs).Order("id ASC").Find(&actions).Error
+	return actions, err
+}
+
+// GetActionByID fetches a manager action by its ID
+func (r *ManagerActionRepository) GetActionByID(actionID int64) (*ManagerAction, error) {
+	var action ManagerAction
+	err := r.db.First(&action, actionID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// UpdateActionProgress stores the batch totals of an action
+func (r *ManagerActionRepository) UpdateActionProgress(actionID int64, totals *BatchTotals) error {
 	return r.db.Model(&ManagerAction{}).
 		Where("id = ?", actionID).
-		Update("completed_batch_count", completedBatchCount).
-		Update("status", "completed").Error
+		Updates(map[string]interface{}{
+			"completed_batch_count": totals.CompletedBatchCount,
+			"failed_batch_count":    totals.StaleBatchCount,
+			"processed_count":       totals.ProcessedCount,
+			"renewed_count":         totals.RenewedCount,
+			"expired_count":         totals.ExpiredCount,
+			"failed_count":          totals.FailedCount,
+			"skipped_count":         totals.SkippedCount,
+			"conflict_count":        totals.ConflictCount,
+		}).Error
+}
+
+// FinishAction moves an active action to its final status once its batches are done, recorded as a
+// change by the manager
+func (r *ManagerActionRepository) FinishAction(actionID int64, status string, reason string) (*ManagerAction, error) {
+	return r.ChangeActionStatus(actionID, []string{"pending", "running", "paused"}, status, ActionActorManager, reason)
+}
+
+// ChangeActionStatus moves an action from one of the given statuses to a new status and records
+// who made the change and why. Canceling an action also cancels its pending batches.
+func (r *ManagerActionRepository) ChangeActionStatus(actionID int64, from []string, to string, actor string, reason string) (*ManagerAction, error) {
+	return r.changeActionStatus(actionID, from, func(tx *gorm.DB, action *ManagerAction) (string, error) {
+		return to, nil
+	}, actor, reason)
+}
+
+// ResumeAction moves a paused action back to running, or to pending when none of its batches was claimed
+// before the pause. The batches are counted while the action is locked, so the status matches them.
+func (r *ManagerActionRepository) ResumeAction(actionID int64, actor string, reason string) (*ManagerAction, error) {
+	return r.changeActionStatus(actionID, []string{"paused"}, func(tx *gorm.DB, action *ManagerAction) (string, error) {
+		var startedCount int64
+		err := tx.Model(&Batch{}).
+			Where("action_id = ? AND status != 'pending'", action.ID).
+			Count(&startedCount).Error
+		if err != nil {
+			return "", fmt.Errorf("failed to check started batches for action %d: %w", action.ID, err)
+		}
+		if startedCount == 0 {
+			return "pending", nil
+		}
+		return "running", nil
+	}, actor, reason)
+}
+
+// changeActionStatus is ChangeActionStatus with a target status decided by target while the action is locked
+func (r *ManagerActionRepository) changeActionStatus(actionID int64, from []string, target func(tx *gorm.DB, action *ManagerAction) (string, error), actor string, reason string) (*ManagerAction, error) {
+	var action ManagerAction
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&action, actionID).Error
+		if err != nil {
+			return err
+		}
+
+		allowed := false
+		for _, status := range from {
+			if action.Status == status {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: action %d is %s", ErrActionStatusConflict, actionID, action.Status)
+		}
+
+		to, err := target(tx, &action)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(&ActionEvent{
+			ActionID:   actionID,
+			FromStatus: action.Status,
+			ToStatus:   to,
+			Actor:      actor,
+			Reason:     reason,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if to == "canceled" {
+			err = tx.Model(&Batch{}).
+				Where("action_id = ? AND status = ?", actionID, "pending").
+				Update("status", "canceled").Error
+			if err != nil {
+				return err
+			}
+		}
+
+		updates := map[string]interface{}{"status": to}
+		if isFinalActionStatus(to) {
+			updates["finished_at"] = time.Now()
+		}
+
+		action.Status = to
+		return tx.Model(&action).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// isFinalActionStatus reports whether an action in status is done and gets no more batches processed
+func isFinalActionStatus(status string) bool {
+	switch status {
+	case "canceled", "completed", "partially_failed", "failed":
+		return true
+	}
+	return false
 }
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Statuses of an outbox message
+const (
+	OutboxStatusPending = "pending" // Waiting to be published
+	OutboxStatusSent    = "sent"    // Confirmed by the broker
+)
+
+// OutboxMessage is a message written in the same transaction as the change it announces.
+// The relay publishes it afterwards, so a change is never committed without its message.
+type OutboxMessage struct {
+	ID            int64      `gorm:"primaryKey" json:"id"`
+	Queue         string     `gorm:"size:100;not null" json:"queue"`
+	Payload       JSON       `gorm:"type:jsonb;not null" json:"payload"`
+	Status        string     `gorm:"size:20;not null;default:'pending'" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`              // Failed publish attempts
+	LastError     string     `gorm:"type:text;not null;default:''" json:"last_error"` // Error of the last failed attempt
+	NextAttemptAt time.Time  `gorm:"type:timestamptz;not null" json:"next_attempt_at"`
+	SentAt        *time.Time `gorm:"default:null" json:"sent_at"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName keeps the table name singular
+func (OutboxMessage) TableName() string {
+	return "outbox"
+}
+
+// enqueueSubscriptionEvents writes the events to the outbox of the subscription_events queue. db should be
+// the transaction of the subscription updates the events announce.
+func enqueueSubscriptionEvents(db *gorm.DB, events []SubscriptionEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	rows := make([]OutboxMessage, len(events))
+	for i, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode subscription event: %w", err)
+		}
+		rows[i] = OutboxMessage{Queue: SubscriptionEventsQueue, Payload: payload, Status: OutboxStatusPending, NextAttemptAt: now}
+	}
+	return db.Create(&rows).Error
+}
+
+// OutboxRepository hands outbox messages to relays
+type OutboxRepository struct {
+	db *gorm.DB
+}
+
+// NewOutboxRepository creates a new instance of OutboxRepository
+func NewOutboxRepository(db *gorm.DB) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+// ClaimMessages leases up to limit due messages, oldest first. Leased messages are not due again until
+// the lease ends, so relays running in parallel never claim the same message, and the messages of a
+// relay that died are picked up by another one after the lease.
+//
+// Messages are published in the order they were written: a message is only claimed while no older
+// message is waiting, either for its retry or in the lease of another relay. Claims are serialized
+// with an advisory lock so a relay never sees the older messages of a claim that is not committed yet.
+func (r *OutboxRepository) ClaimMessages(limit int, lease time.Duration) ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('outbox_claim'))").Error; err != nil {
+			return err
+		}
+		return tx.Raw(`
+			UPDATE outbox SET next_attempt_at = NOW() + make_interval(secs => ?)
+			WHERE id IN (
+				SELECT id FROM outbox
+				WHERE status = ? AND next_attempt_at <= NOW()
+					AND id < COALESCE((
+						SELECT MIN(id) FROM outbox
+						WHERE status = ? AND next_attempt_at > NOW()
+					), ?)
+				ORDER BY id
+				LIMIT ?
+			)
+			RETURNING *`, lease.Seconds(), OutboxStatusPending, OutboxStatusPending, int64(math.MaxInt64), limit).
+			Scan(&messages).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the order of the subquery
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+// ReleaseMessages ends the lease of claimed messages that were not published, so they are due again
+// right away. They still wait for any older message that failed.
+func (r *OutboxRepository) ReleaseMessages(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Model(&OutboxMessage{}).
+		Where("id IN ? AND status = ?", ids, OutboxStatusPending).
+		Update("next_attempt_at", gorm.Expr("NOW()")).Error
+}
+
+// MarkSent records that the broker confirmed the messages
+func (r *OutboxRepository) MarkSent(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Model(&OutboxMessage{}).
+		Where("id IN ?", ids).
+		Updates(map[string]interface{}{"status": OutboxStatusSent, "sent_at": time.Now()}).Error
+}
+
+// MarkFailed counts a failed publish attempt and schedules the next one. The wait doubles with
+// every attempt, up to maxBackoff.
+func (r *OutboxRepository) MarkFailed(id int64, cause error, backoff time.Duration, maxBackoff time.Duration) error {
+	return r.db.Model(&OutboxMessage{}).
+		Where("id = ? AND status = ?", id, OutboxStatusPending).
+		Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"last_error":      cause.Error(),
+			"next_attempt_at": gorm.Expr("NOW() + make_interval(secs => LEAST(? * POWER(2, attempts), ?))", backoff.Seconds(), maxBackoff.Seconds()),
+		}).Error
+}
+
+// PruneSent deletes messages sent before olderThan and returns how many were deleted
+func (r *OutboxRepository) PruneSent(olderThan time.Time) (int64, error) {
+	result := r.db.Where("status = ? AND sent_at < ?", OutboxStatusSent, olderThan).Delete(&OutboxMessage{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of a discrepancy between a stored subscription and the store
+const (
+	DiscrepancyStatusMismatch = "status_mismatch" // Stored with access, the store reports none, e.g. revoked, refunded or expired
+	DiscrepancyExpiryMismatch = "expiry_mismatch" // Access on both sides, the store reports another expiry
+	DiscrepancyUnknownReceipt = "unknown_receipt" // The store does not know the receipt
+)
+
+// discrepancyKinds are the known discrepancy kinds
+var discrepancyKinds = map[string]bool{
+	DiscrepancyStatusMismatch: true,
+	DiscrepancyExpiryMismatch: true,
+	DiscrepancyUnknownReceipt: true,
+}
+
+// ValidateDiscrepancyKind returns an error for kinds that are not one of the Discrepancy constants
+func ValidateDiscrepancyKind(kind string) error {
+	if !discrepancyKinds[kind] {
+		return fmt.Errorf("unknown discrepancy kind %q", kind)
+	}
+	return nil
+}
+
+// ReconciliationDiscrepancy is a difference between a stored subscription and the store found by a
+// reconciliation. Fixed discrepancies were corrected by the reconciliation, the others are only reported.
+type ReconciliationDiscrepancy struct {
+	ID             int64              `gorm:"primaryKey" json:"id"`
+	ActionID       int64              `gorm:"not null;index" json:"action_id"`
+	BatchID        int64              `gorm:"not null" json:"batch_id"`
+	SubscriptionID int64              `gorm:"not null;index" json:"subscription_id"`
+	AppID          int                `gorm:"not null" json:"app_id"`
+	Kind           string             `gorm:"size:30;not null" json:"kind"`                         // One of the Discrepancy constants
+	LocalStatus    SubscriptionStatus `gorm:"size:20;not null" json:"local_status"`                 // Status when the subscription was read
+	StoreStatus    SubscriptionStatus `gorm:"size:20;not null;default:''" json:"store_status"`      // Status reported by the store, empty for unknown receipts
+	LocalExpireAt  time.Time          `gorm:"type:timestamptz" json:"local_expire_at"`              // Expiry when the subscription was read
+	StoreExpireAt  *time.Time         `gorm:"type:timestamptz;default:null" json:"store_expire_at"` // Expiry reported by the store
+	Fixed          bool               `gorm:"not null;default:false" json:"fixed"`                  // Corrected according to the auto-fix policy
+	ResponseHash   string             `gorm:"size:64;not null;default:''" json:"response_hash"`     // SHA-256 of the raw store response
+	CreatedAt      time.Time          `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// ReconciliationFilter selects the subscriptions with access a reconciliation re-validates. The zero
+// value scans every subscription with access.
+type ReconciliationFilter struct {
+	AppIDs       []int `json:"app_ids,omitempty"`       // Only subscriptions of these apps
+	SampleEvery  int64 `json:"sample_every,omitempty"`  // Only every n-th subscription by ID, 0 or 1 scans all
+	SampleOffset int64 `json:"sample_offset,omitempty"` // Which of the n subscriptions, rotate it to cover all of them over time
+}
+
+// Validate checks the sampling of the filter
+func (f ReconciliationFilter) Validate() error {
+	if f.SampleEvery < 0 {
+		return errors.New("sample_every must not be negative")
+	}
+	if f.SampleOffset < 0 || (f.SampleEvery > 1 && f.SampleOffset >= f.SampleEvery) || (f.SampleEvery <= 1 && f.SampleOffset != 0) {
+		return errors.New("sample_offset must be below sample_every")
+	}
+	return nil
+}
+
+// Apply adds the filter conditions to a query on the subscriptions table
+func (f ReconciliationFilter) Apply(query *gorm.DB) *gorm.DB {
+	query = query.Where("subscriptions.status IN ?", EntitledStatuses())
+
+	if len(f.AppIDs) > 0 {
+		query = query.Where("subscriptions.app_id IN ?", f.AppIDs)
+	}
+
+	if f.SampleEvery > 1 {
+		query = query.Where("subscriptions.id % ? = ?", f.SampleEvery, f.SampleOffset)
+	}
+
+	return query
+}
+
+// DiscrepancyFilter narrows down the listed discrepancies
+type DiscrepancyFilter struct {
+	ActionID int64
+	AppID    int
+	Kinds    []string
+	Fixed    *bool
+	Limit    int
+	Offset   int
+}
+
+// recordDiscrepancies inserts the discrepancies found since the last checkpoint on db, which may be a
+// transaction. Fixes of subscriptions that conflicted with a newer change were not written and are
+// recorded as not fixed.
+func recordDiscrepancies(db *gorm.DB, discrepancies []ReconciliationDiscrepancy, conflicts []Subscription) error {
+	if len(discrepancies) == 0 {
+		return nil
+	}
+
+	skipped := make(map[int64]bool, len(conflicts))
+	for _, sub := range conflicts {
+		skipped[sub.ID] = true
+	}
+	for i := range discrepancies {
+		if skipped[discrepancies[i].SubscriptionID] {
+			discrepancies[i].Fixed = false
+		}
+	}
+	return db.Create(&discrepancies).Error
+}
+
+// ReconciliationRepository manages the discrepancy reports of reconciliations
+type ReconciliationRepository struct {
+	db *gorm.DB
+}
+
+// NewReconciliationRepository creates a new instance of ReconciliationRepository
+func NewReconciliationRepository(db *gorm.DB) *ReconciliationRepository {
+	return &ReconciliationRepository{db: db}
+}
+
+// ListDiscrepancies fetches a page of discrepancies matching the filter, newest first, and the total count
+func (r *ReconciliationRepository) ListDiscrepancies(filter DiscrepancyFilter) ([]ReconciliationDiscrepancy, int64, error) {
+	query := r.db.Model(&ReconciliationDiscrepancy{})
+	if filter.ActionID != 0 {
+		query = query.Where("action_id = ?", filter.ActionID)
+	}
+	if filter.AppID != 0 {
+		query = query.Where("app_id = ?", filter.AppID)
+	}
+	if len(filter.Kinds) > 0 {
+		query = query.Where("kind IN ?", filter.Kinds)
+	}
+	if filter.Fixed != nil {
+		query = query.Where("fixed = ?", *filter.Fixed)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var discrepancies []ReconciliationDiscrepancy
+	err := query.Order("id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&discrepancies).Error
+	return discrepancies, total, err
+}
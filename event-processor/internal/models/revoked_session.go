@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RevokedSession is a dashboard session that was logged out before it expired
+type RevokedSession struct {
+	SessionID string    `gorm:"primaryKey;size:64" json:"session_id"` // Random ID carried in the session token
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`           // When the session would have expired
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`     // When the session was revoked
+}
+
+// RevokedSessionRepository manages revoked session database operations
+type RevokedSessionRepository struct {
+	db *gorm.DB
+}
+
+// NewRevokedSessionRepository creates a new instance of RevokedSessionRepository
+func NewRevokedSessionRepository(db *gorm.DB) *RevokedSessionRepository {
+	return &RevokedSessionRepository{db: db}
+}
+
+// RevokeSession stores a session as revoked until it expires and deletes the revocations of sessions
+// that expired since, they are rejected for their expiry anyway
+func (r *RevokedSessionRepository) RevokeSession(sessionID string, expiresAt time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("expires_at < ?", time.Now()).Delete(&RevokedSession{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where(RevokedSession{SessionID: sessionID}).
+			Attrs(RevokedSession{ExpiresAt: expiresAt}).
+			FirstOrCreate(&RevokedSession{}).Error
+	})
+}
+
+// IsSessionRevoked reports whether a session was logged out
+func (r *RevokedSessionRepository) IsSessionRevoked(sessionID string) (bool, error) {
+	var count int64
+	err := r.db.Model(&RevokedSession{}).
+		Where("session_id = ?", sessionID).
+		Count(&count).Error
+	return count > 0, err
+}
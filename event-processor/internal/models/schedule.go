@@ -0,0 +1,115 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Schedule represents a recurring (cron) or one-off run of a job type
+type Schedule struct {
+	ID        int64      `gorm:"primaryKey" json:"id"`
+	Name      string     `gorm:"size:100;not null;unique" json:"name"`   // Unique schedule name
+	JobType   string     `gorm:"size:50;not null" json:"job_type"`       // Job type to trigger, e.g. "renewal_sweep"
+	CronExpr  *string    `gorm:"size:100;default:null" json:"cron_expr"` // Cron expression, nil for one-off runs
+	Params    JSON       `gorm:"type:jsonb" json:"params"`               // Parameters passed to the job type
+	RunAt     *time.Time `gorm:"default:null" json:"run_at"`             // Run time for one-off schedules
+	Enabled   bool       `gorm:"not null;default:true" json:"enabled"`   // Disabled schedules never fire
+	NextRunAt *time.Time `gorm:"default:null" json:"next_run_at"`        // Next time the schedule is due
+	LastRunAt *time.Time `gorm:"default:null" json:"last_run_at"`        // Last occurrence that was handled
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`       // Creation timestamp
+	UpdatedAt time.Time  `gorm:"autoUpdateTime" json:"updated_at"`       // Update timestamp
+}
+
+// ScheduleRun records a single fire of a schedule and its outcome
+type ScheduleRun struct {
+	ID           int64     `gorm:"primaryKey" json:"id"`
+	ScheduleID   int64     `gorm:"not null" json:"schedule_id"`                  // Related schedule ID
+	ScheduledFor time.Time `gorm:"not null" json:"scheduled_for"`                // Occurrence the run belongs to
+	FiredAt      time.Time `gorm:"not null" json:"fired_at"`                     // When the run actually fired
+	Status       string    `gorm:"size:20;not null" json:"status"`               // "succeeded", "skipped", "failed", "missed"
+	ActionID     *int64    `gorm:"default:null" json:"action_id"`                // Action created by the run, if any
+	Message      string    `gorm:"type:text;not null;default:''" json:"message"` // Outcome details
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`             // Creation timestamp
+}
+
+// ScheduleRepository manages schedule-related database operations
+type ScheduleRepository struct {
+	db *gorm.DB
+}
+
+// NewScheduleRepository creates a new instance of ScheduleRepository
+func NewScheduleRepository(db *gorm.DB) *ScheduleRepository {
+	return &ScheduleRepository{db: db}
+}
+
+// GetSchedules fetches all schedules
+func (r *ScheduleRepository) GetSchedules() ([]Schedule, error) {
+	var schedules []Schedule
+	err := r.db.Order("id ASC").Find(&schedules).Error
+	return schedules, err
+}
+
+// GetScheduleByName fetches a schedule by its unique name, returning nil when it does not exist
+func (r *ScheduleRepository) GetScheduleByName(name string) (*Schedule, error) {
+	var schedule Schedule
+	err := r.db.Where("name = ?", name).First(&schedule).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// GetDueSchedules fetches enabled schedules whose next run is at or before now
+func (r *ScheduleRepository) GetDueSchedules(now time.Time) ([]Schedule, error) {
+	var schedules []Schedule
+	err := r.db.Where("enabled = ? AND next_run_at <= ?", true, now).
+		Order("next_run_at ASC").
+		Find(&schedules).Error
+	return schedules, err
+}
+
+// CreateSchedule inserts a new schedule
+func (r *ScheduleRepository) CreateSchedule(schedule *Schedule) error {
+	return r.db.Create(schedule).Error
+}
+
+// UpdateCronSchedule changes the job type and cron expression of an existing schedule
+func (r *ScheduleRepository) UpdateCronSchedule(scheduleID int64, jobType string, cronExpr string, params JSON, nextRunAt time.Time) error {
+	return r.db.Model(&Schedule{}).
+		Where("id = ?", scheduleID).
+		Updates(map[string]interface{}{
+			"job_type":    jobType,
+			"cron_expr":   cronExpr,
+			"params":      params,
+			"next_run_at": nextRunAt,
+			"enabled":     true,
+		}).Error
+}
+
+// AdvanceSchedule stores the last handled occurrence and the next due time.
+// A nil nextRunAt disables the schedule.
+func (r *ScheduleRepository) AdvanceSchedule(scheduleID int64, lastRunAt time.Time, nextRunAt *time.Time) error {
+	return r.db.Model(&Schedule{}).
+		Where("id = ?", scheduleID).
+		Updates(map[string]interface{}{
+			"last_run_at": lastRunAt,
+			"next_run_at": nextRunAt,
+			"enabled":     nextRunAt != nil,
+		}).Error
+}
+
+// CreateRun records the outcome of a schedule fire
+func (r *ScheduleRepository) CreateRun(run *ScheduleRun) error {
+	return r.db.Create(run).Error
+}
+
+// GetRecentRuns fetches the latest schedule runs, newest first
+func (r *ScheduleRepository) GetRecentRuns(limit int) ([]ScheduleRun, error) {
+	var runs []ScheduleRun
+	err := r.db.Order("id DESC").Limit(limit).Find(&runs).Error
+	return runs, err
+}
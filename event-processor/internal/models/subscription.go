@@ -8,17 +8,18 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Subscription struct {
-	ID        int64     `gorm:"primaryKey" json:"id"`              // Primary key
-	UID       string    `gorm:"type:uuid;not null" json:"uid"`     // Unique user identifier
-	AppID     int       `gorm:"not null" json:"app_id"`            // App ID
-	Receipt   string    `gorm:"size:255;not null" json:"receipt"`  // Receipt string
-	Status    string    `gorm:"size:20;not null" json:"status"`    // Subscription status
-	ExpireAt  time.Time `gorm:"type:timestamptz" json:"expire_at"` // Expiration timestamp
-	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`  // Creation timestamp
-	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`  // Update timestamp
+	ID        int64              `gorm:"primaryKey" json:"id"`              // Primary key
+	UID       string             `gorm:"type:uuid;not null" json:"uid"`     // Unique user identifier
+	AppID     int                `gorm:"not null" json:"app_id"`            // App ID
+	Receipt   string             `gorm:"size:255;not null" json:"receipt"`  // Receipt string
+	Status    SubscriptionStatus `gorm:"size:20;not null" json:"status"`    // Subscription status
+	ExpireAt  time.Time          `gorm:"type:timestamptz" json:"expire_at"` // Expiration timestamp
+	CreatedAt time.Time          `gorm:"autoCreateTime" json:"created_at"`  // Creation timestamp
+	UpdatedAt time.Time          `gorm:"autoUpdateTime" json:"updated_at"`  // Update timestamp
 }
 
 // SubscriptionRepository manages subscription-related database operations
@@ -31,81 +32,284 @@ func NewSubscriptionRepository(db *gorm.DB) *SubscriptionRepository {
 	return &SubscriptionRepository{db: db}
 }
 
-// GetCountForProcessing fetches the count of records that need processing
-func (r *SubscriptionRepository) GetCountForProcessing() (int64, error) {
+// GetCountForProcessing counts the subscriptions matching the filter that need processing. Without expiry
+// bounds or Force it only reads the due queue rows that are due now, see SubscriptionFilter.Apply.
+func (r *SubscriptionRepository) GetCountForProcessing(filter SubscriptionFilter) (int64, error) {
 	var count int64
-	err := r.db.Model(&Subscription{}).
-		Where("expire_at <= NOW() AND status != ?", "canceled").
-		Order("id ASC").
+	err := filter.Apply(r.db.Model(&Subscription{})).
 		Count(&count).Error
 	return count, err
 }
 
-// UpdateSubscriptionStatus updates the status of a subscription
-func (r *SubscriptionRepository) UpdateSubscriptionStatus(subscriptionID int64, status string) error {
-	return r.db.Model(&Subscription{}).
-		Where("id = ?", subscriptionID).
-		Update("status", status).Error
+// PruneDueQueue deletes the due queue rows of subscriptions that expired longer ago than the give_up_after
+// of their app, no sweep checks them again. It returns the number of rows deleted.
+func (r *SubscriptionRepository) PruneDueQueue() (int64, error) {
+	result := r.db.Exec(`DELETE FROM subscriptions_due d USING app_validation_windows w
+		WHERE w.app_id = d.app_id AND w.give_up_after > 0 AND d.expire_at < NOW() - w.give_up_after * INTERVAL '1 second'`)
+	return result.RowsAffected, result.Error
 }
 
-func (r *SubscriptionRepository) FetchSubscriptionsForBatch(start int64, end int64) ([]Subscription, error) {
+// refreshDue recomputes the due queue rows of the subscriptions in tx, which moves them to their next check
+// or removes them once no sweep checks them again
+func refreshDue(tx *gorm.DB, subscriptionIDs []int64) error {
+	if len(subscriptionIDs) == 0 {
+		return nil
+	}
+	return tx.Exec("SELECT refresh_subscription_due(ARRAY[?]::BIGINT[])", subscriptionIDs).Error
+}
+
+// GetSubscriptionByID fetches a subscription by its ID
+func (r *SubscriptionRepository) GetSubscriptionByID(subscriptionID int64) (*Subscription, error) {
+	var subscription Subscription
+	err := r.db.Where("id = ?", subscriptionID).First(&subscription).Error
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// FindSubscriptionsByUID fetches the subscriptions of a user, optionally limited to one app
+func (r *SubscriptionRepository) FindSubscriptionsByUID(uid string, appID int) ([]Subscription, error) {
+	var subscriptions []Subscription
+	query := r.db.Where("uid = ?", uid)
+	if appID > 0 {
+		query = query.Where("app_id = ?", appID)
+	}
+	err := query.Order("id ASC").Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// TransitionSubscription moves a subscription to the status and records the transition in the history
+// and the outbox. It returns ErrInvalidSubscriptionTransition when the current status cannot move to it.
+func (r *SubscriptionRepository) TransitionSubscription(subscriptionID int64, status SubscriptionStatus, source string) (*Subscription, error) {
+	var subscription Subscription
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&subscription, subscriptionID).Error
+		if err != nil {
+			return err
+		}
+		if err := ValidateSubscriptionTransition(subscription.Status, status); err != nil {
+			return fmt.Errorf("subscription %d: %w", subscriptionID, err)
+		}
+		if subscription.Status == status {
+			return nil
+		}
+
+		event := SubscriptionEvent{
+			Status:         string(status),
+			SubscriptionID: subscription.ID,
+			UID:            subscription.UID,
+			AppID:          subscription.AppID,
+			OldStatus:      subscription.Status,
+			NewStatus:      status,
+			OldExpireAt:    subscription.ExpireAt,
+			NewExpireAt:    subscription.ExpireAt,
+			Source:         source,
+			OccurredAt:     time.Now(),
+		}
+		subscription.Status = status
+		err = tx.Model(&subscription).Where("id = ?", subscriptionID).Update("status", status).Error
+		if err != nil {
+			return err
+		}
+		return recordTransitions(tx, []SubscriptionEvent{event})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// GetSubscriptionsByIDs fetches the subscriptions with the given IDs in ID order
+func (r *SubscriptionRepository) GetSubscriptionsByIDs(ids []int64) ([]Subscription, error) {
+	var subscriptions []Subscription
+	if len(ids) == 0 {
+		return subscriptions, nil
+	}
+	err := r.db.Where("id IN ?", ids).Order("id ASC").Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// FetchSubscriptionsAfterKey fetches up to limit subscriptions matching the filter with an ID above lastKey,
+// which starts a batch after its key or continues it from its checkpoint, and up to endKey unless it is 0.
+// endKey keeps a batch from reading into the next one when subscriptions became due since it was split.
+func (r *SubscriptionRepository) FetchSubscriptionsAfterKey(filter SubscriptionFilter, lastKey int64, endKey int64, limit int64) ([]Subscription, error) {
 	var subscriptions []Subscription
+	if limit <= 0 {
+		return subscriptions, nil
+	}
 
-	// Fetch subscriptions based on the given range and processing criteria
-	err := r.db.Model(&Subscription{}).
-		Where("expire_at <= NOW() AND status != ?", "canceled").
-		Order("id ASC").
-		Offset(int(start - 1)).      // Subtract 1 because `start` is inclusive
-		Limit(int(end - start + 1)). // The range from start to end (inclusive)
+	query := filter.Apply(r.db.Model(&Subscription{})).
+		Where("d.subscription_id > ?", lastKey)
+	if endKey > 0 {
+		query = query.Where("d.subscription_id <= ?", endKey)
+	}
+	err := query.
+		Order("d.subscription_id ASC").
+		Limit(int(limit)).
 		Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// FetchBatchKeys splits the first total subscriptions matching the filter, in ID order, into batches of
+// batchSize and returns the ID of the last subscription of every batch but the last. A batch then reads
+// its subscriptions after the key of the previous one instead of skipping them with an OFFSET.
+func (r *SubscriptionRepository) FetchBatchKeys(filter SubscriptionFilter, total int64, batchSize int64) ([]int64, error) {
+	return r.batchKeys(filter.Apply(r.db.Model(&Subscription{})), "d.subscription_id", total, batchSize)
+}
 
+// GetCountForReconciliation fetches the count of subscriptions matching the reconciliation filter
+func (r *SubscriptionRepository) GetCountForReconciliation(filter ReconciliationFilter) (int64, error) {
+	var count int64
+	err := filter.Apply(r.db.Model(&Subscription{})).
+		Count(&count).Error
+	return count, err
+}
+
+// FetchReconciliationAfterKey fetches up to limit subscriptions matching the reconciliation filter with an
+// ID above lastKey, and up to endKey unless it is 0
+func (r *SubscriptionRepository) FetchReconciliationAfterKey(filter ReconciliationFilter, lastKey int64, endKey int64, limit int64) ([]Subscription, error) {
+	var subscriptions []Subscription
+	if limit <= 0 {
+		return subscriptions, nil
+	}
+
+	query := filter.Apply(r.db.Model(&Subscription{})).
+		Where("subscriptions.id > ?", lastKey)
+	if endKey > 0 {
+		query = query.Where("subscriptions.id <= ?", endKey)
+	}
+	err := query.
+		Order("subscriptions.id ASC").
+		Limit(int(limit)).
+		Find(&subscriptions).Error
 	return subscriptions, err
 }
 
-func (r *SubscriptionRepository) BulkUpdateSubscriptions(subscriptions []Subscription) error {
+// FetchReconciliationKeys is FetchBatchKeys for the subscriptions matching the reconciliation filter
+func (r *SubscriptionRepository) FetchReconciliationKeys(filter ReconciliationFilter, total int64, batchSize int64) ([]int64, error) {
+	return r.batchKeys(filter.Apply(r.db.Model(&Subscription{})), "subscriptions.id", total, batchSize)
+}
+
+// batchKeys returns the value of key for every batchSize-th of the first total rows of the query in key order,
+// except for the last batch
+func (r *SubscriptionRepository) batchKeys(query *gorm.DB, key string, total int64, batchSize int64) ([]int64, error) {
+	var keys []int64
+	if batchSize <= 0 || total <= batchSize {
+		return keys, nil
+	}
+
+	ordered := query.
+		Select(key + " AS batch_key, ROW_NUMBER() OVER (ORDER BY " + key + ") AS position").
+		Order(key + " ASC").
+		Limit(int(total))
+	err := r.db.Table("(?) AS ordered", ordered).
+		Where("position % ? = 0 AND position < ?", batchSize, total).
+		Order("position ASC").
+		Pluck("batch_key", &keys).Error
+	return keys, err
+}
+
+// BulkUpdateSubscriptions writes the status and expiry of the subscriptions, records the events in the
+// subscription history and puts them in the outbox, in one transaction. Subscriptions changed since they
+// were read are left alone, together with their events, and returned as conflicts.
+func (r *SubscriptionRepository) BulkUpdateSubscriptions(subscriptions []Subscription, events []SubscriptionEvent) ([]Subscription, error) {
+	var conflicts []Subscription
+	err := copyTransaction(r.db, func(tx *gorm.DB, copyFrom copyFromFunc) error {
+		var err error
+		if conflicts, err = bulkUpdateSubscriptions(tx, copyFrom, subscriptions); err != nil {
+			return err
+		}
+		return recordTransitions(tx, withoutConflicts(events, conflicts))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// subscriptionUpdatesTable stages the rows of a bulk update, it is dropped when the transaction ends
+const subscriptionUpdatesTable = "subscription_updates"
+
+// bulkUpdateSubscriptions writes the status and expiry of the subscriptions in tx. The rows are copied
+// into a temporary staging table and applied with a single UPDATE ... FROM joined on (id, app_id), so
+// batches of any size take two round trips and only touch the partitions of their apps. A row is only
+// written while its updated_at still equals the UpdatedAt the subscription was read with, so a sweep
+// never overwrites a newer change such as a purchase. A trigger stamps updated_at on every update with
+// the database clock, whatever the writer sets. The subscriptions that were changed in the meantime are
+// returned as conflicts.
+func bulkUpdateSubscriptions(tx *gorm.DB, copyFrom copyFromFunc, subscriptions []Subscription) ([]Subscription, error) {
 	if len(subscriptions) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	// Build a bulk update query
-	query := "UPDATE subscriptions SET status = CASE id "
-	idList := []interface{}{}
+	err := tx.Exec("CREATE TEMPORARY TABLE " + subscriptionUpdatesTable + ` (
+		id BIGINT NOT NULL,
+		app_id INTEGER NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		expire_at TIMESTAMPTZ,
+		read_at TIMESTAMPTZ NOT NULL
+	) ON COMMIT DROP`).Error
+	if err != nil {
+		return nil, err
+	}
 
-	// Construct CASE statements
+	rows := make([][]interface{}, 0, len(subscriptions))
 	for _, sub := range subscriptions {
-		query += "WHEN ? THEN ? "
-		idList = append(idList, sub.ID, sub.Status)
+		rows = append(rows, []interface{}{sub.ID, int32(sub.AppID), string(sub.Status), sub.ExpireAt, sub.UpdatedAt})
+	}
+	columns := []string{"id", "app_id", "status", "expire_at", "read_at"}
+	if _, err := copyFrom(subscriptionUpdatesTable, columns, rows); err != nil {
+		return nil, fmt.Errorf("failed to stage subscription updates: %w", err)
 	}
 
-	query += "END, expire_at = CASE id "
+	// Only rows nobody changed since they were read
+	var updated []int64
+	err = tx.Raw(`UPDATE subscriptions AS s
+		SET status = u.status, expire_at = u.expire_at
+		FROM ` + subscriptionUpdatesTable + ` AS u
+		WHERE s.id = u.id AND s.app_id = u.app_id AND s.updated_at = u.read_at
+		RETURNING s.id`).Scan(&updated).Error
+	if err != nil {
+		return nil, err
+	}
 
-	// Add CASE statements for expire_at
+	written := make(map[int64]bool, len(updated))
+	for _, id := range updated {
+		written[id] = true
+	}
+	var conflicts []Subscription
 	for _, sub := range subscriptions {
-		query += "WHEN ? THEN ? "
-		idList = append(idList, sub.ID, sub.ExpireAt)
+		if !written[sub.ID] {
+			conflicts = append(conflicts, sub)
+		}
 	}
+	return conflicts, nil
+}
 
-	query += "END, updated_at = ? WHERE id IN ("
-	idList = append(idList, time.Now().UTC())
+// withoutConflicts drops the events of subscriptions that were not written
+func withoutConflicts(events []SubscriptionEvent, conflicts []Subscription) []SubscriptionEvent {
+	if len(conflicts) == 0 {
+		return events
+	}
 
-	// Add IDs for the WHERE clause
-	for i, sub := range subscriptions {
-		if i > 0 {
-			query += ", "
+	skipped := make(map[int64]bool, len(conflicts))
+	for _, sub := range conflicts {
+		skipped[sub.ID] = true
+	}
+	var applied []SubscriptionEvent
+	for _, event := range events {
+		if !skipped[event.SubscriptionID] {
+			applied = append(applied, event)
 		}
-		query += "?"
-		idList = append(idList, sub.ID)
 	}
-	query += ");"
-
-	// Execute the raw query
-	return r.db.Exec(query, idList...).Error
+	return applied
 }
 
 // GenerateMockSubscriptions generates mock data for subscriptions
 func (r *SubscriptionRepository) GenerateMockSubscriptions(total int) error {
-	const chunkSize = 1000                               // Insert 10,000 rows per batch
-	statuses := []string{"active", "pending", "expired"} // Example statuses
+	const chunkSize = 1000                                                                                           // Insert 10,000 rows per batch
+	statuses := []SubscriptionStatus{SubscriptionStatusActive, SubscriptionStatusStarted, SubscriptionStatusExpired} // Example statuses, started replaces the legacy pending
 
 	rand.Seed(time.Now().UTC().UnixNano())
 
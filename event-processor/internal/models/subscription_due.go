@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// SubscriptionDue is the due queue entry of a subscription: when a sweep checks it next, the later of
+// validate_before ahead of its expiry and the re-check interval of its status after its last write. The
+// subscriptions_sync_due trigger keeps it in sync on every write, whether it comes from a sweep or the
+// purchase API, and sweeps recompute the entries they skip. Subscriptions no sweep checks again, canceled
+// ones and the ones their app's window gave up on, have no entry. Sweeps count and read the due subscriptions
+// through its due_at index instead of scanning the subscriptions table.
+type SubscriptionDue struct {
+	SubscriptionID int64     `gorm:"primaryKey;autoIncrement:false;index:subscriptions_due_due_at_idx,priority:2;index:subscriptions_due_expire_at_idx,priority:2" json:"subscription_id"`
+	AppID          int       `gorm:"not null" json:"app_id"`                                                                      // App of the subscription
+	ExpireAt       time.Time `gorm:"type:timestamptz;not null;index:subscriptions_due_expire_at_idx,priority:1" json:"expire_at"` // Expiry of the subscription
+	DueAt          time.Time `gorm:"type:timestamptz;not null;index:subscriptions_due_due_at_idx,priority:1" json:"due_at"`       // Next check of the subscription
+}
+
+// TableName returns the database table name for the SubscriptionDue model
+func (SubscriptionDue) TableName() string {
+	return "subscriptions_due"
+}
+
+// joinDue restricts a query on the subscriptions table to the subscriptions in the due queue, which
+// the filter conditions address as d
+const joinDue = "JOIN subscriptions_due d ON d.subscription_id = subscriptions.id"
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// SubscriptionEventsQueue carries subscription changes to the callback service
+const SubscriptionEventsQueue = "subscription_events"
+
+// Sources of a subscription event
+const (
+	SubscriptionEventSourceWorker            = "worker"             // Renewal sweeps
+	SubscriptionEventSourcePurchase          = "purchase"           // Purchases through the purchase API
+	SubscriptionEventSourceManual            = "manual"             // Changes made by an operator
+	SubscriptionEventSourceStoreNotification = "store_notification" // Server notifications of a store
+	SubscriptionEventSourceReconciliation    = "reconciliation"     // Corrections of reconciliations
+)
+
+// SubscriptionEvent is published to the subscription_events queue for every status transition, through
+// the outbox, and recorded in the subscription history. The callback service triggers the webhooks of
+// the app that subscribed to Status.
+type SubscriptionEvent struct {
+	Status         string             `json:"status"` // Webhook trigger, the outcome such as renewed or expired
+	SubscriptionID int64              `json:"subscription_id"`
+	UID            string             `json:"uid"`
+	AppID          int                `json:"app_id"`
+	OldStatus      SubscriptionStatus `json:"old_status"`
+	NewStatus      SubscriptionStatus `json:"new_status"`
+	OldExpireAt    time.Time          `json:"old_expire_at"`
+	NewExpireAt    time.Time          `json:"new_expire_at"`
+	Source         string             `json:"source"`                  // One of the SubscriptionEventSource constants
+	ActionID       int64              `json:"action_id"`               // Manager action of the sweep, 0 outside of sweeps
+	BatchID        int64              `json:"batch_id"`                // Batch of the sweep, 0 outside of sweeps
+	ResponseHash   string             `json:"response_hash,omitempty"` // SHA-256 of the raw store response that caused the change
+	OccurredAt     time.Time          `json:"occurred_at"`
+}
+
+// Changed reports whether the event changes the status or the expiry of the subscription
+func (e SubscriptionEvent) Changed() bool {
+	return e.OldStatus != e.NewStatus || !e.OldExpireAt.Equal(e.NewExpireAt)
+}
@@ -0,0 +1,196 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of a quarantined receipt
+const (
+	QuarantineStatusQuarantined = "quarantined" // Left out of sweeps until reviewed
+	QuarantineStatusRequeued    = "requeued"    // Picked up again by the next sweep
+	QuarantineStatusDismissed   = "dismissed"   // Reviewed and left out of sweeps for good
+)
+
+// ErrQuarantineConflict is returned when a quarantined receipt was already reviewed
+var ErrQuarantineConflict = errors.New("quarantined receipt was already reviewed")
+
+// SubscriptionFailure tracks a subscription whose receipt could not be processed. Sweeps leave it out
+// until NextRetryAt, it is removed once the subscription is processed or moved to the quarantine.
+type SubscriptionFailure struct {
+	SubscriptionID int64     `gorm:"primaryKey;autoIncrement:false" json:"subscription_id"`
+	ActionID       int64     `gorm:"not null" json:"action_id"`                       // Action of the last failed attempt
+	Attempts       int       `gorm:"not null;default:1" json:"attempts"`              // Failed attempts so far
+	LastError      string    `gorm:"type:text;not null;default:''" json:"last_error"` // Error of the last attempt
+	NextRetryAt    time.Time `gorm:"type:timestamptz;not null" json:"next_retry_at"`  // Sweeps skip the subscription until then
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`                // First failure
+	UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updated_at"`                // Last failure
+}
+
+// QuarantinedReceipt is a receipt that failed too often. It is left out of sweeps until it is re-queued.
+type QuarantinedReceipt struct {
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	SubscriptionID int64      `gorm:"not null" json:"subscription_id"`
+	Receipt        string     `gorm:"size:255;not null" json:"receipt"`
+	Attempts       int        `gorm:"not null" json:"attempts"`                        // Failed attempts before the quarantine
+	LastError      string     `gorm:"type:text;not null;default:''" json:"last_error"` // Error of the last attempt
+	Status         string     `gorm:"size:20;not null" json:"status"`                  // One of the QuarantineStatus constants
+	ReviewedBy     *string    `gorm:"size:255;default:null" json:"reviewed_by"`        // Who re-queued or dismissed the receipt
+	ReviewedAt     *time.Time `gorm:"default:null" json:"reviewed_at"`
+	Note           string     `gorm:"type:text;not null;default:''" json:"note"` // Why it was re-queued or dismissed
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`          // When the receipt was quarantined
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// ItemFailure is a subscription a batch attempt could not process
+type ItemFailure struct {
+	SubscriptionID int64
+	Error          string
+}
+
+// RetryPolicy decides when a failed subscription is retried and when it is quarantined
+type RetryPolicy struct {
+	MaxAttempts int           // Failed attempts after which the receipt is quarantined
+	Backoff     time.Duration // Wait after the first failure, doubled for every further failure
+	MaxBackoff  time.Duration // Upper bound of the wait
+}
+
+// recordFailures counts a failed attempt for each subscription, schedules its next retry and moves
+// the ones that reached the maximum attempts to the quarantine. db may be a transaction.
+func recordFailures(db *gorm.DB, actionID int64, failures []ItemFailure, policy RetryPolicy) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	rows := make([]SubscriptionFailure, len(failures))
+	ids := make([]int64, len(failures))
+	for i, failure := range failures {
+		rows[i] = SubscriptionFailure{
+			SubscriptionID: failure.SubscriptionID,
+			ActionID:       actionID,
+			Attempts:       1,
+			LastError:      failure.Error,
+			NextRetryAt:    now.Add(policy.Backoff),
+		}
+		ids[i] = failure.SubscriptionID
+	}
+
+	// The wait doubles with every attempt, the expressions see the attempts before this one
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "subscription_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"attempts":      gorm.Expr("subscription_failures.attempts + 1"),
+			"action_id":     gorm.Expr("EXCLUDED.action_id"),
+			"last_error":    gorm.Expr("EXCLUDED.last_error"),
+			"next_retry_at": gorm.Expr("NOW() + make_interval(secs => LEAST(? * POWER(2, subscription_failures.attempts), ?))", policy.Backoff.Seconds(), policy.MaxBackoff.Seconds()),
+			"updated_at":    now,
+		}),
+	}).Create(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	err = db.Exec(`
+		INSERT INTO quarantined_receipts (subscription_id, receipt, attempts, last_error, status, note, created_at, updated_at)
+		SELECT f.subscription_id, s.receipt, f.attempts, f.last_error, ?, '', NOW(), NOW()
+		FROM subscription_failures f
+		JOIN subscriptions s ON s.id = f.subscription_id
+		WHERE f.subscription_id IN ? AND f.attempts >= ?`,
+		QuarantineStatusQuarantined, ids, policy.MaxAttempts).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Where("subscription_id IN ? AND attempts >= ?", ids, policy.MaxAttempts).
+		Delete(&SubscriptionFailure{}).Error
+}
+
+// clearFailures forgets the failures of subscriptions that were processed. db may be a transaction.
+func clearFailures(db *gorm.DB, subscriptions []Subscription) error {
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(subscriptions))
+	for i, sub := range subscriptions {
+		ids[i] = sub.ID
+	}
+	return db.Where("subscription_id IN ?", ids).Delete(&SubscriptionFailure{}).Error
+}
+
+// SubscriptionFailureRepository manages failed subscriptions and the quarantine
+type SubscriptionFailureRepository struct {
+	db *gorm.DB
+}
+
+// NewSubscriptionFailureRepository creates a new instance of SubscriptionFailureRepository
+func NewSubscriptionFailureRepository(db *gorm.DB) *SubscriptionFailureRepository {
+	return &SubscriptionFailureRepository{db: db}
+}
+
+// ListFailures fetches a page of failed subscriptions waiting for a retry, next retry first, and the total count
+func (r *SubscriptionFailureRepository) ListFailures(limit int, offset int) ([]SubscriptionFailure, int64, error) {
+	var total int64
+	if err := r.db.Model(&SubscriptionFailure{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var failures []SubscriptionFailure
+	err := r.db.Order("next_retry_at ASC").Limit(limit).Offset(offset).Find(&failures).Error
+	return failures, total, err
+}
+
+// ListQuarantine fetches a page of quarantined receipts in any of the given statuses, newest first, and the total count
+func (r *SubscriptionFailureRepository) ListQuarantine(statuses []string, limit int, offset int) ([]QuarantinedReceipt, int64, error) {
+	query := r.db.Model(&QuarantinedReceipt{})
+	if len(statuses) > 0 {
+		query = query.Where("status IN ?", statuses)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var receipts []QuarantinedReceipt
+	err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&receipts).Error
+	return receipts, total, err
+}
+
+// ReviewQuarantined re-queues or dismisses a quarantined receipt and records who did it and why.
+// A re-queued receipt starts over with no failed attempts.
+func (r *SubscriptionFailureRepository) ReviewQuarantined(id int64, status string, actor string, note string) (*QuarantinedReceipt, error) {
+	var receipt QuarantinedReceipt
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&receipt, id).Error
+		if err != nil {
+			return err
+		}
+		if receipt.Status != QuarantineStatusQuarantined {
+			return fmt.Errorf("%w: receipt %d is %s", ErrQuarantineConflict, id, receipt.Status)
+		}
+
+		now := time.Now()
+		receipt.Status, receipt.ReviewedBy, receipt.ReviewedAt, receipt.Note = status, &actor, &now, note
+		err = tx.Model(&receipt).Updates(map[string]interface{}{
+			"status":      status,
+			"reviewed_by": actor,
+			"reviewed_at": now,
+			"note":        note,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("subscription_id = ?", receipt.SubscriptionID).Delete(&SubscriptionFailure{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SubscriptionFilter narrows down the subscriptions a renewal sweep re-validates.
+// The zero value matches every subscription within the validation window of its app that is not canceled,
+// quarantined or waiting for a retry.
+type SubscriptionFilter struct {
+	AppIDs          []int      `json:"app_ids,omitempty"`          // Only subscriptions of these apps
+	Store           string     `json:"store,omitempty"`            // Only apps of this store, e.g. "ios"
+	Statuses        []string   `json:"statuses,omitempty"`         // Only these statuses instead of everything, canceled subscriptions are never swept
+	ExpireFrom      *time.Time `json:"expire_from,omitempty"`      // Only subscriptions expiring at or after this time
+	ExpireTo        *time.Time `json:"expire_to,omitempty"`        // Only subscriptions expiring at or before this time, defaults to the validation window of their app
+	SubscriptionIDs []int64    `json:"subscription_ids,omitempty"` // Only these subscriptions
+	UIDs            []string   `json:"uids,omitempty"`             // Only subscriptions of these users
+	Force           bool       `json:"force,omitempty"`            // Re-check subscriptions updated within the re-check interval of their app or waiting for a retry
+}
+
+// Validate checks the filter for contradicting or malformed values
+func (f SubscriptionFilter) Validate() error {
+	if f.ExpireFrom != nil && f.ExpireTo != nil && f.ExpireFrom.After(*f.ExpireTo) {
+		return errors.New("expire_from must not be after expire_to")
+	}
+
+	for _, status := range f.Statuses {
+		parsed, err := ParseSubscriptionStatus(status)
+		if err != nil {
+			return err
+		}
+		if parsed == SubscriptionStatusCanceled {
+			return errors.New("canceled subscriptions are not in the due queue and cannot be swept")
+		}
+	}
+
+	for _, uid := range f.UIDs {
+		if _, err := uuid.Parse(uid); err != nil {
+			return fmt.Errorf("invalid uid %q: %w", uid, err)
+		}
+	}
+
+	return nil
+}
+
+// Due reports whether a subscription expiring at expireAt is within the expiry bounds of the filter at now.
+// Without an ExpireTo the validation window of the subscription's app decides.
+func (f SubscriptionFilter) Due(window ValidationWindow, expireAt time.Time, now time.Time) bool {
+	if f.ExpireFrom != nil && expireAt.Before(*f.ExpireFrom) {
+		return false
+	}
+	if f.ExpireTo != nil {
+		return !expireAt.After(*f.ExpireTo)
+	}
+	return window.Due(expireAt, now)
+}
+
+// MatchesStatus reports whether a subscription status is selected by the filter
+func (f SubscriptionFilter) MatchesStatus(status SubscriptionStatus) bool {
+	if len(f.Statuses) == 0 {
+		return status != SubscriptionStatusCanceled
+	}
+
+	for _, s := range f.statuses() {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// statuses returns the selected statuses with legacy names replaced by their successor
+func (f SubscriptionFilter) statuses() []SubscriptionStatus {
+	statuses := make([]SubscriptionStatus, 0, len(f.Statuses))
+	for _, s := range f.Statuses {
+		status, err := ParseSubscriptionStatus(s)
+		if err != nil {
+			status = SubscriptionStatus(s) // Rejected by Validate, matches nothing
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Apply adds the filter conditions to a query on the subscriptions table. The query is joined with the
+// due queue, so only subscriptions a sweep can still check match, and the due time or expiry bounds only
+// read the due part of its indexes instead of the whole table.
+func (f SubscriptionFilter) Apply(query *gorm.DB) *gorm.DB {
+	query = query.Joins(joinDue)
+
+	if f.ExpireTo != nil {
+		query = query.Where("d.expire_at <= ?", *f.ExpireTo)
+	} else {
+		query = query.Joins("LEFT JOIN app_validation_windows w ON w.app_id = d.app_id").
+			Where("(COALESCE(w.give_up_after, ?) = 0 OR d.expire_at >= NOW() - COALESCE(w.give_up_after, ?) * INTERVAL '1 second')",
+				DefaultValidationWindow.GiveUpAfter, DefaultValidationWindow.GiveUpAfter)
+		if f.Force {
+			// Forced sweeps don't wait for the re-check interval the due time includes, the widest window bounds
+			// the index scan and the window of each app narrows it down
+			query = query.
+				Where("d.expire_at <= NOW() + GREATEST((SELECT MAX(validate_before) FROM app_validation_windows), ?) * INTERVAL '1 second'", DefaultValidationWindow.ValidateBefore).
+				Where("d.expire_at <= NOW() + COALESCE(w.validate_before, ?) * INTERVAL '1 second'", DefaultValidationWindow.ValidateBefore)
+		} else {
+			query = query.Where("d.due_at <= NOW()")
+		}
+	}
+
+	if f.ExpireFrom != nil {
+		query = query.Where("d.expire_at >= ?", *f.ExpireFrom)
+	}
+
+	if len(f.Statuses) > 0 {
+		query = query.Where("subscriptions.status IN ?", f.statuses())
+	} else {
+		query = query.Where("subscriptions.status != ?", SubscriptionStatusCanceled)
+	}
+
+	if len(f.AppIDs) > 0 {
+		query = query.Where("d.app_id IN ?", f.AppIDs)
+	}
+
+	if f.Store != "" {
+		query = query.Where("d.app_id IN (SELECT id FROM apps WHERE store = ?)", f.Store)
+	}
+
+	if len(f.SubscriptionIDs) > 0 {
+		query = query.Where("d.subscription_id IN ?", f.SubscriptionIDs)
+	}
+
+	if len(f.UIDs) > 0 {
+		query = query.Where("subscriptions.uid IN ?", f.UIDs)
+	}
+
+	// Quarantined receipts are left out until re-queued, failed ones until their next retry unless forced
+	query = query.Where("NOT EXISTS (SELECT 1 FROM quarantined_receipts q WHERE q.subscription_id = subscriptions.id AND q.status IN ?)",
+		[]string{QuarantineStatusQuarantined, QuarantineStatusDismissed})
+	if !f.Force {
+		query = query.Where("NOT EXISTS (SELECT 1 FROM subscription_failures sf WHERE sf.subscription_id = subscriptions.id AND sf.next_retry_at > NOW())")
+	}
+
+	return query
+}
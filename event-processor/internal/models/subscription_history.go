@@ -0,0 +1,168 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SubscriptionHistory is one status or expiry transition of a subscription. The table is partitioned
+// by month of created_at, so lookups should be limited to a time range where possible.
+type SubscriptionHistory struct {
+	ID             int64              `gorm:"primaryKey;autoIncrement" json:"id"`
+	SubscriptionID int64              `gorm:"not null" json:"subscription_id"`
+	FromStatus     SubscriptionStatus `gorm:"size:50;not null" json:"from_status"`
+	ToStatus       SubscriptionStatus `gorm:"size:50;not null" json:"to_status"`
+	FromExpireAt   time.Time          `gorm:"type:timestamptz;not null" json:"from_expire_at"`
+	ToExpireAt     time.Time          `gorm:"type:timestamptz;not null" json:"to_expire_at"`
+	Reason         string             `gorm:"size:50;not null;default:''" json:"reason"`        // Outcome that caused the transition, such as renewed or expired
+	Source         string             `gorm:"size:50;not null" json:"source"`                   // One of the SubscriptionEventSource constants
+	ActionID       *int64             `gorm:"default:null" json:"action_id"`                    // Manager action of the sweep
+	BatchID        *int64             `gorm:"default:null" json:"batch_id"`                     // Batch of the sweep
+	ResponseHash   string             `gorm:"size:64;not null;default:''" json:"response_hash"` // SHA-256 of the raw store response
+	CreatedAt      time.Time          `gorm:"primaryKey;autoCreateTime" json:"created_at"`
+}
+
+// TableName keeps the table name singular
+func (SubscriptionHistory) TableName() string {
+	return "subscription_history"
+}
+
+// SubscriptionHistoryFilter narrows a history lookup, at least a subscription or a user is required
+type SubscriptionHistoryFilter struct {
+	SubscriptionID int64      // Only transitions of this subscription
+	UID            string     // Only transitions of the subscriptions of this user
+	AppID          int        // Only transitions of subscriptions of this app, with UID
+	Sources        []string   // Only transitions from these sources
+	From           *time.Time // Only transitions at or after this time
+	To             *time.Time // Only transitions before this time
+	Limit          int
+	Offset         int
+}
+
+// recordTransitions writes the events to the subscription history and to the outbox. db should be the
+// transaction of the subscription updates the events describe.
+func recordTransitions(db *gorm.DB, events []SubscriptionEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	rows := make([]SubscriptionHistory, len(events))
+	for i, event := range events {
+		rows[i] = SubscriptionHistory{
+			SubscriptionID: event.SubscriptionID,
+			FromStatus:     event.OldStatus,
+			ToStatus:       event.NewStatus,
+			FromExpireAt:   event.OldExpireAt,
+			ToExpireAt:     event.NewExpireAt,
+			Reason:         event.Status,
+			Source:         event.Source,
+			ActionID:       optionalID(event.ActionID),
+			BatchID:        optionalID(event.BatchID),
+			ResponseHash:   event.ResponseHash,
+			CreatedAt:      event.OccurredAt,
+		}
+	}
+	if err := db.Create(&rows).Error; err != nil {
+		return err
+	}
+
+	return enqueueSubscriptionEvents(db, events)
+}
+
+// optionalID stores unset IDs as NULL
+func optionalID(id int64) *int64 {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+// SubscriptionHistoryRepository looks up subscription transitions
+type SubscriptionHistoryRepository struct {
+	db *gorm.DB
+}
+
+// NewSubscriptionHistoryRepository creates a new instance of SubscriptionHistoryRepository
+func NewSubscriptionHistoryRepository(db *gorm.DB) *SubscriptionHistoryRepository {
+	return &SubscriptionHistoryRepository{db: db}
+}
+
+// ListHistory fetches a page of transitions matching the filter, newest first, and the total count
+func (r *SubscriptionHistoryRepository) ListHistory(filter SubscriptionHistoryFilter) ([]SubscriptionHistory, int64, error) {
+	query := r.db.Model(&SubscriptionHistory{})
+	if filter.SubscriptionID != 0 {
+		query = query.Where("subscription_id = ?", filter.SubscriptionID)
+	}
+	if filter.UID != "" {
+		subscriptions := r.db.Model(&Subscription{}).Select("id").Where("uid = ?", filter.UID)
+		if filter.AppID != 0 {
+			subscriptions = subscriptions.Where("app_id = ?", filter.AppID)
+		}
+		query = query.Where("subscription_id IN (?)", subscriptions)
+	}
+	if len(filter.Sources) > 0 {
+		query = query.Where("source IN ?", filter.Sources)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var history []SubscriptionHistory
+	err := query.Order("created_at DESC, id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&history).Error
+	return history, total, err
+}
+
+// EnsurePartitions creates the monthly partitions of the subscription history from the month of now
+// through the given number of months ahead. Existing partitions are left alone.
+func (r *SubscriptionHistoryRepository) EnsurePartitions(now time.Time, monthsAhead int) error {
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= monthsAhead; i++ {
+		from := start.AddDate(0, i, 0)
+		if err := r.createPartition(from, from.AddDate(0, 1, 0)); err != nil {
+			return fmt.Errorf("failed to create partition for %s: %w", from.Format("2006-01"), err)
+		}
+	}
+	return nil
+}
+
+// createPartition creates the partition of the subscription history from from to to unless it exists.
+// Transitions written to the default partition while it was missing are moved into it, as Postgres
+// refuses to add a partition whose rows are in the default partition.
+func (r *SubscriptionHistoryRepository) createPartition(from time.Time, to time.Time) error {
+	name := "subscription_history_" + from.Format("2006_01")
+	lower, upper := from.Format(time.RFC3339), to.Format(time.RFC3339)
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var exists bool
+		if err := tx.Raw("SELECT to_regclass(?) IS NOT NULL", name).Scan(&exists).Error; err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+
+		statements := []string{
+			fmt.Sprintf("CREATE TABLE %s (LIKE subscription_history INCLUDING DEFAULTS)", name),
+			fmt.Sprintf(
+				"WITH moved AS (DELETE FROM subscription_history_default WHERE created_at >= '%s' AND created_at < '%s' RETURNING *) INSERT INTO %s SELECT * FROM moved",
+				lower, upper, name,
+			),
+			fmt.Sprintf("ALTER TABLE subscription_history ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')", name, lower, upper),
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
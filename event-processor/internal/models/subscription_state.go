@@ -0,0 +1,144 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SubscriptionStatus is the state of a subscription. Every status either grants access to the
+// subscribed content or not, see Entitled.
+type SubscriptionStatus string
+
+// Statuses of a subscription
+const (
+	SubscriptionStatusStarted      SubscriptionStatus = "started"       // First purchase, or a purchase after a cancellation
+	SubscriptionStatusActive       SubscriptionStatus = "active"        // Confirmed by the store
+	SubscriptionStatusRenewed      SubscriptionStatus = "renewed"       // Purchased again after it expired
+	SubscriptionStatusGracePeriod  SubscriptionStatus = "grace_period"  // Payment failed, access is kept while the store retries
+	SubscriptionStatusBillingRetry SubscriptionStatus = "billing_retry" // Payment failed, the store retries without access
+	SubscriptionStatusOnHold       SubscriptionStatus = "on_hold"       // Billing retry ran out, waiting for the user to fix the payment
+	SubscriptionStatusPaused       SubscriptionStatus = "paused"        // Paused by the user until a resume date
+	SubscriptionStatusExpired      SubscriptionStatus = "expired"       // Ran out without a renewal
+	SubscriptionStatusCanceled     SubscriptionStatus = "canceled"      // Canceled by the user
+	SubscriptionStatusRevoked      SubscriptionStatus = "revoked"       // Refunded or revoked by the store
+)
+
+var (
+	// ErrUnknownSubscriptionStatus is returned for statuses that are not part of the state model
+	ErrUnknownSubscriptionStatus = errors.New("unknown subscription status")
+	// ErrInvalidSubscriptionTransition is returned when a subscription cannot move from its status to another
+	ErrInvalidSubscriptionTransition = errors.New("invalid subscription status transition")
+)
+
+// entitledStatuses are the statuses that grant access
+var entitledStatuses = map[SubscriptionStatus]bool{
+	SubscriptionStatusStarted:     true,
+	SubscriptionStatusActive:      true,
+	SubscriptionStatusRenewed:     true,
+	SubscriptionStatusGracePeriod: true,
+}
+
+// subscriptionTransitions lists the statuses each status can move to. Staying in a status is always
+// allowed, e.g. to extend the expiry.
+var subscriptionTransitions = map[SubscriptionStatus][]SubscriptionStatus{
+	SubscriptionStatusStarted:      {SubscriptionStatusActive, SubscriptionStatusRenewed, SubscriptionStatusGracePeriod, SubscriptionStatusBillingRetry, SubscriptionStatusPaused, SubscriptionStatusExpired, SubscriptionStatusCanceled, SubscriptionStatusRevoked},
+	SubscriptionStatusActive:       {SubscriptionStatusRenewed, SubscriptionStatusGracePeriod, SubscriptionStatusBillingRetry, SubscriptionStatusPaused, SubscriptionStatusExpired, SubscriptionStatusCanceled, SubscriptionStatusRevoked},
+	SubscriptionStatusRenewed:      {SubscriptionStatusActive, SubscriptionStatusGracePeriod, SubscriptionStatusBillingRetry, SubscriptionStatusPaused, SubscriptionStatusExpired, SubscriptionStatusCanceled, SubscriptionStatusRevoked},
+	SubscriptionStatusGracePeriod:  {SubscriptionStatusActive, SubscriptionStatusRenewed, SubscriptionStatusBillingRetry, SubscriptionStatusOnHold, SubscriptionStatusExpired, SubscriptionStatusCanceled, SubscriptionStatusRevoked},
+	SubscriptionStatusBillingRetry: {SubscriptionStatusActive, SubscriptionStatusRenewed, SubscriptionStatusOnHold, SubscriptionStatusExpired, SubscriptionStatusCanceled, SubscriptionStatusRevoked},
+	SubscriptionStatusOnHold:       {SubscriptionStatusActive, SubscriptionStatusRenewed, SubscriptionStatusExpired, SubscriptionStatusCanceled, SubscriptionStatusRevoked},
+	SubscriptionStatusPaused:       {SubscriptionStatusActive, SubscriptionStatusRenewed, SubscriptionStatusExpired, SubscriptionStatusCanceled, SubscriptionStatusRevoked},
+	SubscriptionStatusExpired:      {SubscriptionStatusStarted, SubscriptionStatusActive, SubscriptionStatusRenewed, SubscriptionStatusCanceled, SubscriptionStatusRevoked},
+	SubscriptionStatusCanceled:     {SubscriptionStatusStarted, SubscriptionStatusActive, SubscriptionStatusExpired, SubscriptionStatusRevoked},
+	SubscriptionStatusRevoked:      {SubscriptionStatusStarted},
+}
+
+// legacySubscriptionStatuses maps the statuses written before the state model to their successor.
+// The subscriptions_normalize_status trigger rewrites them on every write, see 00_init.sql.
+var legacySubscriptionStatuses = map[string]SubscriptionStatus{
+	"pending": SubscriptionStatusStarted,
+}
+
+// ParseSubscriptionStatus returns the status with the given name, legacy names return their successor
+func ParseSubscriptionStatus(value string) (SubscriptionStatus, error) {
+	if status, ok := legacySubscriptionStatuses[value]; ok {
+		return status, nil
+	}
+	status := SubscriptionStatus(value)
+	if _, ok := subscriptionTransitions[status]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownSubscriptionStatus, value)
+	}
+	return status, nil
+}
+
+// Entitled reports whether a subscription in the status grants access
+func (s SubscriptionStatus) Entitled() bool {
+	return entitledStatuses[s]
+}
+
+// EntitledStatuses returns the statuses that grant access, in the order of the state model
+func EntitledStatuses() []SubscriptionStatus {
+	return []SubscriptionStatus{SubscriptionStatusStarted, SubscriptionStatusActive, SubscriptionStatusRenewed, SubscriptionStatusGracePeriod}
+}
+
+// InGrace reports whether a subscription in the status is waiting for the store to collect a failed payment
+func (s SubscriptionStatus) InGrace() bool {
+	return s == SubscriptionStatusGracePeriod || s == SubscriptionStatusBillingRetry || s == SubscriptionStatusOnHold
+}
+
+// CanTransitionTo reports whether a subscription can move from s to the status
+func (s SubscriptionStatus) CanTransitionTo(to SubscriptionStatus) bool {
+	if s == to {
+		_, known := subscriptionTransitions[s]
+		return known
+	}
+	for _, allowed := range subscriptionTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateSubscriptionTransition returns an error that names both statuses when a subscription cannot
+// move from one to the other
+func ValidateSubscriptionTransition(from SubscriptionStatus, to SubscriptionStatus) error {
+	from, err := ParseSubscriptionStatus(string(from))
+	if err != nil {
+		return err
+	}
+	to, err = ParseSubscriptionStatus(string(to))
+	if err != nil {
+		return err
+	}
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidSubscriptionTransition, from, to)
+	}
+	return nil
+}
+
+// StoreStatus maps a store validation result to a subscription status. Stores that report a state
+// (grace_period, billing_retry, on_hold, paused, expired or revoked) are taken at their word, otherwise
+// a valid receipt means active and an invalid one expired.
+func StoreStatus(valid bool, state string) (SubscriptionStatus, error) {
+	if state == "" {
+		if valid {
+			return SubscriptionStatusActive, nil
+		}
+		return SubscriptionStatusExpired, nil
+	}
+
+	status, err := ParseSubscriptionStatus(state)
+	if err != nil {
+		return "", fmt.Errorf("store reported %w", err)
+	}
+	switch status {
+	case SubscriptionStatusStarted, SubscriptionStatusRenewed, SubscriptionStatusCanceled:
+		// Purchase and cancellation states are set by the purchase API, not by the store
+		return "", fmt.Errorf("%w: store cannot report %s", ErrUnknownSubscriptionStatus, status)
+	}
+	if status.Entitled() != valid {
+		return "", fmt.Errorf("store reported %s with status %t", status, valid)
+	}
+	return status, nil
+}
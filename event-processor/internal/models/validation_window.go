@@ -0,0 +1,125 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidValidationWindow is returned for validation windows with negative or missing durations
+var ErrInvalidValidationWindow = errors.New("invalid validation window")
+
+// ValidationWindow tells sweeps when to re-validate the subscriptions of an app. Durations are in seconds.
+// Apps without a window of their own use DefaultValidationWindow.
+type ValidationWindow struct {
+	AppID                int       `gorm:"primaryKey;autoIncrement:false" json:"app_id"`
+	ValidateBefore       int64     `gorm:"not null;default:0" json:"validate_before"`           // Time before the expiry from which subscriptions are re-validated
+	RecheckInterval      int64     `gorm:"not null;default:1800" json:"recheck_interval"`       // Minimum time between two checks of a subscription
+	GraceRecheckInterval int64     `gorm:"not null;default:1800" json:"grace_recheck_interval"` // Minimum time between two checks while a payment is retried
+	GiveUpAfter          int64     `gorm:"not null;default:0" json:"give_up_after"`             // Time after the expiry after which checks stop, 0 keeps checking
+	UpdatedAt            time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// TableName returns the database table name for the ValidationWindow model
+func (ValidationWindow) TableName() string {
+	return "app_validation_windows"
+}
+
+// DefaultValidationWindow re-validates subscriptions once they expired, at most every 30 minutes and without giving up
+var DefaultValidationWindow = ValidationWindow{RecheckInterval: 1800, GraceRecheckInterval: 1800}
+
+// Validate checks the window for negative or missing durations
+func (w ValidationWindow) Validate() error {
+	if w.ValidateBefore < 0 || w.GiveUpAfter < 0 {
+		return fmt.Errorf("%w: validate_before and give_up_after must not be negative", ErrInvalidValidationWindow)
+	}
+	if w.RecheckInterval <= 0 || w.GraceRecheckInterval <= 0 {
+		return fmt.Errorf("%w: recheck_interval and grace_recheck_interval must be positive", ErrInvalidValidationWindow)
+	}
+	return nil
+}
+
+// RecheckAfter returns the minimum time between two checks of a subscription in the status
+func (w ValidationWindow) RecheckAfter(status SubscriptionStatus) time.Duration {
+	if status.InGrace() {
+		return time.Duration(w.GraceRecheckInterval) * time.Second
+	}
+	return time.Duration(w.RecheckInterval) * time.Second
+}
+
+// Due reports whether a subscription expiring at expireAt is re-validated at now
+func (w ValidationWindow) Due(expireAt time.Time, now time.Time) bool {
+	if expireAt.After(now.Add(time.Duration(w.ValidateBefore) * time.Second)) {
+		return false
+	}
+	return w.GiveUpAfter == 0 || !expireAt.Before(now.Add(-time.Duration(w.GiveUpAfter)*time.Second))
+}
+
+// ValidationWindowRepository manages the validation windows of apps
+type ValidationWindowRepository struct {
+	db *gorm.DB
+}
+
+// NewValidationWindowRepository creates a new instance of ValidationWindowRepository
+func NewValidationWindowRepository(db *gorm.DB) *ValidationWindowRepository {
+	return &ValidationWindowRepository{db: db}
+}
+
+// ListWindows fetches the windows of all apps that have one, in app order
+func (r *ValidationWindowRepository) ListWindows() ([]ValidationWindow, error) {
+	var windows []ValidationWindow
+	err := r.db.Order("app_id ASC").Find(&windows).Error
+	return windows, err
+}
+
+// GetWindow fetches the window of an app, or the default window when the app has none
+func (r *ValidationWindowRepository) GetWindow(appID int) (ValidationWindow, error) {
+	windows, err := r.GetWindows([]int{appID})
+	if err != nil {
+		return ValidationWindow{}, err
+	}
+	return windows[appID], nil
+}
+
+// GetWindows fetches the windows of the apps by app ID, apps without a window get the default window
+func (r *ValidationWindowRepository) GetWindows(appIDs []int) (map[int]ValidationWindow, error) {
+	windows := make(map[int]ValidationWindow, len(appIDs))
+	if len(appIDs) == 0 {
+		return windows, nil
+	}
+
+	var found []ValidationWindow
+	if err := r.db.Where("app_id IN ?", appIDs).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for _, appID := range appIDs {
+		window := DefaultValidationWindow
+		window.AppID = appID
+		windows[appID] = window
+	}
+	for _, window := range found {
+		windows[window.AppID] = window
+	}
+	return windows, nil
+}
+
+// SaveWindow creates or replaces the window of an app
+func (r *ValidationWindowRepository) SaveWindow(window ValidationWindow) (ValidationWindow, error) {
+	if err := window.Validate(); err != nil {
+		return window, err
+	}
+
+	err := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "app_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"validate_before", "recheck_interval", "grace_recheck_interval", "give_up_after", "updated_at"}),
+	}).Create(&window).Error
+	return window, err
+}
+
+// DeleteWindow removes the window of an app, which falls back to the default window
+func (r *ValidationWindowRepository) DeleteWindow(appID int) error {
+	return r.db.Where("app_id = ?", appID).Delete(&ValidationWindow{}).Error
+}
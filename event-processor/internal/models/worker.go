@@ -1,24 +1,72 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"slices"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Worker statuses
+const (
+	WorkerStatusRegistering = "registering" // Registered, not claiming batches yet
+	WorkerStatusIdle        = "idle"        // Waiting for batches
+	WorkerStatusProcessing  = "processing"  // Processing one or more batches
+	WorkerStatusPaused      = "paused"      // Claims no batches until resumed
+	WorkerStatusDraining    = "draining"    // Finishing the batches in progress, then takes no more
+	WorkerStatusDrained     = "drained"     // Drained and waiting for a resume
+	WorkerStatusStopped     = "stopped"     // Shut down, final
+	WorkerStatusStale       = "stale"       // Marked by the manager after missing heartbeats
+)
+
+// workerTransitions lists the statuses a worker may move to from each status. A stale worker
+// that sends a status again is alive after all and may go back to any running status.
+var workerTransitions = map[string][]string{
+	WorkerStatusRegistering: {WorkerStatusIdle, WorkerStatusStopped, WorkerStatusStale},
+	WorkerStatusIdle:        {WorkerStatusProcessing, WorkerStatusPaused, WorkerStatusDraining, WorkerStatusDrained, WorkerStatusStopped, WorkerStatusStale},
+	WorkerStatusProcessing:  {WorkerStatusIdle, WorkerStatusPaused, WorkerStatusDraining, WorkerStatusStopped, WorkerStatusStale},
+	WorkerStatusPaused:      {WorkerStatusIdle, WorkerStatusProcessing, WorkerStatusDraining, WorkerStatusDrained, WorkerStatusStopped, WorkerStatusStale},
+	WorkerStatusDraining:    {WorkerStatusDrained, WorkerStatusIdle, WorkerStatusProcessing, WorkerStatusPaused, WorkerStatusStopped, WorkerStatusStale},
+	WorkerStatusDrained:     {WorkerStatusIdle, WorkerStatusPaused, WorkerStatusDraining, WorkerStatusStopped, WorkerStatusStale},
+	WorkerStatusStale:       {WorkerStatusIdle, WorkerStatusProcessing, WorkerStatusPaused, WorkerStatusDraining, WorkerStatusDrained, WorkerStatusStopped},
+	WorkerStatusStopped:     {},
+}
+
+// ErrInvalidWorkerTransition is returned when a worker status change is not allowed by the lifecycle
+var ErrInvalidWorkerTransition = errors.New("invalid worker status transition")
+
+// CanTransitionWorker reports whether a worker may move from one status to another, staying in a status is always allowed
+func CanTransitionWorker(from string, to string) bool {
+	return from == to || slices.Contains(workerTransitions[from], to)
+}
+
 // Worker represents a worker in the system
 type Worker struct {
 	ID             int64     `gorm:"primaryKey" json:"id"`
-	WorkerID       string    `gorm:"type:uuid;not null;unique" json:"worker_id"` // Unique worker instance identifier
-	Status         string    `gorm:"size:20;not null" json:"status"`             // Worker status: "idle", "processing", "stale"
-	LastHeartbeat  time.Time `gorm:"type:timestamptz" json:"last_heartbeat"`     // Timestamp of the last heartbeat
-	ActionID       *int64    `gorm:"default:null" json:"action_id"`              // Reference to the current manager action
-	CurrentBatchID *int64    `gorm:"default:null" json:"current_batch_id"`       // Reference to the batch being processed
-	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`           // Creation timestamp
-	UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updated_at"`           // Update timestamp
+	WorkerID       string    `gorm:"type:uuid;not null;unique" json:"worker_id"`     // Unique worker instance identifier
+	Status         string    `gorm:"size:20;not null" json:"status"`                 // One of the WorkerStatus constants
+	Concurrency    int       `gorm:"not null;default:1" json:"concurrency"`          // Number of batches processed at once
+	Labels         Labels    `gorm:"type:jsonb;not null;default:'{}'" json:"labels"` // What the worker can serve, e.g. store, region, apps, version, hostname
+	LastHeartbeat  time.Time `gorm:"type:timestamptz" json:"last_heartbeat"`         // Timestamp of the last heartbeat
+	ActionID       *int64    `gorm:"default:null" json:"action_id"`                  // Reference to the current manager action
+	CurrentBatchID *int64    `gorm:"default:null" json:"current_batch_id"`           // Reference to the batch being processed
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`               // Creation timestamp
+	UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updated_at"`               // Update timestamp
+	RecentBatches  []Batch   `gorm:"-" json:"recent_batches,omitempty"`              // Non-Gorm field
+	// Non-Gorm field, the latest commands sent to the worker
+	RecentCommands []WorkerCommand `gorm:"-" json:"recent_commands,omitempty"`
 }
 
+// ActiveWorkerStatuses are the statuses of workers that are running, whether or not they take batches
+var ActiveWorkerStatuses = []string{WorkerStatusRegistering, WorkerStatusIdle, WorkerStatusProcessing, WorkerStatusPaused, WorkerStatusDraining, WorkerStatusDrained}
+
+// deadWorkerStatuses are the statuses of workers whose rows are pruned once their heartbeat is old enough
+var deadWorkerStatuses = []string{WorkerStatusStopped, WorkerStatusStale}
+
 // WorkerRepository handles operations related to the Worker model
 type WorkerRepository struct {
 	db *gorm.DB
@@ -35,8 +83,8 @@ func (r *WorkerRepository) GetActiveWorkers() ([]Worker, error) {
 	// Calculate the threshold for heartbeat (10 minutes ago)
 	heartbeatThreshold := time.Now().Add(-10 * time.Minute)
 
-	// Query workers with status "idle" or "processing" and a recent heartbeat
-	err := r.db.Where("status IN ?", []string{"idle", "processing"}).
+	// Query running workers with a recent heartbeat
+	err := r.db.Where("status IN ?", ActiveWorkerStatuses).
 		Where("last_heartbeat > ?", heartbeatThreshold).
 		Find(&workers).Error
 
@@ -47,15 +95,74 @@ func (r *WorkerRepository) GetActiveWorkers() ([]Worker, error) {
 	return workers, nil
 }
 
-// RegisterWorker registers a new worker in the database
-func (r *WorkerRepository) RegisterWorker(workerID string) (*Worker, error) {
+// ListWorkers fetches a page of workers in any of the given statuses, most recently seen first, and the total count
+func (r *WorkerRepository) ListWorkers(statuses []string, limit int, offset int) ([]Worker, int64, error) {
+	query := r.db.Model(&Worker{})
+	if len(statuses) > 0 {
+		query = query.Where("status IN ?", statuses)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var workers []Worker
+	err := query.Order("last_heartbeat DESC").Limit(limit).Offset(offset).Find(&workers).Error
+	return workers, total, err
+}
+
+// GetWorkersByIDs fetches the workers with the given primary keys
+func (r *WorkerRepository) GetWorkersByIDs(ids []int64) ([]Worker, error) {
+	var workers []Worker
+	if len(ids) == 0 {
+		return workers, nil
+	}
+	err := r.db.Where("id IN ?", ids).Order("id ASC").Find(&workers).Error
+	return workers, err
+}
+
+// GetWorkersByWorkerIDs fetches the workers with the given instance identifiers
+func (r *WorkerRepository) GetWorkersByWorkerIDs(workerIDs []string) ([]Worker, error) {
+	var workers []Worker
+	if len(workerIDs) == 0 {
+		return workers, nil
+	}
+	err := r.db.Where("worker_id IN ?", workerIDs).Order("id ASC").Find(&workers).Error
+	return workers, err
+}
+
+// GetWorkerByWorkerID fetches a worker by its instance identifier
+func (r *WorkerRepository) GetWorkerByWorkerID(workerID string) (*Worker, error) {
+	var worker Worker
+	err := r.db.Where("worker_id = ?", workerID).First(&worker).Error
+	if err != nil {
+		return nil, err
+	}
+	return &worker, nil
+}
+
+// RegisterWorker registers a new worker in the database with the registering status
+func (r *WorkerRepository) RegisterWorker(workerID string, concurrency int, labels Labels) (*Worker, error) {
 	worker := Worker{
 		WorkerID:      workerID,
-		Status:        "idle",
+		Status:        WorkerStatusRegistering,
+		Concurrency:   concurrency,
+		Labels:        labels,
 		LastHeartbeat: time.Now(),
 	}
 
-	if err := r.db.Create(&worker).Error; err != nil {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&worker).Error; err != nil {
+			return err
+		}
+		return tx.Create(&WorkerEvent{
+			WorkerID: workerID,
+			ToStatus: WorkerStatusRegistering,
+			Reason:   "Registered",
+		}).Error
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -70,29 +177,62 @@ func (r *WorkerRepository) UpdateHeartbeat(workerID string) error {
 		Update("last_heartbeat", time.Now()).Error
 }
 
-// UpdateWorkerStatus updates the status of a worker
-func (r *WorkerRepository) UpdateWorkerStatus(workerID string, status string, currentBatchId int64) (*Worker, error) {
-	// Update the worker
-	err := r.db.Model(&Worker{}).
-		Where("worker_id = ?", workerID).
-		Updates(map[string]interface{}{
-			"current_batch_id": currentBatchId,
+// TransitionWorker moves a worker to a new status and sets its current batch, a nil batch clears it.
+// Transitions the lifecycle does not allow fail with ErrInvalidWorkerTransition, every status change is
+// recorded as a worker event with the given reason.
+func (r *WorkerRepository) TransitionWorker(workerID string, status string, currentBatchID *int64, reason string) (*Worker, error) {
+	var worker Worker
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("worker_id = ?", workerID).
+			First(&worker).Error
+		if err != nil {
+			return err
+		}
+
+		if !CanTransitionWorker(worker.Status, status) {
+			return fmt.Errorf("%w: worker %s cannot go from %s to %s", ErrInvalidWorkerTransition, workerID, worker.Status, status)
+		}
+
+		if worker.Status != status {
+			err = tx.Create(&WorkerEvent{
+				WorkerID:   workerID,
+				FromStatus: worker.Status,
+				ToStatus:   status,
+				Reason:     reason,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// A stopped worker applies no more commands
+		if status == WorkerStatusStopped {
+			if err := failOpenCommands(tx, []string{workerID}, "Worker stopped"); err != nil {
+				return err
+			}
+		}
+
+		worker.Status = status
+		worker.CurrentBatchID = currentBatchID
+		return tx.Model(&worker).Updates(map[string]interface{}{
+			"current_batch_id": currentBatchID,
 			"status":           status,
 		}).Error
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	// Fetch the updated worker
-	var worker Worker
-	err = r.db.Where("worker_id = ?", workerID).First(&worker).Error
-	if err != nil {
-		return nil, err
-	}
-
 	return &worker, nil
 }
 
+// UpdateWorkerConcurrency updates the number of batches a worker processes at once
+func (r *WorkerRepository) UpdateWorkerConcurrency(workerID string, concurrency int) error {
+	return r.db.Model(&Worker{}).
+		Where("worker_id = ?", workerID).
+		Update("concurrency", concurrency).Error
+}
+
 // UpdateCurrentBatch updates the current batch being processed by a worker
 func (r *WorkerRepository) UpdateCurrentBatch(workerID string, batchID *int64) error {
 	return r.db.Model(&Worker{}).
@@ -100,19 +240,69 @@ func (r *WorkerRepository) UpdateCurrentBatch(workerID string, batchID *int64) e
 		Update("current_batch_id", batchID).Error
 }
 
-// SetStaleWorkers marks workers as stale if they have not sent a heartbeat recently
-func (r *WorkerRepository) SetStaleWorkers(timeout time.Duration) error {
+// SetStaleWorkers marks running workers as stale if they have not sent a heartbeat recently,
+// records the transitions and returns the workers it marked
+func (r *WorkerRepository) SetStaleWorkers(timeout time.Duration) ([]Worker, error) {
 	staleThreshold := time.Now().Add(-timeout)
 
-	err := r.db.Model(&Worker{}).
-		Where("last_heartbeat < ?", staleThreshold).
-		Update("status", "stale").Error
+	var workers []Worker
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Skip workers that are changing their own status right now, they are alive
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ?", ActiveWorkerStatuses).
+			Where("last_heartbeat < ?", staleThreshold).
+			Find(&workers).Error
+		if err != nil || len(workers) == 0 {
+			return err
+		}
+
+		ids := make([]int64, len(workers))
+		workerIDs := make([]string, len(workers))
+		events := make([]WorkerEvent, len(workers))
+		for i, worker := range workers {
+			ids[i] = worker.ID
+			workerIDs[i] = worker.WorkerID
+			events[i] = WorkerEvent{
+				WorkerID:   worker.WorkerID,
+				FromStatus: worker.Status,
+				ToStatus:   WorkerStatusStale,
+				Reason:     fmt.Sprintf("No heartbeat since %s", worker.LastHeartbeat.Format(time.RFC3339)),
+			}
+			workers[i].Status = WorkerStatusStale
+			workers[i].CurrentBatchID = nil
+		}
 
-	if err == nil {
-		log.Printf("Marked workers as stale if heartbeat was older than: %s", staleThreshold)
+		if err := tx.Create(&events).Error; err != nil {
+			return err
+		}
+		// Commands are not waited for while the worker is unreachable, it looks for new ones when it reports again
+		if err := failOpenCommands(tx, workerIDs, "Worker went stale"); err != nil {
+			return err
+		}
+		return tx.Model(&Worker{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":           WorkerStatusStale,
+				"current_batch_id": nil,
+			}).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return err
+	if len(workers) > 0 {
+		log.Printf("Marked %d workers as stale, their heartbeat was older than: %s", len(workers), staleThreshold)
+	}
+	return workers, nil
+}
+
+// PruneWorkers deletes stopped and stale workers whose last heartbeat is older than olderThan,
+// together with their commands, and returns how many were deleted. Their events are kept.
+func (r *WorkerRepository) PruneWorkers(olderThan time.Time) (int64, error) {
+	result := r.db.Where("status IN ?", deadWorkerStatuses).
+		Where("last_heartbeat < ?", olderThan).
+		Delete(&Worker{})
+	return result.RowsAffected, result.Error
 }
 
 // GetAvailableWorker retrieves an available worker
@@ -120,7 +310,7 @@ func (r *WorkerRepository) GetAvailableWorker() (*Worker, error) {
 	var worker Worker
 
 	err := r.db.Model(&Worker{}).
-		Where("status = ?", "idle").
+		Where("status = ?", WorkerStatusIdle).
 		Order("last_heartbeat ASC"). // Get the oldest idle worker
 		First(&worker).Error
 
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Commands a worker understands
+const (
+	WorkerCommandDrain          = "drain"           // Finish the batches in progress, then take no more
+	WorkerCommandPause          = "pause"           // Take no more batches until resumed
+	WorkerCommandResume         = "resume"          // Take batches again after a pause or drain
+	WorkerCommandShutdown       = "shutdown"        // Drain, then exit the process
+	WorkerCommandSetConcurrency = "set_concurrency" // Change the number of batches processed at once
+)
+
+// MaxWorkerConcurrency is the highest number of batches a worker may process at once
+const MaxWorkerConcurrency = 32
+
+// WorkerCommand is an instruction from the manager to a single worker.
+// It moves from "pending" to "acknowledged" when the worker picks it up, and ends as "completed" or "failed".
+type WorkerCommand struct {
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	WorkerID       string     `gorm:"type:uuid;not null" json:"worker_id"`         // Worker instance the command is for
+	Command        string     `gorm:"size:20;not null" json:"command"`             // One of the WorkerCommand constants
+	Concurrency    *int       `gorm:"default:null" json:"concurrency,omitempty"`   // New concurrency for set_concurrency
+	Status         string     `gorm:"size:20;default:pending" json:"status"`       // Command status
+	Result         string     `gorm:"type:text;not null;default:''" json:"result"` // Outcome reported by the worker
+	IssuedBy       string     `gorm:"size:255;not null" json:"issued_by"`          // Who sent the command
+	AcknowledgedAt *time.Time `gorm:"default:null" json:"acknowledged_at"`         // When the worker picked the command up
+	FinishedAt     *time.Time `gorm:"default:null" json:"finished_at"`             // When the command completed or failed
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`            // Creation timestamp
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime" json:"updated_at"`            // Update timestamp
+}
+
+// WorkerCommandRepository manages worker command database operations
+type WorkerCommandRepository struct {
+	db *gorm.DB
+}
+
+// NewWorkerCommandRepository creates a new instance of WorkerCommandRepository
+func NewWorkerCommandRepository(db *gorm.DB) *WorkerCommandRepository {
+	return &WorkerCommandRepository{db: db}
+}
+
+// CreateWorkerCommand stores a new pending command
+func (r *WorkerCommandRepository) CreateWorkerCommand(command *WorkerCommand) error {
+	return r.db.Create(command).Error
+}
+
+// GetPendingCommands fetches the commands a worker has not picked up yet, oldest first
+func (r *WorkerCommandRepository) GetPendingCommands(workerID string) ([]WorkerCommand, error) {
+	var commands []WorkerCommand
+	err := r.db.Where("worker_id = ? AND status = ?", workerID, "pending").
+		Order("id ASC").
+		Find(&commands).Error
+	return commands, err
+}
+
+// AcknowledgeCommand marks a pending command as picked up by the worker
+func (r *WorkerCommandRepository) AcknowledgeCommand(commandID int64) error {
+	return r.db.Model(&WorkerCommand{}).
+		Where("id = ? AND status = ?", commandID, "pending").
+		Updates(map[string]interface{}{
+			"status":          "acknowledged",
+			"acknowledged_at": time.Now(),
+		}).Error
+}
+
+// FinishCommand stores the outcome of a command, status is "completed" or "failed"
+func (r *WorkerCommandRepository) FinishCommand(commandID int64, status string, result string) error {
+	return r.db.Model(&WorkerCommand{}).
+		Where("id = ?", commandID).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"result":      result,
+			"finished_at": time.Now(),
+		}).Error
+}
+
+// failOpenCommands fails the pending and acknowledged commands of the workers with tx
+func failOpenCommands(tx *gorm.DB, workerIDs []string, result string) error {
+	return tx.Model(&WorkerCommand{}).
+		Where("worker_id IN ? AND status IN ?", workerIDs, []string{"pending", "acknowledged"}).
+		Updates(map[string]interface{}{
+			"status":      "failed",
+			"result":      result,
+			"finished_at": time.Now(),
+		}).Error
+}
+
+// GetCommandsByIDs fetches the commands with the given primary keys
+func (r *WorkerCommandRepository) GetCommandsByIDs(ids []int64) ([]WorkerCommand, error) {
+	var commands []WorkerCommand
+	if len(ids) == 0 {
+		return commands, nil
+	}
+	err := r.db.Where("id IN ?", ids).Order("id ASC").Find(&commands).Error
+	return commands, err
+}
+
+// GetRecentCommands fetches the latest commands of the given workers, at most limit per worker, newest first
+func (r *WorkerCommandRepository) GetRecentCommands(workerIDs []string, limit int) ([]WorkerCommand, error) {
+	var commands []WorkerCommand
+	if len(workerIDs) == 0 {
+		return commands, nil
+	}
+
+	ranked := r.db.Model(&WorkerCommand{}).
+		Select("*, ROW_NUMBER() OVER (PARTITION BY worker_id ORDER BY id DESC) AS position").
+		Where("worker_id IN ?", workerIDs)
+	err := r.db.Table("(?) AS ranked", ranked).
+		Where("position <= ?", limit).
+		Order("id DESC").
+		Find(&commands).Error
+	return commands, err
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WorkerEvent records a status transition of a worker. Events are kept after the worker row
+// is pruned, so the history of a worker outlives it until the events are pruned themselves.
+type WorkerEvent struct {
+	ID         int64     `gorm:"primaryKey" json:"id"`
+	WorkerID   string    `gorm:"type:uuid;not null" json:"worker_id"`         // Worker instance the event belongs to
+	FromStatus string    `gorm:"size:20;not null" json:"from_status"`         // Status before the transition, empty on registration
+	ToStatus   string    `gorm:"size:20;not null" json:"to_status"`           // Status after the transition
+	Reason     string    `gorm:"type:text;not null;default:''" json:"reason"` // Why the transition happened
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`            // Creation timestamp
+}
+
+// WorkerEventRepository manages worker event database operations
+type WorkerEventRepository struct {
+	db *gorm.DB
+}
+
+// NewWorkerEventRepository creates a new instance of WorkerEventRepository
+func NewWorkerEventRepository(db *gorm.DB) *WorkerEventRepository {
+	return &WorkerEventRepository{db: db}
+}
+
+// GetEventsByWorkerID fetches the latest status transitions of a worker, newest first
+func (r *WorkerEventRepository) GetEventsByWorkerID(workerID string, limit int) ([]WorkerEvent, error) {
+	var events []WorkerEvent
+	err := r.db.Where("worker_id = ?", workerID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+// PruneEvents deletes the events created before olderThan and returns how many were deleted
+func (r *WorkerEventRepository) PruneEvents(olderThan time.Time) (int64, error) {
+	result := r.db.Where("created_at < ?", olderThan).Delete(&WorkerEvent{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,105 @@
+package pgnotify
+
+import (
+	"context"
+	"event-processor/internal/config"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Listener shares one Postgres connection for LISTEN across all handlers of a process
+type Listener struct {
+	dsn        string
+	mu         sync.Mutex
+	handlers   map[string][]func(payload string)
+	onConnect  []func()
+	retryDelay time.Duration
+}
+
+// NewListener creates a new Listener for the configured database
+func NewListener(config *config.Config) *Listener {
+	return &Listener{
+		dsn:        config.DatabaseDSN,
+		handlers:   map[string][]func(payload string){},
+		retryDelay: 2 * time.Second,
+	}
+}
+
+// Handle registers a handler for the notifications of a channel. Handlers run on the
+// listener goroutine and should hand the payload off instead of blocking.
+func (l *Listener) Handle(channel string, handler func(payload string)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handlers[channel] = append(l.handlers[channel], handler)
+}
+
+// OnConnect registers a callback that runs after every (re)connect. Notifications sent
+// while disconnected are lost, so callbacks should resync whatever depends on them.
+func (l *Listener) OnConnect(callback func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onConnect = append(l.onConnect, callback)
+}
+
+// Run listens on every channel with a handler until ctx is done, reconnecting on errors
+func (l *Listener) Run(ctx context.Context) {
+	for {
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			log.Println("Notification listener stopped.")
+			return
+		}
+
+		log.Printf("Notification listener disconnected: %v, reconnecting in %s", err, l.retryDelay)
+		select {
+		case <-time.After(l.retryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *Listener) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, l.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	l.mu.Lock()
+	channels := make([]string, 0, len(l.handlers))
+	for channel := range l.handlers {
+		channels = append(channels, channel)
+	}
+	onConnect := append([]func(){}, l.onConnect...)
+	l.mu.Unlock()
+
+	for _, channel := range channels {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return err
+		}
+	}
+	log.Printf("Listening for notifications on %v", channels)
+
+	for _, callback := range onConnect {
+		callback()
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		l.mu.Lock()
+		handlers := l.handlers[notification.Channel]
+		l.mu.Unlock()
+
+		for _, handler := range handlers {
+			handler(notification.Payload)
+		}
+	}
+}
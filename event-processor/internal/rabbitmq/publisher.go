@@ -0,0 +1,124 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"event-processor/internal/config"
+	"fmt"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrNotConfirmed is returned when the broker rejects a message or does not confirm it in time
+var ErrNotConfirmed = errors.New("message not confirmed by the broker")
+
+// Publisher publishes JSON messages to RabbitMQ queues in confirm mode. It connects on the first
+// publish and reconnects on the next one after an error, so processes that never publish never connect.
+type Publisher struct {
+	url      string
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+// NewPublisher creates a new Publisher for the configured broker
+func NewPublisher(config *config.Config) *Publisher {
+	return &Publisher{url: config.RabbitMQURL}
+}
+
+// Publish sends message as a persistent JSON message to the durable queue and waits until the broker
+// confirms it, so a nil error means the message is stored. Publishes are serialized, the confirmation
+// received is always the one of this message.
+func (p *Publisher) Publish(ctx context.Context, queue string, message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ch == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := p.ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		p.reset()
+		return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+	}
+
+	err = p.ch.Publish("", queue, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+	if err != nil {
+		p.reset()
+		return fmt.Errorf("failed to publish to %s: %w", queue, err)
+	}
+
+	select {
+	case confirm, ok := <-p.confirms:
+		if !ok {
+			p.reset()
+			return fmt.Errorf("%w: channel closed", ErrNotConfirmed)
+		}
+		if !confirm.Ack {
+			return fmt.Errorf("%w: rejected by %s", ErrNotConfirmed, queue)
+		}
+		return nil
+	case <-ctx.Done():
+		// A late confirmation would be taken for the next message, start over on a new channel
+		p.reset()
+		return fmt.Errorf("%w: %v", ErrNotConfirmed, ctx.Err())
+	}
+}
+
+// Close closes the connection, the next publish opens a new one
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reset()
+}
+
+func (p *Publisher) connect() error {
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to open a channel: %w", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		conn.Close()
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	p.conn, p.ch = conn, ch
+	p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	return nil
+}
+
+// reset drops a broken connection. Callers hold mu.
+func (p *Publisher) reset() {
+	if p.ch != nil {
+		p.ch.Close()
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn, p.ch, p.confirms = nil, nil, nil
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Roles of the manager HTTP server, each one includes the permissions of the roles before it
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// placeholderSecret is the value of every secret in manager.env.example, refused so an unedited copy cannot be deployed
+const placeholderSecret = "REPLACE_ME"
+
+// ErrInvalidSession is returned for session tokens that are malformed, forged, expired or revoked
+var ErrInvalidSession = errors.New("invalid session")
+
+// Identity is the authenticated caller of a manager endpoint
+type Identity struct {
+	Name       string `json:"name"`
+	Role       string `json:"role"`
+	AuthMethod string `json:"auth_method"` // "api_key" or "session"
+}
+
+// HasRole reports whether the identity has at least the given role
+func (i *Identity) HasRole(role string) bool {
+	return i != nil && roleRanks[i.Role] >= roleRanks[role]
+}
+
+type apiKey struct {
+	name string
+	role string
+	key  []byte
+}
+
+// AuthService authenticates API keys and dashboard sessions and records audited requests
+type AuthService struct {
+	auditLogRepo       *models.AuditLogRepository
+	revokedSessionRepo *models.RevokedSessionRepository
+	keys               []apiKey
+	sessionSecret      []byte
+	sessionTTL         time.Duration
+	allowedOrigins     map[string]bool
+}
+
+// NewAuthService creates a new AuthService from the configured API keys
+func NewAuthService(config *config.Config, auditLogRepo *models.AuditLogRepository, revokedSessionRepo *models.RevokedSessionRepository) (*AuthService, error) {
+	keys, err := parseApiKeys(config.ApiKeys)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		log.Println("No MANAGER_API_KEYS configured, every manager endpoint will reject requests.")
+	}
+
+	if config.SessionSecret == placeholderSecret {
+		return nil, fmt.Errorf("MANAGER_SESSION_SECRET still has the placeholder value")
+	}
+	secret := []byte(config.SessionSecret)
+	if len(secret) == 0 {
+		// Sessions then only survive as long as this replica
+		log.Println("No MANAGER_SESSION_SECRET configured, using a random secret.")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate session secret: %w", err)
+		}
+	}
+
+	allowedOrigins := map[string]bool{}
+	for _, origin := range strings.Split(config.AllowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins[strings.TrimRight(origin, "/")] = true
+		}
+	}
+	if len(allowedOrigins) == 0 {
+		allowedOrigins[fmt.Sprintf("http://%s:%d", config.ManagerHost, config.ManagerPort)] = true
+	}
+
+	sessionTTL := time.Duration(config.SessionTTL) * time.Minute
+	if sessionTTL <= 0 {
+		sessionTTL = 12 * time.Hour
+	}
+
+	return &AuthService{
+		auditLogRepo:       auditLogRepo,
+		revokedSessionRepo: revokedSessionRepo,
+		keys:               keys,
+		sessionSecret:      secret,
+		sessionTTL:         sessionTTL,
+		allowedOrigins:     allowedOrigins,
+	}, nil
+}
+
+// parseApiKeys reads name:role:key entries separated by commas
+func parseApiKeys(value string) ([]apiKey, error) {
+	var keys []apiKey
+	names := map[string]bool{}
+
+	for i, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// Never echo the entry itself, it may contain the key
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid API key entry #%d, expected name:role:key", i+1)
+		}
+		if _, ok := roleRanks[parts[1]]; !ok {
+			return nil, fmt.Errorf("invalid role %q for API key %s", parts[1], parts[0])
+		}
+		if parts[2] == placeholderSecret {
+			return nil, fmt.Errorf("API key %s still has the placeholder value", parts[0])
+		}
+		if names[parts[0]] {
+			return nil, fmt.Errorf("duplicate API key name %s", parts[0])
+		}
+		names[parts[0]] = true
+
+		keys = append(keys, apiKey{name: parts[0], role: parts[1], key: []byte(parts[2])})
+	}
+
+	return keys, nil
+}
+
+// Authenticate returns the identity of an API key, or nil when the key is unknown
+func (s *AuthService) Authenticate(key string) *Identity {
+	var identity *Identity
+	for _, k := range s.keys {
+		// Compare against every key so the response time does not reveal a match
+		if subtle.ConstantTimeCompare(k.key, []byte(key)) == 1 {
+			identity = &Identity{Name: k.name, Role: k.role, AuthMethod: "api_key"}
+		}
+	}
+	return identity
+}
+
+// IssueSession creates a signed session token for an identity and returns it with its expiry.
+// Every session gets a random ID so a single session can be revoked on logout.
+func (s *AuthService) IssueSession(identity *Identity) (string, time.Time, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	expiresAt := time.Now().Add(s.sessionTTL)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(identity.Name + "|" + strconv.FormatInt(expiresAt.Unix(), 10) + "|" + hex.EncodeToString(id)))
+	return payload + "." + s.sign(payload), expiresAt, nil
+}
+
+// VerifySession returns the identity of a session token. The role is looked up again
+// so revoked or changed API keys take effect before the session expires.
+func (s *AuthService) VerifySession(token string) (*Identity, error) {
+	name, sessionID, _, err := s.parseSession(token)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.revokedSessionRepo.IsSessionRevoked(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check session revocation: %w", err)
+	}
+	if revoked {
+		return nil, ErrInvalidSession
+	}
+
+	for _, k := range s.keys {
+		if k.name == name {
+			return &Identity{Name: k.name, Role: k.role, AuthMethod: "session"}, nil
+		}
+	}
+	return nil, ErrInvalidSession
+}
+
+// RevokeSession rejects a session token from now on, used on logout so a copied cookie stops working
+func (s *AuthService) RevokeSession(token string) error {
+	_, sessionID, expiresAt, err := s.parseSession(token)
+	if err != nil {
+		return err
+	}
+	return s.revokedSessionRepo.RevokeSession(sessionID, expiresAt)
+}
+
+// parseSession checks the signature and expiry of a session token and returns its key name, ID and expiry
+func (s *AuthService) parseSession(token string) (string, string, time.Time, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return "", "", time.Time{}, ErrInvalidSession
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", time.Time{}, ErrInvalidSession
+	}
+
+	parts := strings.Split(string(decoded), "|")
+	if len(parts) != 3 || parts[2] == "" {
+		return "", "", time.Time{}, ErrInvalidSession
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return "", "", time.Time{}, ErrInvalidSession
+	}
+
+	return parts[0], parts[2], time.Unix(expiry, 0), nil
+}
+
+func (s *AuthService) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.sessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// OriginAllowed reports whether a browser origin is in the allowed origins
+func (s *AuthService) OriginAllowed(origin string) bool {
+	return s.allowedOrigins[strings.TrimRight(origin, "/")]
+}
+
+// RecordAudit stores an authenticated mutation, failures are only logged
+func (s *AuthService) RecordAudit(entry *models.AuditLog) {
+	if err := s.auditLogRepo.CreateAuditLog(entry); err != nil {
+		log.Printf("Failed to record audit log for %s %s by %s: %v", entry.Method, entry.Path, entry.Actor, err)
+	}
+}
+
+func (s *AuthService) ListAuditLogs(actor string, limit int, offset int) ([]models.AuditLog, int64, error) {
+	return s.auditLogRepo.ListAuditLogs(actor, limit, offset)
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"event-processor/internal/models"
+	"event-processor/internal/pgnotify"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DashboardChannel is the notification channel the batches, manager_actions, workers and worker_commands triggers publish to
+const DashboardChannel = "manager_changes"
+
+// dashboardBufferSize is the number of messages a subscriber may fall behind before it is dropped
+const dashboardBufferSize = 64
+
+// DashboardMessage is sent to dashboard clients. A snapshot replaces the client state,
+// a delta carries the changed rows to merge into it.
+type DashboardMessage struct {
+	Type     string                 `json:"type"`              // "snapshot" or "delta"
+	ActionID int64                  `json:"action_id"`         // Subscribed action, 0 for all active actions
+	Actions  []models.ManagerAction `json:"actions"`           // Actions with progress, snapshots include their batches
+	Batches  []models.Batch         `json:"batches,omitempty"` // Changed batches, deltas only
+	Workers  []models.Worker        `json:"workers"`
+}
+
+// changeNotification is the payload published by the change triggers
+type changeNotification struct {
+	Table string `json:"table"`
+	ID    int64  `json:"id"`
+}
+
+// DashboardSubscriber receives the dashboard messages of one client
+type DashboardSubscriber struct {
+	actionID int64
+	messages chan DashboardMessage
+}
+
+// Messages returns the message channel, it is closed when the subscriber falls too far behind
+func (s *DashboardSubscriber) Messages() <-chan DashboardMessage {
+	return s.messages
+}
+
+// DashboardHub turns change notifications into deltas for dashboard subscribers.
+// Notifications are collected and loaded together once per flush interval, so a
+// burst of batch updates costs one query per table regardless of the number of clients.
+type DashboardHub struct {
+	service       *WorkerManagerService
+	flushInterval time.Duration
+
+	mu             sync.Mutex
+	subscribers    map[*DashboardSubscriber]struct{}
+	pendingActions map[int64]struct{}
+	pendingBatches map[int64]struct{}
+	pendingWorkers map[int64]struct{}
+	// Commands are sent as part of their worker
+	pendingCommands map[int64]struct{}
+	resync          bool // Set after a reconnect, notifications may have been lost
+}
+
+// NewDashboardHub creates a new DashboardHub fed by the shared notification listener
+func NewDashboardHub(service *WorkerManagerService, listener *pgnotify.Listener) *DashboardHub {
+	hub := &DashboardHub{
+		service:        service,
+		flushInterval:  250 * time.Millisecond,
+		subscribers:    map[*DashboardSubscriber]struct{}{},
+		pendingActions: map[int64]struct{}{},
+		pendingBatches: map[int64]struct{}{},
+		pendingWorkers: map[int64]struct{}{},
+
+		pendingCommands: map[int64]struct{}{},
+	}
+
+	listener.Handle(DashboardChannel, hub.HandleNotification)
+	listener.OnConnect(func() {
+		hub.mu.Lock()
+		hub.resync = true
+		hub.mu.Unlock()
+	})
+
+	return hub
+}
+
+// Subscribe adds a subscriber for one action, or for all active actions when actionID is 0,
+// and queues its initial snapshot. Deltas may arrive before the snapshot and should be ignored.
+func (h *DashboardHub) Subscribe(actionID int64) (*DashboardSubscriber, error) {
+	subscriber := &DashboardSubscriber{
+		actionID: actionID,
+		messages: make(chan DashboardMessage, dashboardBufferSize),
+	}
+
+	h.mu.Lock()
+	h.subscribers[subscriber] = struct{}{}
+	h.mu.Unlock()
+
+	// Load the snapshot after subscribing so no change falls between the two
+	snapshot, err := h.snapshot(actionID)
+	if err != nil {
+		h.Unsubscribe(subscriber)
+		return nil, err
+	}
+
+	h.mu.Lock()
+	h.deliverLocked(subscriber, *snapshot)
+	h.mu.Unlock()
+
+	return subscriber, nil
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (h *DashboardHub) Unsubscribe(subscriber *DashboardSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(subscriber)
+}
+
+// HandleNotification records a change published by the triggers until the next flush
+func (h *DashboardHub) HandleNotification(payload string) {
+	var change changeNotification
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		log.Printf("Ignoring invalid change notification %q: %v", payload, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch change.Table {
+	case "manager_actions":
+		h.pendingActions[change.ID] = struct{}{}
+	case "batches":
+		h.pendingBatches[change.ID] = struct{}{}
+	case "workers":
+		h.pendingWorkers[change.ID] = struct{}{}
+	case "worker_commands":
+		h.pendingCommands[change.ID] = struct{}{}
+	}
+}
+
+// Run flushes collected changes to the subscribers until ctx is done
+func (h *DashboardHub) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.Flush(); err != nil {
+				log.Printf("Failed to publish dashboard changes: %v", err)
+			}
+		case <-ctx.Done():
+			log.Println("Dashboard hub stopped.")
+			return
+		}
+	}
+}
+
+// Flush loads the changed rows and sends each subscriber the ones it is interested in
+func (h *DashboardHub) Flush() error {
+	h.mu.Lock()
+	resync := h.resync
+	actionIDs, batchIDs, workerIDs := setIDs(h.pendingActions), setIDs(h.pendingBatches), setIDs(h.pendingWorkers)
+	commandIDs := setIDs(h.pendingCommands)
+	h.resync = false
+	h.pendingActions, h.pendingBatches, h.pendingWorkers = map[int64]struct{}{}, map[int64]struct{}{}, map[int64]struct{}{}
+	h.pendingCommands = map[int64]struct{}{}
+	h.mu.Unlock()
+
+	if resync {
+		return h.resyncAll()
+	}
+	if len(actionIDs) == 0 && len(batchIDs) == 0 && len(workerIDs) == 0 && len(commandIDs) == 0 {
+		return nil
+	}
+
+	actions, err := h.service.GetActionsByIDs(actionIDs)
+	if err != nil {
+		return err
+	}
+	batches, err := h.service.GetBatchesByIDs(batchIDs)
+	if err != nil {
+		return err
+	}
+	workers, err := h.service.GetWorkersByIDs(workerIDs)
+	if err != nil {
+		return err
+	}
+	commandWorkers, err := h.service.GetWorkersByCommandIDs(commandIDs)
+	if err != nil {
+		return err
+	}
+	for _, worker := range commandWorkers {
+		if !slices.Contains(workerIDs, worker.ID) {
+			workers = append(workers, worker)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for subscriber := range h.subscribers {
+		delta := DashboardMessage{Type: "delta", ActionID: subscriber.actionID, Workers: workers}
+		for _, action := range actions {
+			if subscriber.actionID == 0 || action.ID == subscriber.actionID {
+				delta.Actions = append(delta.Actions, action)
+			}
+		}
+		for _, batch := range batches {
+			if subscriber.actionID == 0 || batch.ActionID == subscriber.actionID {
+				delta.Batches = append(delta.Batches, batch)
+			}
+		}
+
+		if len(delta.Actions) > 0 || len(delta.Batches) > 0 || len(delta.Workers) > 0 {
+			h.deliverLocked(subscriber, delta)
+		}
+	}
+
+	return nil
+}
+
+// resyncAll sends every subscriber a fresh snapshot
+func (h *DashboardHub) resyncAll() error {
+	h.mu.Lock()
+	subscribedActions := map[int64]struct{}{}
+	for subscriber := range h.subscribers {
+		subscribedActions[subscriber.actionID] = struct{}{}
+	}
+	h.mu.Unlock()
+
+	snapshots := map[int64]*DashboardMessage{}
+	for actionID := range subscribedActions {
+		snapshot, err := h.snapshot(actionID)
+		if err != nil {
+			return err
+		}
+		snapshots[actionID] = snapshot
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for subscriber := range h.subscribers {
+		if snapshot, ok := snapshots[subscriber.actionID]; ok {
+			h.deliverLocked(subscriber, *snapshot)
+		}
+	}
+	return nil
+}
+
+func (h *DashboardHub) snapshot(actionID int64) (*DashboardMessage, error) {
+	snapshot := &DashboardMessage{Type: "snapshot", ActionID: actionID}
+
+	if actionID == 0 {
+		actions, err := h.service.GetActiveActions()
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Actions = actions
+	} else {
+		action, err := h.service.GetAction(actionID)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Actions = []models.ManagerAction{*action}
+	}
+
+	workers, err := h.service.GetActiveWorkers()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Workers = workers
+
+	return snapshot, nil
+}
+
+// deliverLocked queues a message without blocking, subscribers that fell behind are dropped
+// and have to subscribe again for a new snapshot
+func (h *DashboardHub) deliverLocked(subscriber *DashboardSubscriber, message DashboardMessage) {
+	if _, ok := h.subscribers[subscriber]; !ok {
+		return
+	}
+
+	select {
+	case subscriber.messages <- message:
+	default:
+		log.Printf("Dropping dashboard subscriber of action %d, it fell behind", subscriber.actionID)
+		h.removeLocked(subscriber)
+	}
+}
+
+func (h *DashboardHub) removeLocked(subscriber *DashboardSubscriber) {
+	if _, ok := h.subscribers[subscriber]; ok {
+		delete(h.subscribers, subscriber)
+		close(subscriber.messages)
+	}
+}
+
+func setIDs(set map[int64]struct{}) []int64 {
+	ids := make([]int64, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"event-processor/internal/models"
+	"fmt"
+	"sort"
+)
+
+// ErrInvalidJobParams is returned when the params of a trigger are rejected by the job type
+var ErrInvalidJobParams = errors.New("invalid job params")
+
+// Job is a bulk job type run through manager actions, batches and workers.
+// The manager counts the items and splits them into batches, workers process one batch at a time.
+type Job interface {
+	// Type returns the unique name stored on manager actions
+	Type() string
+
+	// NormalizeParams validates params and returns them in the form stored on the action
+	NormalizeParams(params models.JSON) (models.JSON, error)
+
+	// CountItems returns the number of items the job would process for params
+	CountItems(ctx context.Context, params models.JSON) (int64, error)
+
+	// SplitBatches divides total items into batch ranges of at most batchSize items
+	SplitBatches(ctx context.Context, params models.JSON, total int64, batchSize int64) ([]models.BatchRange, error)
+
+	// ProcessBatch processes a locked batch and reports its outcome counters.
+	// Returning false puts the batch back for a retry.
+	ProcessBatch(ctx context.Context, action *models.ManagerAction, batch *models.Batch) (models.BatchResult, bool, error)
+}
+
+// LabeledJob is implemented by job types whose batches can only be processed by workers with certain labels
+type LabeledJob interface {
+	// RequiredLabels returns the labels a worker needs to process the batches of an action with params
+	RequiredLabels(params models.JSON) (models.Labels, error)
+}
+
+// JobRegistry holds the job types known to the manager and the workers
+type JobRegistry struct {
+	jobs map[string]Job
+}
+
+// NewJobRegistry creates a registry with the built-in job types
+func NewJobRegistry(renewalJob *RenewalJob, reconciliationJob *ReconciliationJob) *JobRegistry {
+	registry := &JobRegistry{jobs: map[string]Job{}}
+	registry.Register(renewalJob)
+	registry.Register(reconciliationJob)
+	return registry
+}
+
+// Register adds a job type, replacing any job registered under the same name
+func (r *JobRegistry) Register(job Job) {
+	r.jobs[job.Type()] = job
+}
+
+// Get returns the job registered for jobType
+func (r *JobRegistry) Get(jobType string) (Job, error) {
+	job, ok := r.jobs[jobType]
+	if !ok {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+	return job, nil
+}
+
+// Types returns the registered job type names in order
+func (r *JobRegistry) Types() []string {
+	types := make([]string, 0, len(r.jobs))
+	for jobType := range r.jobs {
+		types = append(types, jobType)
+	}
+	sort.Strings(types)
+	return types
+}
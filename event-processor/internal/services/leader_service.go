@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const workerManagerLease = "worker_manager"
+
+// LeaderService elects a single worker manager replica through a lease in Postgres
+type LeaderService struct {
+	leaseRepo     *models.LeaderLeaseRepository
+	instanceID    string
+	ttl           time.Duration
+	renewInterval time.Duration
+
+	mu          sync.RWMutex
+	leaderUntil time.Time // Local deadline of the current lease, zero when not leader
+}
+
+// NewLeaderService creates a new LeaderService instance
+func NewLeaderService(config *config.Config, leaseRepo *models.LeaderLeaseRepository) *LeaderService {
+	ttl := time.Duration(config.LeaderLeaseTTL) * time.Second
+	if ttl <= 0 {
+		ttl = 10 * time.Second
+	}
+
+	return &LeaderService{
+		leaseRepo:     leaseRepo,
+		instanceID:    uuid.New().String(), // Generate a unique instance ID
+		ttl:           ttl,
+		renewInterval: ttl / 4,
+	}
+}
+
+func (s *LeaderService) InstanceID() string {
+	return s.instanceID
+}
+
+// IsLeader reports whether this instance holds a lease that has not run out locally
+func (s *LeaderService) IsLeader() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Now().Before(s.leaderUntil)
+}
+
+func (s *LeaderService) GetLeader() (*models.LeaderLease, error) {
+	return s.leaseRepo.GetLease(workerManagerLease)
+}
+
+// Run keeps trying to acquire the lease and renews it while held. onElected is started
+// with a context that is canceled as soon as leadership is lost or ctx is done.
+func (s *LeaderService) Run(ctx context.Context, onElected func(ctx context.Context)) {
+	ticker := time.NewTicker(s.renewInterval)
+	defer ticker.Stop()
+
+	var cancelLeader context.CancelFunc
+	stepDown := func() {
+		if cancelLeader != nil {
+			log.Printf("Instance %s lost leadership", s.instanceID)
+			cancelLeader()
+			cancelLeader = nil
+		}
+		s.setLeaderUntil(time.Time{})
+	}
+
+	for {
+		// Deadline is taken before the round trip so the local view never outlives the lease
+		deadline := time.Now().Add(s.ttl)
+		acquired, err := s.leaseRepo.TryAcquire(workerManagerLease, s.instanceID, s.ttl)
+		if err != nil {
+			log.Printf("Failed to acquire leader lease: %v\n", err)
+		}
+
+		switch {
+		case err == nil && acquired:
+			s.setLeaderUntil(deadline)
+			if cancelLeader == nil {
+				log.Printf("Instance %s elected as leader", s.instanceID)
+				leaderCtx, cancel := context.WithCancel(ctx)
+				cancelLeader = cancel
+				go onElected(leaderCtx)
+			}
+		case err != nil && s.IsLeader():
+			// Nobody can take over before the lease runs out, so a failed renewal only steps down then
+			log.Printf("Instance %s keeps leadership until its lease runs out", s.instanceID)
+		default:
+			stepDown()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			stepDown()
+			if err := s.leaseRepo.Release(workerManagerLease, s.instanceID); err != nil {
+				log.Printf("Failed to release leader lease: %v\n", err)
+			}
+			log.Println("Leader election stopped.")
+			return
+		}
+	}
+}
+
+func (s *LeaderService) setLeaderUntil(until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leaderUntil = until
+}
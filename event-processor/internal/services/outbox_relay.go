@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"event-processor/internal/pgnotify"
+	"event-processor/internal/rabbitmq"
+	"log"
+	"time"
+)
+
+// OutboxChannel is notified when messages are written to the outbox
+const OutboxChannel = "outbox_pending"
+
+// Bounds of the outbox relay
+const (
+	outboxLease         = 30 * time.Second // Claimed messages of a relay that died are due again after this
+	outboxPublishWait   = 10 * time.Second // Time to wait for the broker to confirm a message
+	outboxMaxBackoff    = 10 * time.Minute // Upper bound of the wait before a failed message is retried
+	outboxPruneInterval = time.Hour
+)
+
+// OutboxRelay publishes outbox messages to RabbitMQ and marks them sent once the broker confirms them.
+// Messages are claimed with a lease, so any number of relays can run side by side. Delivery is at least
+// once: a relay that dies between the confirmation and marking the message sent publishes it again.
+type OutboxRelay struct {
+	outboxRepo   *models.OutboxRepository
+	publisher    *rabbitmq.Publisher
+	batchSize    int
+	pollInterval time.Duration // Fallback when notifications are missed
+	backoff      time.Duration // Wait after the first failed publish, doubled for every further one
+	retention    time.Duration // How long sent messages are kept
+	wakeup       chan struct{}
+}
+
+// NewOutboxRelay creates a new OutboxRelay instance
+func NewOutboxRelay(config *config.Config, outboxRepo *models.OutboxRepository, publisher *rabbitmq.Publisher, listener *pgnotify.Listener) *OutboxRelay {
+	batchSize := config.OutboxBatchSize
+	if batchSize < 1 {
+		batchSize = 100
+	}
+	pollInterval := time.Duration(config.OutboxPollInterval) * time.Second
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+
+	r := &OutboxRelay{
+		outboxRepo:   outboxRepo,
+		publisher:    publisher,
+		batchSize:    batchSize,
+		pollInterval: pollInterval,
+		backoff:      time.Duration(config.OutboxRetryBackoff) * time.Second,
+		retention:    time.Duration(config.OutboxRetentionHours) * time.Hour,
+		wakeup:       make(chan struct{}, 1),
+	}
+
+	listener.Handle(OutboxChannel, func(string) { signal(r.wakeup) })
+	// Notifications sent while disconnected are lost, look for messages after every reconnect
+	listener.OnConnect(func() { signal(r.wakeup) })
+
+	return r
+}
+
+// Run relays messages until ctx is done
+func (r *OutboxRelay) Run(ctx context.Context) {
+	log.Printf("Outbox relay started, batch size %d", r.batchSize)
+	defer r.publisher.Close()
+
+	lastPrune := time.Time{}
+	for ctx.Err() == nil {
+		claimed, err := r.RelayOnce(ctx)
+		if err != nil {
+			log.Printf("Failed to relay outbox messages: %v", err)
+		}
+
+		if time.Since(lastPrune) >= outboxPruneInterval {
+			r.prune()
+			lastPrune = time.Now()
+		}
+
+		// A full batch means more messages are probably due
+		if err == nil && claimed == r.batchSize {
+			continue
+		}
+
+		timer := time.NewTimer(r.pollInterval)
+		select {
+		case <-r.wakeup:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+	}
+	log.Println("Outbox relay stopped.")
+}
+
+// RelayOnce publishes one batch of due messages in order and returns how many were claimed. The batch
+// stops at the first message that could not be published: it is retried after a backoff, and the
+// messages after it are released to wait for it, so consumers never see them first.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	messages, err := r.outboxRepo.ClaimMessages(r.batchSize, outboxLease)
+	if err != nil {
+		return 0, err
+	}
+
+	var sent []int64
+	for i, message := range messages {
+		publishCtx, cancel := context.WithTimeout(ctx, outboxPublishWait)
+		err := r.publisher.Publish(publishCtx, message.Queue, json.RawMessage(message.Payload))
+		cancel()
+		if err != nil {
+			log.Printf("Failed to publish outbox message %d to %s: %v", message.ID, message.Queue, err)
+			if markErr := r.outboxRepo.MarkFailed(message.ID, err, r.backoff, outboxMaxBackoff); markErr != nil {
+				log.Printf("Failed to schedule retry of outbox message %d: %v", message.ID, markErr)
+			}
+
+			var unpublished []int64
+			for _, later := range messages[i+1:] {
+				unpublished = append(unpublished, later.ID)
+			}
+			if releaseErr := r.outboxRepo.ReleaseMessages(unpublished); releaseErr != nil {
+				// They are due again once the lease ends
+				log.Printf("Failed to release %d outbox messages: %v", len(unpublished), releaseErr)
+			}
+			break
+		}
+		sent = append(sent, message.ID)
+	}
+
+	if err := r.outboxRepo.MarkSent(sent); err != nil {
+		return len(messages), err
+	}
+	return len(messages), nil
+}
+
+// prune deletes sent messages older than the retention, failures are only logged
+func (r *OutboxRelay) prune() {
+	if r.retention <= 0 {
+		return
+	}
+
+	pruned, err := r.outboxRepo.PruneSent(time.Now().Add(-r.retention))
+	if err != nil {
+		log.Printf("Failed to prune outbox: %v", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d sent outbox messages", pruned)
+	}
+}
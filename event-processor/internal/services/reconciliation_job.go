@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// JobTypeReconciliation re-validates subscriptions with access and reports where they differ from the store
+const JobTypeReconciliation = "reconciliation"
+
+// defaultExpiryTolerance is the difference between a stored expiry and the store's, in seconds, that is not reported
+const defaultExpiryTolerance = 60
+
+// ReconciliationParams are the params of a reconciliation, e.g.
+// {"app_ids": [1], "sample_every": 10, "sample_offset": 3, "auto_fix": ["status_mismatch"]}
+type ReconciliationParams struct {
+	models.ReconciliationFilter
+	AutoFix         []string `json:"auto_fix,omitempty"`         // Discrepancy kinds corrected right away, the others are only reported
+	ExpiryTolerance int64    `json:"expiry_tolerance,omitempty"` // Seconds an expiry may differ from the store's, defaults to 60
+}
+
+// Fixes reports whether discrepancies of the kind are corrected
+func (p ReconciliationParams) Fixes(kind string) bool {
+	for _, fix := range p.AutoFix {
+		if fix == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ReconciliationJob re-validates subscriptions that still grant access, which renewal sweeps only look at
+// once they expired, so refunds, revocations and expiry changes are noticed early. Every difference is
+// written to the discrepancy report and corrected when the auto-fix policy of the action says so.
+type ReconciliationJob struct {
+	subscriptionRepo   *models.SubscriptionRepository
+	batchRepo          *models.BatchRepository
+	storeApiService    *StoreApiService
+	checkpointInterval int // Items processed between two checkpoints
+}
+
+// NewReconciliationJob creates a new ReconciliationJob instance
+func NewReconciliationJob(config *config.Config, subscriptionRepo *models.SubscriptionRepository, batchRepo *models.BatchRepository, storeApiService *StoreApiService) *ReconciliationJob {
+	checkpointInterval := config.WorkerCheckpointInterval
+	if checkpointInterval < 1 {
+		checkpointInterval = 100
+	}
+
+	return &ReconciliationJob{
+		subscriptionRepo:   subscriptionRepo,
+		batchRepo:          batchRepo,
+		storeApiService:    storeApiService,
+		checkpointInterval: checkpointInterval,
+	}
+}
+
+func (j *ReconciliationJob) Type() string {
+	return JobTypeReconciliation
+}
+
+// NormalizeParams decodes params as reconciliation params and re-encodes them with the default tolerance
+func (j *ReconciliationJob) NormalizeParams(params models.JSON) (models.JSON, error) {
+	reconciliation, err := decodeReconciliationParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := json.Marshal(reconciliation)
+	if err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+func (j *ReconciliationJob) CountItems(ctx context.Context, params models.JSON) (int64, error) {
+	reconciliation, err := decodeReconciliationParams(params)
+	if err != nil {
+		return 0, err
+	}
+	return j.subscriptionRepo.GetCountForReconciliation(reconciliation.ReconciliationFilter)
+}
+
+// SplitBatches splits the subscriptions into batches that start after the ID of the previous batch's last subscription
+func (j *ReconciliationJob) SplitBatches(ctx context.Context, params models.JSON, total int64, batchSize int64) ([]models.BatchRange, error) {
+	reconciliation, err := decodeReconciliationParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := j.subscriptionRepo.FetchReconciliationKeys(reconciliation.ReconciliationFilter, total, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return models.SplitKeyedRange(total, batchSize, keys), nil
+}
+
+// RequiredLabels routes reconciliations of some apps to workers serving those apps
+func (j *ReconciliationJob) RequiredLabels(params models.JSON) (models.Labels, error) {
+	reconciliation, err := decodeReconciliationParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := models.Labels{}
+	for _, appID := range reconciliation.AppIDs {
+		labels.Add("apps", strconv.Itoa(appID))
+	}
+	return labels, nil
+}
+
+// decodeReconciliationParams reads the params of a reconciliation
+func decodeReconciliationParams(params models.JSON) (ReconciliationParams, error) {
+	var reconciliation ReconciliationParams
+	if err := params.Decode(&reconciliation); err != nil {
+		return reconciliation, fmt.Errorf("%w: %v", ErrInvalidJobParams, err)
+	}
+	if err := reconciliation.Validate(); err != nil {
+		return reconciliation, fmt.Errorf("%w: %v", ErrInvalidJobParams, err)
+	}
+	for _, kind := range reconciliation.AutoFix {
+		if err := models.ValidateDiscrepancyKind(kind); err != nil {
+			return reconciliation, fmt.Errorf("%w: %v", ErrInvalidJobParams, err)
+		}
+	}
+	if reconciliation.ExpiryTolerance < 0 {
+		return reconciliation, fmt.Errorf("%w: expiry_tolerance must not be negative", ErrInvalidJobParams)
+	}
+	if reconciliation.ExpiryTolerance == 0 {
+		reconciliation.ExpiryTolerance = defaultExpiryTolerance
+	}
+	return reconciliation, nil
+}
+
+// ProcessBatch re-validates the subscriptions in the batch. Discrepancies are written with the checkpoint
+// together with the corrections and their events, a retried or reclaimed batch continues after it. Fixed
+// subscriptions count as renewed or expired, reported ones as skipped. Only an unavailable store API stops
+// the batch for a retry.
+func (j *ReconciliationJob) ProcessBatch(ctx context.Context, action *models.ManagerAction, batch *models.Batch) (models.BatchResult, bool, error) {
+	log.Printf("Reconciling batch: ID %d, ActionID %d\n", batch.ID, batch.ActionID)
+
+	params, err := decodeReconciliationParams(action.Params)
+	if err != nil {
+		return models.BatchResult{}, false, err
+	}
+	if batch.LockedBy == nil {
+		return models.BatchResult{}, false, fmt.Errorf("batch %d is not locked", batch.ID)
+	}
+	workerID := *batch.LockedBy
+
+	var checkpoint models.BatchCheckpoint
+	lastKey := batch.AfterKey
+	if batch.Checkpoint != nil {
+		checkpoint = *batch.Checkpoint
+		lastKey = checkpoint.LastKey
+		log.Printf("Resuming batch %d after subscription ID %d (%d of %d items)\n", batch.ID, checkpoint.LastKey, checkpoint.Position, batch.Size())
+	}
+
+	subscriptions, err := j.subscriptionRepo.FetchReconciliationAfterKey(params.ReconciliationFilter, lastKey, batch.EndKey, batch.Size()-checkpoint.Position)
+	if err != nil {
+		return checkpoint.Result, false, fmt.Errorf("failed to fetch subscriptions for batch %d: %w", batch.ID, err)
+	}
+
+	next := checkpoint
+	writes := models.CheckpointWrites{ActionID: batch.ActionID}
+
+	// save writes the queued discrepancies, corrections and events with the checkpoint. Corrections that lost
+	// against a newer change are skipped, counted as conflicts and reported as not fixed.
+	save := func() error {
+		saved, err := j.batchRepo.SaveCheckpoint(batch.ID, workerID, next, writes)
+		if err != nil {
+			return fmt.Errorf("failed to save checkpoint of batch %d: %w", batch.ID, err)
+		}
+		if conflicts := saved.Result.ConflictCount - next.Result.ConflictCount; conflicts > 0 {
+			log.Printf("Skipped %d corrections in batch %d, the subscriptions changed while they were validated", conflicts, batch.ID)
+		}
+		next = saved
+		writes.Updates, writes.Events, writes.Discrepancies = nil, nil, nil
+		return nil
+	}
+
+	var storeErr error
+	sinceCheckpoint := 0
+	for _, sub := range subscriptions {
+		// Stop before the next store call once the action is canceled, results so far are still saved
+		if ctx.Err() != nil {
+			log.Printf("Stopping batch %d early: %v", batch.ID, ctx.Err())
+			break
+		}
+
+		old := sub
+		discrepancy, err := j.reconcileSubscription(ctx, params, &sub)
+		if ctx.Err() != nil || errors.Is(err, ErrStoreUnavailable) {
+			// Not the receipt's fault, the next attempt starts again at this subscription
+			storeErr = err
+			break
+		}
+
+		switch {
+		case err != nil:
+			log.Printf("Failed to reconcile subscription ID %d: %v", sub.ID, err)
+			next.Result.FailedCount++
+		case discrepancy == nil:
+			// Matches the store
+		case discrepancy.Fixed:
+			next.Result.CountUpdate(sub.Status)
+			writes.Updates = append(writes.Updates, sub)
+			writes.Events = appendTransition(writes.Events, models.SubscriptionEventSourceReconciliation, statusOutcome(sub.Status), old, sub, batch, discrepancy.ResponseHash)
+		default:
+			next.Result.SkippedCount++
+		}
+		if discrepancy != nil {
+			discrepancy.ActionID, discrepancy.BatchID = batch.ActionID, batch.ID
+			writes.Discrepancies = append(writes.Discrepancies, *discrepancy)
+		}
+		next.Result.ProcessedCount++
+		next.LastKey = sub.ID
+		next.Position++
+
+		if sinceCheckpoint++; sinceCheckpoint >= j.checkpointInterval {
+			if err := save(); err != nil {
+				return next.Result, false, err
+			}
+			sinceCheckpoint = 0
+		}
+	}
+
+	// Write the remaining discrepancies and corrections
+	if err := save(); err != nil {
+		return next.Result, false, err
+	}
+
+	result := next.Result
+	log.Printf("Completed reconciling batch: ID %d, Fixed: %d, Reported: %d, Failures: %d, Conflicts: %d\n",
+		batch.ID, result.RenewedCount+result.ExpiredCount, result.SkippedCount, result.FailedCount, result.ConflictCount)
+
+	if storeErr != nil && ctx.Err() == nil {
+		return result, false, fmt.Errorf("stopped batch %d after subscription ID %d: %w", batch.ID, next.LastKey, storeErr)
+	}
+	return result, true, nil
+}
+
+// reconcileSubscription re-validates one subscription and returns its discrepancy, nil when it matches the
+// store. When the policy fixes the discrepancy, sub is corrected to the store's state.
+func (j *ReconciliationJob) reconcileSubscription(ctx context.Context, params ReconciliationParams, sub *models.Subscription) (*models.ReconciliationDiscrepancy, error) {
+	storeResult, responseHash, err := j.storeApiService.ValidateReceipt(ctx, sub.Receipt)
+	discrepancy := &models.ReconciliationDiscrepancy{
+		SubscriptionID: sub.ID,
+		AppID:          sub.AppID,
+		LocalStatus:    sub.Status,
+		LocalExpireAt:  sub.ExpireAt,
+		ResponseHash:   responseHash,
+	}
+
+	// Access ends now, or at the stored expiry when that already passed
+	revokeAt := time.Now()
+	if sub.ExpireAt.Before(revokeAt) {
+		revokeAt = sub.ExpireAt
+	}
+
+	var status models.SubscriptionStatus
+	var expireAt time.Time
+	switch {
+	case errors.Is(err, ErrUnknownReceipt):
+		discrepancy.Kind = models.DiscrepancyUnknownReceipt
+		status, expireAt = models.SubscriptionStatusExpired, revokeAt
+	case err != nil:
+		return nil, err
+	default:
+		storeStatus, storeExpireAt, err := parseStoreResult(storeResult)
+		if err != nil {
+			return nil, err
+		}
+		discrepancy.StoreStatus, discrepancy.StoreExpireAt = storeStatus, storeExpireAt
+
+		tolerance := time.Duration(params.ExpiryTolerance) * time.Second
+		switch {
+		case !storeStatus.Entitled():
+			discrepancy.Kind = models.DiscrepancyStatusMismatch
+			status, expireAt = storeStatus, revokeAt
+			if storeExpireAt != nil && storeExpireAt.Before(revokeAt) {
+				expireAt = *storeExpireAt
+			}
+		case storeExpireAt.Sub(sub.ExpireAt) > tolerance || sub.ExpireAt.Sub(*storeExpireAt) > tolerance:
+			discrepancy.Kind = models.DiscrepancyExpiryMismatch
+			status, expireAt = sub.Status, *storeExpireAt
+		default:
+			return nil, nil
+		}
+	}
+
+	if !params.Fixes(discrepancy.Kind) {
+		return discrepancy, nil
+	}
+	if err := models.ValidateSubscriptionTransition(sub.Status, status); err != nil {
+		log.Printf("Cannot fix %s of subscription ID %d: %v", discrepancy.Kind, sub.ID, err)
+		return discrepancy, nil
+	}
+	sub.Status, sub.ExpireAt = status, expireAt
+	discrepancy.Fixed = true
+	return discrepancy, nil
+}
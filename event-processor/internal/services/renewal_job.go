@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// JobTypeRenewalSweep re-validates expired subscriptions against the store
+const JobTypeRenewalSweep = "renewal_sweep"
+
+// maxRetryBackoff caps the wait before a failed receipt is retried
+const maxRetryBackoff = 24 * time.Hour
+
+// RenewalJob re-validates expired subscriptions and renews or expires them
+type RenewalJob struct {
+	subscriptionRepo   *models.SubscriptionRepository
+	batchRepo          *models.BatchRepository
+	windowRepo         *models.ValidationWindowRepository
+	storeApiService    *StoreApiService
+	checkpointInterval int                // Items processed between two checkpoints
+	retryPolicy        models.RetryPolicy // When failed receipts are retried and quarantined
+}
+
+// NewRenewalJob creates a new RenewalJob instance
+func NewRenewalJob(config *config.Config, subscriptionRepo *models.SubscriptionRepository, batchRepo *models.BatchRepository, windowRepo *models.ValidationWindowRepository, storeApiService *StoreApiService) *RenewalJob {
+	checkpointInterval := config.WorkerCheckpointInterval
+	if checkpointInterval < 1 {
+		checkpointInterval = 100
+	}
+	maxAttempts := config.SubscriptionMaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 5
+	}
+
+	return &RenewalJob{
+		subscriptionRepo:   subscriptionRepo,
+		batchRepo:          batchRepo,
+		windowRepo:         windowRepo,
+		storeApiService:    storeApiService,
+		checkpointInterval: checkpointInterval,
+		retryPolicy: models.RetryPolicy{
+			MaxAttempts: maxAttempts,
+			Backoff:     time.Duration(config.SubscriptionRetryBackoff) * time.Second,
+			MaxBackoff:  maxRetryBackoff,
+		},
+	}
+}
+
+func (j *RenewalJob) Type() string {
+	return JobTypeRenewalSweep
+}
+
+// NormalizeParams decodes params as a subscription filter and re-encodes it
+func (j *RenewalJob) NormalizeParams(params models.JSON) (models.JSON, error) {
+	filter, err := decodeSubscriptionFilter(params)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+func (j *RenewalJob) CountItems(ctx context.Context, params models.JSON) (int64, error) {
+	filter, err := decodeSubscriptionFilter(params)
+	if err != nil {
+		return 0, err
+	}
+
+	// Rows the windows gave up on since they were last written would only be skipped
+	pruned, err := j.subscriptionRepo.PruneDueQueue()
+	if err != nil {
+		return 0, err
+	}
+	if pruned > 0 {
+		log.Printf("Removed %d subscriptions past their validation window from the due queue", pruned)
+	}
+	return j.subscriptionRepo.GetCountForProcessing(filter)
+}
+
+// SplitBatches splits the due subscriptions into batches that start after the ID of the previous batch's
+// last subscription, so workers read them by key instead of skipping the earlier batches with an OFFSET
+func (j *RenewalJob) SplitBatches(ctx context.Context, params models.JSON, total int64, batchSize int64) ([]models.BatchRange, error) {
+	filter, err := decodeSubscriptionFilter(params)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := j.subscriptionRepo.FetchBatchKeys(filter, total, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return models.SplitKeyedRange(total, batchSize, keys), nil
+}
+
+// RequiredLabels routes sweeps filtered by store or apps to workers serving that store and those apps
+func (j *RenewalJob) RequiredLabels(params models.JSON) (models.Labels, error) {
+	filter, err := decodeSubscriptionFilter(params)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := models.Labels{}
+	if filter.Store != "" {
+		labels.Add("store", filter.Store)
+	}
+	for _, appID := range filter.AppIDs {
+		labels.Add("apps", strconv.Itoa(appID))
+	}
+	return labels, nil
+}
+
+// decodeSubscriptionFilter reads the subscription filter stored in the params of a renewal sweep
+func decodeSubscriptionFilter(params models.JSON) (models.SubscriptionFilter, error) {
+	var filter models.SubscriptionFilter
+	if err := params.Decode(&filter); err != nil {
+		return filter, fmt.Errorf("%w: %v", ErrInvalidJobParams, err)
+	}
+	if err := filter.Validate(); err != nil {
+		return filter, fmt.Errorf("%w: %v", ErrInvalidJobParams, err)
+	}
+	return filter, nil
+}
+
+// Outcomes of a single subscription. Moves to other statuses, such as grace_period, use the status as outcome.
+const (
+	outcomeRenewed = "renewed"
+	outcomeExpired = "expired"
+	outcomeSkipped = "skipped"
+	outcomeFailed  = "failed"
+)
+
+// ProcessBatch processes the records in the batch. Every checkpointInterval items the updates and
+// failures are written together with a checkpoint, and a retried or reclaimed batch continues after it.
+// Receipts that fail are tracked per subscription and retried by later sweeps, so they do not fail the
+// batch. Only an unavailable store API stops the batch for a retry. Status transitions are written to
+// the outbox with their checkpoint, and the outbox relay publishes them.
+func (j *RenewalJob) ProcessBatch(ctx context.Context, action *models.ManagerAction, batch *models.Batch) (models.BatchResult, bool, error) {
+	log.Printf("Processing batch: ID %d, ActionID %d\n", batch.ID, batch.ActionID)
+
+	filter, err := decodeSubscriptionFilter(action.Params)
+	if err != nil {
+		return models.BatchResult{}, false, err
+	}
+	if batch.LockedBy == nil {
+		return models.BatchResult{}, false, fmt.Errorf("batch %d is not locked", batch.ID)
+	}
+	workerID := *batch.LockedBy
+
+	var checkpoint models.BatchCheckpoint
+	if batch.Checkpoint != nil {
+		checkpoint = *batch.Checkpoint
+		log.Printf("Resuming batch %d after subscription ID %d (%d of %d items)\n", batch.ID, checkpoint.LastKey, checkpoint.Position, batch.Size())
+	}
+
+	// Fetch records in the batch, or the ones after the checkpoint
+	lastKey := batch.AfterKey
+	if batch.Checkpoint != nil {
+		lastKey = checkpoint.LastKey
+	}
+	subscriptions, err := j.subscriptionRepo.FetchSubscriptionsAfterKey(filter, lastKey, batch.EndKey, batch.Size()-checkpoint.Position)
+	if err != nil {
+		return checkpoint.Result, false, fmt.Errorf("failed to fetch subscriptions for batch %d: %w", batch.ID, err)
+	}
+
+	// Validation windows of the apps in the batch
+	var appIDs []int
+	for _, sub := range subscriptions {
+		appIDs = append(appIDs, sub.AppID)
+	}
+	windows, err := j.windowRepo.GetWindows(appIDs)
+	if err != nil {
+		return checkpoint.Result, false, fmt.Errorf("failed to fetch validation windows for batch %d: %w", batch.ID, err)
+	}
+
+	next := checkpoint
+	writes := models.CheckpointWrites{ActionID: batch.ActionID, Retry: j.retryPolicy}
+
+	// save writes the queued updates, events and failures with the checkpoint. Updates that lost against a
+	// newer change, such as a purchase, are skipped and counted as conflicts by the saved checkpoint.
+	save := func() error {
+		saved, err := j.batchRepo.SaveCheckpoint(batch.ID, workerID, next, writes)
+		if err != nil {
+			return fmt.Errorf("failed to save checkpoint of batch %d: %w", batch.ID, err)
+		}
+		if conflicts := saved.Result.ConflictCount - next.Result.ConflictCount; conflicts > 0 {
+			log.Printf("Skipped %d updates in batch %d, the subscriptions changed while they were validated", conflicts, batch.ID)
+		}
+		next = saved
+		writes.Updates, writes.Events, writes.Failures, writes.Skipped = nil, nil, nil, nil
+		return nil
+	}
+
+	// Process each subscription
+	var storeErr error
+	sinceCheckpoint := 0
+	for _, sub := range subscriptions {
+		// Stop before the next store call once the action is canceled, results so far are still saved
+		if ctx.Err() != nil {
+			log.Printf("Stopping batch %d early: %v", batch.ID, ctx.Err())
+			break
+		}
+
+		old := sub
+		outcome, responseHash, err := j.processSubscription(ctx, filter, windows[sub.AppID], &sub)
+		if ctx.Err() != nil || errors.Is(err, ErrStoreUnavailable) {
+			// Not the receipt's fault, the next attempt starts again at this subscription
+			storeErr = err
+			break
+		}
+
+		switch outcome {
+		case outcomeSkipped:
+			next.Result.SkippedCount++
+			writes.Skipped = append(writes.Skipped, sub.ID)
+		case outcomeFailed:
+			next.Result.FailedCount++
+			writes.Failures = append(writes.Failures, models.ItemFailure{SubscriptionID: sub.ID, Error: err.Error()})
+		default:
+			// Renewals, expiries and other store states count as renewed or expired by whether they grant access
+			next.Result.CountUpdate(sub.Status)
+			writes.Updates = append(writes.Updates, sub)
+			writes.Events = appendTransition(writes.Events, models.SubscriptionEventSourceWorker, outcome, old, sub, batch, responseHash)
+		}
+		next.Result.ProcessedCount++
+		next.LastKey = sub.ID
+		next.Position++
+
+		if sinceCheckpoint++; sinceCheckpoint >= j.checkpointInterval {
+			if err := save(); err != nil {
+				return next.Result, false, err
+			}
+			sinceCheckpoint = 0
+		}
+	}
+
+	// Write the remaining updates and failures
+	if err := save(); err != nil {
+		return next.Result, false, err
+	}
+
+	result := next.Result
+	log.Printf("Completed processing batch: ID %d, Renewed: %d, Expired: %d, Failures: %d, Skipped: %d, Conflicts: %d\n",
+		batch.ID, result.RenewedCount, result.ExpiredCount, result.FailedCount, result.SkippedCount, result.ConflictCount)
+
+	if storeErr != nil && ctx.Err() == nil {
+		return result, false, fmt.Errorf("stopped batch %d after subscription ID %d: %w", batch.ID, next.LastKey, storeErr)
+	}
+	return result, true, nil
+}
+
+// appendTransition adds the event of a subscription updated by a batch if its status or expiry changed
+func appendTransition(events []models.SubscriptionEvent, source string, outcome string, old models.Subscription, sub models.Subscription, batch *models.Batch, responseHash string) []models.SubscriptionEvent {
+	event := models.SubscriptionEvent{
+		Status:         outcome,
+		SubscriptionID: sub.ID,
+		UID:            sub.UID,
+		AppID:          sub.AppID,
+		OldStatus:      old.Status,
+		NewStatus:      sub.Status,
+		OldExpireAt:    old.ExpireAt,
+		NewExpireAt:    sub.ExpireAt,
+		Source:         source,
+		ActionID:       batch.ActionID,
+		BatchID:        batch.ID,
+		ResponseHash:   responseHash,
+		OccurredAt:     time.Now(),
+	}
+	if !event.Changed() {
+		return events
+	}
+	return append(events, event)
+}
+
+// processSubscription re-validates one subscription within the validation window of its app and updates sub
+// with the outcome. It also returns the hash of the store response, and failed subscriptions come with the
+// error that explains why.
+func (j *RenewalJob) processSubscription(ctx context.Context, filter models.SubscriptionFilter, window models.ValidationWindow, sub *models.Subscription) (string, string, error) {
+	// Skip if subscription status is not selected, canceled by default
+	if !filter.MatchesStatus(sub.Status) {
+		log.Printf("Skipping subscription ID %d: status is %s", sub.ID, sub.Status)
+		return outcomeSkipped, "", nil
+	}
+
+	// Skip if subscription was checked within the app's re-check interval, unless the re-check is forced
+	if recheck := window.RecheckAfter(sub.Status); !filter.Force && time.Since(sub.UpdatedAt) < recheck {
+		log.Printf("Skipping subscription ID %d: updated within %s", sub.ID, recheck)
+		return outcomeSkipped, "", nil
+	}
+
+	// Skip if the subscription is no longer within the filter's expiry bounds or the app's validation window
+	if !filter.Due(window, sub.ExpireAt, time.Now()) {
+		return outcomeSkipped, "", nil
+	}
+
+	// Request the Store API to validate the receipt
+	storeResult, responseHash, err := j.storeApiService.ValidateReceipt(ctx, sub.Receipt)
+	if err != nil {
+		log.Printf("Failed to validate receipt for subscription ID %d: %v", sub.ID, err)
+		return outcomeFailed, responseHash, err
+	}
+
+	// Map the Store API result into the subscription state model
+	status, expireAt, err := parseStoreResult(storeResult)
+	if err != nil {
+		log.Printf("Invalid store result for subscription ID %d: %v", sub.ID, err)
+		return outcomeFailed, responseHash, err
+	}
+	if status.Entitled() {
+		sub.ExpireAt = *expireAt
+	}
+
+	if err := models.ValidateSubscriptionTransition(sub.Status, status); err != nil {
+		log.Printf("Rejected store result for subscription ID %d: %v", sub.ID, err)
+		return outcomeFailed, responseHash, err
+	}
+	sub.Status = status
+	return statusOutcome(status), responseHash, nil
+}
+
+// statusOutcome returns the outcome of moving a subscription to the status
+func statusOutcome(status models.SubscriptionStatus) string {
+	switch status {
+	case models.SubscriptionStatusActive:
+		return outcomeRenewed
+	case models.SubscriptionStatusExpired:
+		return outcomeExpired
+	default:
+		return string(status)
+	}
+}
+
+// parseStoreResult maps a store validation result into the subscription state model. The expiry is nil
+// when the store reported none, statuses that grant access always come with one.
+func parseStoreResult(storeResult map[string]interface{}) (models.SubscriptionStatus, *time.Time, error) {
+	valid, ok := storeResult["status"].(bool)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid status %v in store response", storeResult["status"])
+	}
+
+	state, _ := storeResult["state"].(string)
+	status, err := models.StoreStatus(valid, state)
+	if err != nil {
+		return "", nil, err
+	}
+
+	expireDate, ok := storeResult["expire_date"].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid expire_date %v in store response", storeResult["expire_date"])
+	}
+	if expireDate == "" && !status.Entitled() {
+		return status, nil, nil
+	}
+
+	expireAt, err := time.Parse("2006-01-02 15:04:05", expireDate)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse expire_date: %w", err)
+	}
+	return status, &expireAt, nil
+}
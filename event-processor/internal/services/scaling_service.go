@@ -0,0 +1,110 @@
+package services
+
+import (
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"math"
+)
+
+// durationSampleSize is the number of recently completed batches the average batch duration is based on
+const durationSampleSize = 200
+
+// ScalingSignal describes the batch backlog and the number of workers needed to finish it in the target time
+type ScalingSignal struct {
+	PendingBatches      int64   `json:"pending_batches"`       // Claimable pending batches
+	ProcessingBatches   int64   `json:"processing_batches"`    // Batches in progress
+	ActiveWorkers       int     `json:"active_workers"`        // Running workers, including paused and draining ones
+	WorkerSlots         int     `json:"worker_slots"`          // Batches the idle and processing workers can run at once
+	AverageBatchSeconds float64 `json:"average_batch_seconds"` // Average duration of recently completed batches
+	DurationSamples     int64   `json:"duration_samples"`      // Completed batches the average is based on
+	BacklogSeconds      float64 `json:"backlog_seconds"`       // Estimated time to finish the backlog with the current slots, at least one
+	TargetSeconds       int     `json:"target_seconds"`        // Time the backlog should be finished in
+	DesiredWorkers      int     `json:"desired_workers"`       // Workers needed to meet the target, within the bounds
+	MinWorkers          int     `json:"min_workers"`
+	MaxWorkers          int     `json:"max_workers"`
+}
+
+// ScalingService calculates the autoscaling signal for workers
+type ScalingService struct {
+	batchRepo          *models.BatchRepository
+	workerRepo         *models.WorkerRepository
+	minWorkers         int
+	maxWorkers         int
+	targetSeconds      int
+	defaultConcurrency int
+}
+
+// NewScalingService creates a new ScalingService with the configured bounds
+func NewScalingService(config *config.Config, batchRepo *models.BatchRepository, workerRepo *models.WorkerRepository) *ScalingService {
+	minWorkers := max(config.ScalingMinWorkers, 0)
+	targetSeconds := config.ScalingTargetSeconds
+	if targetSeconds <= 0 {
+		targetSeconds = 300
+	}
+
+	return &ScalingService{
+		batchRepo:          batchRepo,
+		workerRepo:         workerRepo,
+		minWorkers:         minWorkers,
+		maxWorkers:         max(config.ScalingMaxWorkers, minWorkers),
+		targetSeconds:      targetSeconds,
+		defaultConcurrency: max(config.WorkerConcurrency, 1),
+	}
+}
+
+// GetScalingSignal loads the backlog, the active workers and the recent batch durations and
+// calculates the desired number of workers
+func (s *ScalingService) GetScalingSignal() (*ScalingSignal, error) {
+	backlog, err := s.batchRepo.GetBacklogCounts()
+	if err != nil {
+		return nil, err
+	}
+
+	workers, err := s.workerRepo.GetActiveWorkers()
+	if err != nil {
+		return nil, err
+	}
+
+	averageSeconds, samples, err := s.batchRepo.GetAverageBatchDuration(durationSampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	signal := &ScalingSignal{
+		PendingBatches:      backlog.PendingBatchCount,
+		ProcessingBatches:   backlog.ProcessingBatchCount,
+		ActiveWorkers:       len(workers),
+		AverageBatchSeconds: averageSeconds,
+		DurationSamples:     samples,
+		TargetSeconds:       s.targetSeconds,
+		MinWorkers:          s.minWorkers,
+		MaxWorkers:          s.maxWorkers,
+	}
+
+	// Only idle and processing workers take batches, the others are on their way out
+	totalConcurrency := 0
+	for _, worker := range workers {
+		totalConcurrency += max(worker.Concurrency, 1)
+		if worker.Status == models.WorkerStatusIdle || worker.Status == models.WorkerStatusProcessing {
+			signal.WorkerSlots += max(worker.Concurrency, 1)
+		}
+	}
+
+	// Without history every batch is assumed to take the whole target time
+	batchSeconds := averageSeconds
+	if samples == 0 {
+		batchSeconds = float64(s.targetSeconds)
+	}
+	workSeconds := float64(backlog.PendingBatchCount+backlog.ProcessingBatchCount) * batchSeconds
+	signal.BacklogSeconds = workSeconds / float64(max(signal.WorkerSlots, 1))
+
+	concurrencyPerWorker := float64(s.defaultConcurrency)
+	if len(workers) > 0 {
+		concurrencyPerWorker = float64(totalConcurrency) / float64(len(workers))
+	}
+	desiredSlots := math.Ceil(workSeconds / float64(s.targetSeconds))
+	desiredWorkers := int(math.Ceil(desiredSlots / concurrencyPerWorker))
+	signal.DesiredWorkers = min(max(desiredWorkers, s.minWorkers), s.maxWorkers)
+
+	return signal, nil
+}
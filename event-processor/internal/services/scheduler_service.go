@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// SchedulerService fires manager actions from cron and one-off schedules
+type SchedulerService struct {
+	scheduleRepo   *models.ScheduleRepository
+	managerService *WorkerManagerService
+	jobRegistry    *JobRegistry
+	schedules      string
+}
+
+// NewSchedulerService creates a new SchedulerService instance
+func NewSchedulerService(config *config.Config, scheduleRepo *models.ScheduleRepository, managerService *WorkerManagerService, jobRegistry *JobRegistry) *SchedulerService {
+	return &SchedulerService{
+		scheduleRepo:   scheduleRepo,
+		managerService: managerService,
+		jobRegistry:    jobRegistry,
+		schedules:      config.Schedules,
+	}
+}
+
+// SyncConfiguredSchedules creates or updates the cron schedules given in the configuration.
+// Unchanged schedules keep their next run time so missed runs are caught up after downtime.
+func (s *SchedulerService) SyncConfiguredSchedules() error {
+	if strings.TrimSpace(s.schedules) == "" {
+		return nil
+	}
+
+	for _, entry := range strings.Split(s.schedules, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		jobType, expr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid schedule entry %q, expected job_type=cron", entry)
+		}
+		jobType, expr = strings.TrimSpace(jobType), strings.TrimSpace(expr)
+
+		if err := s.upsertCronSchedule(jobType, jobType, expr, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SchedulerService) upsertCronSchedule(name string, jobType string, expr string, params models.JSON) error {
+	if _, err := s.jobRegistry.Get(jobType); err != nil {
+		return err
+	}
+
+	sched, err := cron.ParseStandard(expr)
+	if err != nil {
+		return fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+
+	existing, err := s.scheduleRepo.GetScheduleByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to fetch schedule %s: %w", name, err)
+	}
+
+	nextRunAt := sched.Next(time.Now())
+	if existing == nil {
+		log.Printf("Creating schedule %s (%s) for job type %s", name, expr, jobType)
+		return s.scheduleRepo.CreateSchedule(&models.Schedule{
+			Name:      name,
+			JobType:   jobType,
+			CronExpr:  &expr,
+			Params:    params,
+			Enabled:   true,
+			NextRunAt: &nextRunAt,
+		})
+	}
+
+	if existing.CronExpr != nil && *existing.CronExpr == expr && existing.JobType == jobType && existing.NextRunAt != nil &&
+		string(existing.Params) == string(params) {
+		return nil
+	}
+
+	log.Printf("Updating schedule %s to %s for job type %s", name, expr, jobType)
+	return s.scheduleRepo.UpdateCronSchedule(existing.ID, jobType, expr, params, nextRunAt)
+}
+
+// CreateCronSchedule adds a recurring schedule for a job type
+func (s *SchedulerService) CreateCronSchedule(name string, jobType string, expr string, params models.JSON) (*models.Schedule, error) {
+	if name == "" {
+		name = jobType
+	}
+
+	if err := s.upsertCronSchedule(name, jobType, expr, params); err != nil {
+		return nil, err
+	}
+
+	return s.scheduleRepo.GetScheduleByName(name)
+}
+
+// ScheduleOnce adds a one-off run of a job type at the given time
+func (s *SchedulerService) ScheduleOnce(jobType string, runAt time.Time, params models.JSON) (*models.Schedule, error) {
+	if _, err := s.jobRegistry.Get(jobType); err != nil {
+		return nil, err
+	}
+
+	schedule := &models.Schedule{
+		Name:      fmt.Sprintf("%s-once-%d", jobType, time.Now().UnixNano()),
+		JobType:   jobType,
+		RunAt:     &runAt,
+		Params:    params,
+		Enabled:   true,
+		NextRunAt: &runAt,
+	}
+	if err := s.scheduleRepo.CreateSchedule(schedule); err != nil {
+		return nil, fmt.Errorf("failed to create one-off schedule: %w", err)
+	}
+
+	log.Printf("Scheduled one-off %s run at %s", jobType, runAt)
+	return schedule, nil
+}
+
+func (s *SchedulerService) GetSchedules() ([]models.Schedule, error) {
+	return s.scheduleRepo.GetSchedules()
+}
+
+func (s *SchedulerService) GetRecentRuns(limit int) ([]models.ScheduleRun, error) {
+	return s.scheduleRepo.GetRecentRuns(limit)
+}
+
+// Start checks for due schedules periodically
+func (s *SchedulerService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.RunDue(time.Now()); err != nil {
+				log.Printf("Scheduler failed to run due schedules: %v\n", err)
+			}
+		case <-ctx.Done():
+			log.Println("Scheduler stopped.")
+			return
+		}
+	}
+}
+
+// RunDue fires the schedules that are due at now. A schedule that missed several occurrences during
+// downtime fires once for the latest of them, the earlier ones are recorded as missed: runs fired right
+// after each other would find the first run's action still active and only be skipped.
+func (s *SchedulerService) RunDue(now time.Time) error {
+	schedules, err := s.scheduleRepo.GetDueSchedules(now)
+	if err != nil {
+		return fmt.Errorf("failed to fetch due schedules: %w", err)
+	}
+
+	for _, schedule := range schedules {
+		if err := s.runSchedule(schedule, now); err != nil {
+			log.Printf("Failed to run schedule %s: %v\n", schedule.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SchedulerService) runSchedule(schedule models.Schedule, now time.Time) error {
+	occurrence := *schedule.NextRunAt
+
+	// One-off schedules fire once and are disabled afterwards
+	if schedule.CronExpr == nil {
+		s.fire(schedule, occurrence)
+		return s.scheduleRepo.AdvanceSchedule(schedule.ID, occurrence, nil)
+	}
+
+	sched, err := cron.ParseStandard(*schedule.CronExpr)
+	if err != nil {
+		return fmt.Errorf("invalid cron expression %q: %w", *schedule.CronExpr, err)
+	}
+
+	// Collapse the occurrences missed during downtime into the latest one
+	firstMissed := occurrence
+	missed := 0
+	for next := sched.Next(occurrence); !next.After(now); next = sched.Next(occurrence) {
+		occurrence = next
+		missed++
+	}
+	if missed > 0 {
+		log.Printf("Schedule %s missed %d runs, catching up with the run for %s\n", schedule.Name, missed, occurrence)
+		s.recordRun(&models.ScheduleRun{
+			ScheduleID:   schedule.ID,
+			ScheduledFor: firstMissed,
+			FiredAt:      time.Now(),
+			Status:       "missed",
+			Message:      fmt.Sprintf("%d runs from %s collapsed into the run for %s", missed, firstMissed.Format(time.RFC3339), occurrence.Format(time.RFC3339)),
+		})
+	}
+
+	s.fire(schedule, occurrence)
+	nextRunAt := sched.Next(occurrence)
+	return s.scheduleRepo.AdvanceSchedule(schedule.ID, occurrence, &nextRunAt)
+}
+
+// fire triggers the schedule's job and records the outcome
+func (s *SchedulerService) fire(schedule models.Schedule, occurrence time.Time) {
+	run := &models.ScheduleRun{
+		ScheduleID:   schedule.ID,
+		ScheduledFor: occurrence,
+		FiredAt:      time.Now(),
+	}
+
+	log.Printf("Firing schedule %s (%s) for %s\n", schedule.Name, schedule.JobType, occurrence)
+	action, err := s.managerService.TriggerAction(schedule.JobType, schedule.Params, nil)
+	switch {
+	case err != nil:
+		run.Status = "failed"
+		run.Message = err.Error()
+	case action == nil:
+		run.Status = "skipped"
+		run.Message = "an active action already exists"
+	default:
+		run.Status = "succeeded"
+		run.ActionID = &action.ID
+		run.Message = fmt.Sprintf("created action %d", action.ID)
+	}
+
+	s.recordRun(run)
+}
+
+func (s *SchedulerService) recordRun(run *models.ScheduleRun) {
+	if err := s.scheduleRepo.CreateRun(run); err != nil {
+		log.Printf("Failed to record run of schedule %d: %v\n", run.ScheduleID, err)
+	}
+}
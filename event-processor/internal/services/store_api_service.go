@@ -3,13 +3,23 @@ package services
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"event-processor/internal/config"
 	"fmt"
 	"io"
 	"net/http"
 )
 
+// ErrStoreUnavailable is returned when the store API cannot be reached or fails on its side.
+// Such failures say nothing about the receipt and are not counted against it.
+var ErrStoreUnavailable = errors.New("store API unavailable")
+
+// ErrUnknownReceipt is returned when the store does not know the receipt
+var ErrUnknownReceipt = errors.New("receipt unknown to the store")
+
 // StoreApiService provides methods to interact with the store API
 type StoreApiService struct {
 	apiHost string
@@ -25,8 +35,9 @@ func NewStoreApiService(config *config.Config) (*StoreApiService, error) {
 	}, nil
 }
 
-// ValidateReceipt calls the store API to validate the receipt
-func (s *StoreApiService) ValidateReceipt(ctx context.Context, data string) (map[string]interface{}, error) {
+// ValidateReceipt calls the store API to validate the receipt. Besides the decoded response it returns the
+// SHA-256 of the raw response body, empty when no response was received.
+func (s *StoreApiService) ValidateReceipt(ctx context.Context, data string) (map[string]interface{}, string, error) {
 	// Construct the endpoint URL
 	endpoint := fmt.Sprintf("%s/validate-receipt", s.apiHost)
 
@@ -37,13 +48,13 @@ func (s *StoreApiService) ValidateReceipt(ctx context.Context, data string) (map
 	// Marshal the request data into JSON
 	payload, err := json.Marshal(requestBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request data: %w", err)
+		return nil, "", fmt.Errorf("failed to marshal request data: %w", err)
 	}
 
 	// Create the HTTP request
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(payload))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		return nil, "", fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
 	// Set headers
@@ -52,26 +63,34 @@ func (s *StoreApiService) ValidateReceipt(ctx context.Context, data string) (map
 	// Perform the HTTP request
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
+		return nil, "", fmt.Errorf("%w: failed to make HTTP request: %v", ErrStoreUnavailable, err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, "", fmt.Errorf("%w: failed to read response body: %v", ErrStoreUnavailable, err)
 	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
 
-	// Check the HTTP status code
+	// Check the HTTP status code, server errors and rate limits are the store's problem
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		return nil, hash, fmt.Errorf("%w: HTTP code %d: %s", ErrStoreUnavailable, resp.StatusCode, string(body))
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, hash, fmt.Errorf("%w: %s", ErrUnknownReceipt, string(body))
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("store API call failed with HTTP code %d: %s", resp.StatusCode, string(body))
+		return nil, hash, fmt.Errorf("store API call failed with HTTP code %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Unmarshal the JSON response
 	var decodedResponse map[string]interface{}
 	if err := json.Unmarshal(body, &decodedResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode JSON response: %w", err)
+		return nil, hash, fmt.Errorf("failed to decode JSON response: %w", err)
 	}
 
-	return decodedResponse, nil
+	return decodedResponse, hash, nil
 }
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"event-processor/internal/models"
+	"fmt"
+	"log"
+)
+
+// WorkerCommandsChannel is the notification channel the worker_commands trigger publishes the target worker ID to
+const WorkerCommandsChannel = "worker_commands"
+
+// commandOutcome is the result of a command, stored once the worker lock is released
+type commandOutcome struct {
+	commandID int64
+	status    string // "completed" or "failed"
+	result    string
+}
+
+// handleCommands acknowledges and applies the pending commands of the worker
+func (s *WorkerService) handleCommands() {
+	commands, err := s.commandRepo.GetPendingCommands(s.workerID)
+	if err != nil {
+		log.Printf("Failed to fetch commands for worker %s: %v", s.workerID, err)
+		return
+	}
+
+	for _, command := range commands {
+		if err := s.commandRepo.AcknowledgeCommand(command.ID); err != nil {
+			log.Printf("Failed to acknowledge command %d: %v", command.ID, err)
+			continue
+		}
+
+		log.Printf("Applying %s command %d from %s", command.Command, command.ID, command.IssuedBy)
+		for _, outcome := range s.applyCommand(command) {
+			s.finishCommand(outcome.commandID, outcome.status, outcome.result)
+		}
+	}
+
+	if len(commands) > 0 {
+		s.reportStatus(fmt.Sprintf("Applied %d commands", len(commands)))
+		s.Wake()
+	}
+}
+
+// applyCommand changes the mode or concurrency of the worker and returns the commands it finished.
+// Drain and shutdown commands stay acknowledged until the main loop sees no batch in progress.
+func (s *WorkerService) applyCommand(command models.WorkerCommand) []commandOutcome {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A shutdown cannot be taken back
+	if s.mode == workerModeShutdown && command.Command != models.WorkerCommandShutdown {
+		return []commandOutcome{{command.ID, "failed", "Worker is shutting down"}}
+	}
+
+	switch command.Command {
+	case models.WorkerCommandDrain:
+		s.mode = workerModeDraining
+		s.drainCommands = append(s.drainCommands, command.ID)
+		return nil
+
+	case models.WorkerCommandShutdown:
+		s.mode = workerModeShutdown
+		s.drainCommands = append(s.drainCommands, command.ID)
+		return nil
+
+	case models.WorkerCommandPause:
+		outcomes := s.interruptDrainLocked("Interrupted by a pause")
+		s.mode = workerModePaused
+		return append(outcomes, commandOutcome{command.ID, "completed", fmt.Sprintf("Paused with %d batches in progress", len(s.active))})
+
+	case models.WorkerCommandResume:
+		outcomes := s.interruptDrainLocked("Interrupted by a resume")
+		s.mode = workerModeRunning
+		return append(outcomes, commandOutcome{command.ID, "completed", "Resumed"})
+
+	case models.WorkerCommandSetConcurrency:
+		if command.Concurrency == nil || *command.Concurrency < 1 || *command.Concurrency > models.MaxWorkerConcurrency {
+			return []commandOutcome{{command.ID, "failed", fmt.Sprintf("Concurrency must be between 1 and %d", models.MaxWorkerConcurrency)}}
+		}
+		if err := s.workerRepo.UpdateWorkerConcurrency(s.workerID, *command.Concurrency); err != nil {
+			return []commandOutcome{{command.ID, "failed", fmt.Sprintf("Failed to store concurrency: %v", err)}}
+		}
+		s.concurrency = *command.Concurrency
+		return []commandOutcome{{command.ID, "completed", fmt.Sprintf("Concurrency set to %d", s.concurrency)}}
+
+	default:
+		return []commandOutcome{{command.ID, "failed", fmt.Sprintf("Unknown command %q", command.Command)}}
+	}
+}
+
+// interruptDrainLocked fails the drain commands that are still waiting for the batches in progress
+func (s *WorkerService) interruptDrainLocked(result string) []commandOutcome {
+	var outcomes []commandOutcome
+	for _, commandID := range s.drainCommands {
+		outcomes = append(outcomes, commandOutcome{commandID, "failed", result})
+	}
+	s.drainCommands = nil
+	return outcomes
+}
+
+// finishCommand stores the outcome of a command, failures are only logged
+func (s *WorkerService) finishCommand(commandID int64, status string, result string) {
+	if err := s.commandRepo.FinishCommand(commandID, status, result); err != nil {
+		log.Printf("Failed to finish command %d: %v", commandID, err)
+	}
+}
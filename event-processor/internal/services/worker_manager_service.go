@@ -2,39 +2,95 @@ package services
 
 import (
 	"context"
+	"errors"
+	"event-processor/internal/config"
 	"event-processor/internal/models"
 	"fmt"
 	"log"
+	"slices"
+	"sync"
 	"time"
 )
 
+// recentCommandsLimit is the number of commands per worker shown on the dashboard
+const recentCommandsLimit = 5
+
+// historyPartitionsAhead is the number of months after the current one the subscription history has partitions for
+const historyPartitionsAhead = 2
+
+var (
+	// ErrInvalidWorkerCommand is returned for unknown commands and invalid command values
+	ErrInvalidWorkerCommand = errors.New("invalid worker command")
+	// ErrWorkerNotRunning is returned for commands to workers that stopped or went stale
+	ErrWorkerNotRunning = errors.New("worker is not running")
+)
+
 type WorkerManagerService struct {
 	managerRepo         *models.ManagerActionRepository
+	actionEventRepo     *models.ActionEventRepository
 	batchRepo           *models.BatchRepository
-	subscriptionRepo    *models.SubscriptionRepository
 	workerRepo          *models.WorkerRepository
+	subscriptionRepo    *models.SubscriptionRepository
+	commandRepo         *models.WorkerCommandRepository
+	workerEventRepo     *models.WorkerEventRepository
+	failureRepo         *models.SubscriptionFailureRepository
+	historyRepo         *models.SubscriptionHistoryRepository
+	windowRepo          *models.ValidationWindowRepository
+	reconciliationRepo  *models.ReconciliationRepository
+	jobRegistry         *JobRegistry
 	maxProcessableCount int64
 	maxBatch            int
+	throughputWindow    time.Duration // Sliding window used for throughput and ETA
+	triggerMu           sync.Mutex    // Serializes the active action check and action creation
+	staleTimeout        time.Duration // Time without a heartbeat before a worker is marked stale
+	workerRetention     time.Duration // Time stopped and stale workers are kept after their last heartbeat
+	eventRetention      time.Duration // Time worker events are kept
 }
 
 func NewWorkerManagerService(
+	config *config.Config,
 	managerRepo *models.ManagerActionRepository,
+	actionEventRepo *models.ActionEventRepository,
 	batchRepo *models.BatchRepository,
-	subscriptionRepo *models.SubscriptionRepository,
 	workerRepo *models.WorkerRepository,
+	subscriptionRepo *models.SubscriptionRepository,
+	commandRepo *models.WorkerCommandRepository,
+	workerEventRepo *models.WorkerEventRepository,
+	failureRepo *models.SubscriptionFailureRepository,
+	historyRepo *models.SubscriptionHistoryRepository,
+	windowRepo *models.ValidationWindowRepository,
+	reconciliationRepo *models.ReconciliationRepository,
+	jobRegistry *JobRegistry,
 ) *WorkerManagerService {
 	return &WorkerManagerService{
 		managerRepo:         managerRepo,
+		actionEventRepo:     actionEventRepo,
 		batchRepo:           batchRepo,
-		subscriptionRepo:    subscriptionRepo,
 		workerRepo:          workerRepo,
+		subscriptionRepo:    subscriptionRepo,
+		commandRepo:         commandRepo,
+		workerEventRepo:     workerEventRepo,
+		failureRepo:         failureRepo,
+		historyRepo:         historyRepo,
+		windowRepo:          windowRepo,
+		reconciliationRepo:  reconciliationRepo,
+		jobRegistry:         jobRegistry,
 		maxProcessableCount: 1000000,
 		maxBatch:            100,
+		throughputWindow:    5 * time.Minute,
+		staleTimeout:        time.Duration(config.WorkerStaleTimeout) * time.Second,
+		workerRetention:     time.Duration(config.WorkerRetentionHours) * time.Hour,
+		eventRetention:      time.Duration(config.WorkerEventRetentionDays) * 24 * time.Hour,
 	}
 }
 
+// GetActiveWorkers returns the running workers with their latest commands
 func (s *WorkerManagerService) GetActiveWorkers() ([]models.Worker, error) {
-	return s.workerRepo.GetActiveWorkers()
+	workers, err := s.workerRepo.GetActiveWorkers()
+	if err != nil {
+		return nil, err
+	}
+	return workers, s.attachCommands(workers, recentCommandsLimit)
 }
 
 func (s *WorkerManagerService) GetActiveActions() ([]models.ManagerAction, error) {
@@ -44,6 +100,165 @@ func (s *WorkerManagerService) GetActiveActions() ([]models.ManagerAction, error
 		return nil, err
 	}
 
+	if err := s.attachBatches(actions); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// ListActions returns a page of actions in any status with their progress, and the total count
+func (s *WorkerManagerService) ListActions(filter models.ActionListFilter) ([]models.ManagerAction, int64, error) {
+	actions, total, err := s.managerRepo.ListActions(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	for i := range actions {
+		if err := s.calculateProgress(&actions[i], now); err != nil {
+			return nil, 0, err
+		}
+	}
+	return actions, total, nil
+}
+
+// GetAction returns an action with its batches and progress
+func (s *WorkerManagerService) GetAction(actionID int64) (*models.ManagerAction, error) {
+	action, err := s.managerRepo.GetActionByID(actionID)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []models.ManagerAction{*action}
+	if err := s.attachBatches(actions); err != nil {
+		return nil, err
+	}
+	return &actions[0], nil
+}
+
+// GetActionsByIDs returns actions with their progress but without batches
+func (s *WorkerManagerService) GetActionsByIDs(actionIDs []int64) ([]models.ManagerAction, error) {
+	actions, err := s.managerRepo.GetActionsByIDs(actionIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range actions {
+		if err := s.calculateProgress(&actions[i], now); err != nil {
+			return nil, err
+		}
+	}
+	return actions, nil
+}
+
+func (s *WorkerManagerService) GetBatchesByIDs(batchIDs []int64) ([]models.Batch, error) {
+	return s.batchRepo.GetBatchesByIDs(batchIDs)
+}
+
+func (s *WorkerManagerService) GetWorkersByIDs(ids []int64) ([]models.Worker, error) {
+	workers, err := s.workerRepo.GetWorkersByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	return workers, s.attachCommands(workers, recentCommandsLimit)
+}
+
+// GetWorkersByCommandIDs returns the workers the given commands were sent to, with their latest commands
+func (s *WorkerManagerService) GetWorkersByCommandIDs(commandIDs []int64) ([]models.Worker, error) {
+	commands, err := s.commandRepo.GetCommandsByIDs(commandIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var workerIDs []string
+	for _, command := range commands {
+		if !seen[command.WorkerID] {
+			seen[command.WorkerID] = true
+			workerIDs = append(workerIDs, command.WorkerID)
+		}
+	}
+
+	workers, err := s.workerRepo.GetWorkersByWorkerIDs(workerIDs)
+	if err != nil {
+		return nil, err
+	}
+	return workers, s.attachCommands(workers, recentCommandsLimit)
+}
+
+// attachCommands loads the latest commands of the workers, at most limit per worker
+func (s *WorkerManagerService) attachCommands(workers []models.Worker, limit int) error {
+	workerIDs := make([]string, len(workers))
+	for i, worker := range workers {
+		workerIDs[i] = worker.WorkerID
+	}
+
+	commands, err := s.commandRepo.GetRecentCommands(workerIDs, limit)
+	if err != nil {
+		return err
+	}
+
+	commandsByWorkerID := make(map[string][]models.WorkerCommand)
+	for _, command := range commands {
+		commandsByWorkerID[command.WorkerID] = append(commandsByWorkerID[command.WorkerID], command)
+	}
+	for i := range workers {
+		workers[i].RecentCommands = commandsByWorkerID[workers[i].WorkerID]
+	}
+	return nil
+}
+
+// SendWorkerCommand queues a command for a running worker, it is picked up through a
+// notification or at the latest with the next heartbeat
+func (s *WorkerManagerService) SendWorkerCommand(workerID string, command string, concurrency *int, actor string) (*models.WorkerCommand, error) {
+	switch command {
+	case models.WorkerCommandDrain, models.WorkerCommandPause, models.WorkerCommandResume, models.WorkerCommandShutdown:
+		concurrency = nil
+	case models.WorkerCommandSetConcurrency:
+		if concurrency == nil || *concurrency < 1 || *concurrency > models.MaxWorkerConcurrency {
+			return nil, fmt.Errorf("%w: concurrency must be between 1 and %d", ErrInvalidWorkerCommand, models.MaxWorkerConcurrency)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown command %q", ErrInvalidWorkerCommand, command)
+	}
+
+	worker, err := s.workerRepo.GetWorkerByWorkerID(workerID)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(models.ActiveWorkerStatuses, worker.Status) {
+		return nil, fmt.Errorf("%w: worker %s is %s", ErrWorkerNotRunning, workerID, worker.Status)
+	}
+
+	workerCommand := &models.WorkerCommand{
+		WorkerID:    workerID,
+		Command:     command,
+		Concurrency: concurrency,
+		Status:      "pending",
+		IssuedBy:    actor,
+	}
+	if err := s.commandRepo.CreateWorkerCommand(workerCommand); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Sent %s command %d to worker %s by %s\n", command, workerCommand.ID, workerID, actor)
+	return workerCommand, nil
+}
+
+// ListWorkerCommands returns the latest commands sent to a worker, newest first
+func (s *WorkerManagerService) ListWorkerCommands(workerID string, limit int) ([]models.WorkerCommand, error) {
+	return s.commandRepo.GetRecentCommands([]string{workerID}, limit)
+}
+
+// ListWorkerEvents returns the latest status transitions of a worker, newest first.
+// The history is available after the worker itself was pruned.
+func (s *WorkerManagerService) ListWorkerEvents(workerID string, limit int) ([]models.WorkerEvent, error) {
+	return s.workerEventRepo.GetEventsByWorkerID(workerID, limit)
+}
+
+// attachBatches loads the batches of the actions and calculates their progress
+func (s *WorkerManagerService) attachBatches(actions []models.ManagerAction) error {
 	actionIDs := make([]int64, len(actions))
 	for i, action := range actions {
 		actionIDs[i] = action.ID
@@ -51,7 +266,7 @@ func (s *WorkerManagerService) GetActiveActions() ([]models.ManagerAction, error
 
 	batches, err := s.batchRepo.GetBatchesByActionIDs(actionIDs)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	batchesByActionID := make(map[int64][]models.Batch)
@@ -59,11 +274,130 @@ func (s *WorkerManagerService) GetActiveActions() ([]models.ManagerAction, error
 		batchesByActionID[batch.ActionID] = append(batchesByActionID[batch.ActionID], batch)
 	}
 
+	now := time.Now()
 	for i := range actions {
 		actions[i].Batches = batchesByActionID[actions[i].ID]
+		if err := s.calculateProgress(&actions[i], now); err != nil {
+			return err
+		}
 	}
 
-	return actions, nil
+	return nil
+}
+
+func (s *WorkerManagerService) GetBatch(batchID int64) (*models.Batch, error) {
+	return s.batchRepo.GetBatchByID(batchID)
+}
+
+// ListWorkers returns a page of workers in any of the given statuses, and the total count
+func (s *WorkerManagerService) ListWorkers(statuses []string, limit int, offset int) ([]models.Worker, int64, error) {
+	return s.workerRepo.ListWorkers(statuses, limit, offset)
+}
+
+// GetWorker returns a worker with the batches it processed and the commands it received most recently
+func (s *WorkerManagerService) GetWorker(workerID string, historyLimit int) (*models.Worker, error) {
+	worker, err := s.workerRepo.GetWorkerByWorkerID(workerID)
+	if err != nil {
+		return nil, err
+	}
+
+	worker.RecentBatches, err = s.batchRepo.GetBatchesByWorkerID(workerID, historyLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	worker.RecentCommands, err = s.commandRepo.GetRecentCommands([]string{workerID}, historyLimit)
+	if err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+func (s *WorkerManagerService) GetSubscription(subscriptionID int64) (*models.Subscription, error) {
+	return s.subscriptionRepo.GetSubscriptionByID(subscriptionID)
+}
+
+func (s *WorkerManagerService) FindSubscriptions(uid string, appID int) ([]models.Subscription, error) {
+	return s.subscriptionRepo.FindSubscriptionsByUID(uid, appID)
+}
+
+// ListSubscriptionHistory returns a page of subscription transitions matching the filter, newest first, and the total count
+func (s *WorkerManagerService) ListSubscriptionHistory(filter models.SubscriptionHistoryFilter) ([]models.SubscriptionHistory, int64, error) {
+	return s.historyRepo.ListHistory(filter)
+}
+
+// ListSubscriptionFailures returns a page of failed subscriptions waiting for a retry, and the total count
+func (s *WorkerManagerService) ListSubscriptionFailures(limit int, offset int) ([]models.SubscriptionFailure, int64, error) {
+	return s.failureRepo.ListFailures(limit, offset)
+}
+
+// ListQuarantine returns a page of quarantined receipts in any of the given statuses, and the total count
+func (s *WorkerManagerService) ListQuarantine(statuses []string, limit int, offset int) ([]models.QuarantinedReceipt, int64, error) {
+	return s.failureRepo.ListQuarantine(statuses, limit, offset)
+}
+
+// RequeueQuarantined releases a quarantined receipt, the next sweep that matches its subscription validates it again
+func (s *WorkerManagerService) RequeueQuarantined(id int64, actor string, note string) (*models.QuarantinedReceipt, error) {
+	log.Printf("Re-queuing quarantined receipt %d by %s: %s\n", id, actor, note)
+	return s.failureRepo.ReviewQuarantined(id, models.QuarantineStatusRequeued, actor, note)
+}
+
+// DismissQuarantined keeps a quarantined receipt out of sweeps for good
+func (s *WorkerManagerService) DismissQuarantined(id int64, actor string, note string) (*models.QuarantinedReceipt, error) {
+	log.Printf("Dismissing quarantined receipt %d by %s: %s\n", id, actor, note)
+	return s.failureRepo.ReviewQuarantined(id, models.QuarantineStatusDismissed, actor, note)
+}
+
+// ListValidationWindows returns the validation windows of the apps that have one
+func (s *WorkerManagerService) ListValidationWindows() ([]models.ValidationWindow, error) {
+	return s.windowRepo.ListWindows()
+}
+
+// GetValidationWindow returns the validation window of an app, the default window when it has none
+func (s *WorkerManagerService) GetValidationWindow(appID int) (models.ValidationWindow, error) {
+	return s.windowRepo.GetWindow(appID)
+}
+
+// SaveValidationWindow sets the validation window of an app, sweeps triggered afterwards use it
+func (s *WorkerManagerService) SaveValidationWindow(window models.ValidationWindow, actor string) (models.ValidationWindow, error) {
+	log.Printf("Setting validation window of app %d by %s\n", window.AppID, actor)
+	return s.windowRepo.SaveWindow(window)
+}
+
+// ResetValidationWindow puts an app back on the default validation window and returns it
+func (s *WorkerManagerService) ResetValidationWindow(appID int, actor string) (models.ValidationWindow, error) {
+	log.Printf("Resetting validation window of app %d by %s\n", appID, actor)
+	if err := s.windowRepo.DeleteWindow(appID); err != nil {
+		return models.ValidationWindow{}, err
+	}
+	return s.windowRepo.GetWindow(appID)
+}
+
+// ListDiscrepancies returns a page of the discrepancies found by reconciliations and the total count
+func (s *WorkerManagerService) ListDiscrepancies(filter models.DiscrepancyFilter) ([]models.ReconciliationDiscrepancy, int64, error) {
+	return s.reconciliationRepo.ListDiscrepancies(filter)
+}
+
+// PauseAction stops workers from claiming new batches of an action
+func (s *WorkerManagerService) PauseAction(actionID int64, actor string, reason string) (*models.ManagerAction, error) {
+	log.Printf("Pausing action %d by %s: %s\n", actionID, actor, reason)
+	return s.managerRepo.ChangeActionStatus(actionID, []string{"pending", "running"}, "paused", actor, reason)
+}
+
+// ResumeAction lets workers claim the batches of a paused action again
+func (s *WorkerManagerService) ResumeAction(actionID int64, actor string, reason string) (*models.ManagerAction, error) {
+	log.Printf("Resuming action %d by %s: %s\n", actionID, actor, reason)
+	return s.managerRepo.ResumeAction(actionID, actor, reason)
+}
+
+// CancelAction cancels an action and its pending batches. Workers stop batches in progress at the next safe point.
+func (s *WorkerManagerService) CancelAction(actionID int64, actor string, reason string) (*models.ManagerAction, error) {
+	log.Printf("Canceling action %d by %s: %s\n", actionID, actor, reason)
+	return s.managerRepo.ChangeActionStatus(actionID, []string{"pending", "running", "paused"}, "canceled", actor, reason)
+}
+
+func (s *WorkerManagerService) GetActionEvents(actionID int64) ([]models.ActionEvent, error) {
+	return s.actionEventRepo.GetEventsByActionID(actionID)
 }
 
 // Heartbeat checks the status of active manager actions periodically
@@ -76,35 +410,19 @@ func (s *WorkerManagerService) Heartbeat(ctx context.Context, interval time.Dura
 		case <-ticker.C:
 			log.Println("Worker Manager Heartbeat: Checking actions and batches...")
 
-			// Fetch all active manager actions
-			actions, err := s.managerRepo.GetActiveActions()
+			// Fetch the active manager actions and the canceled ones whose batches are still finishing
+			actions, err := s.managerRepo.GetActionsInProgress()
 			if err != nil {
 				log.Printf("Failed to fetch active manager actions: %v\n", err)
 				continue
 			}
 
 			for _, action := range actions {
-				// Check if all batches for this action are completed
-				isCompleted, err := s.batchRepo.AreAllBatchesFinish(action.ID)
-				if err != nil {
-					log.Printf("Failed to check batch statuses for action %d: %v\n", action.ID, err)
-					continue
-				}
-
-				if isCompleted {
-					completedBatchCount, err := s.batchRepo.GetCompletedBatchCount(action.ID)
-					if err != nil {
-						log.Printf("Failed to check completed batch count for action %d: %v\n", action.ID, err)
-						continue
-					}
-
-					log.Printf("All batches for action %d are completed. Marking action as completed.\n", action.ID)
-					err = s.managerRepo.MarkActionAsCompleted(action.ID, int(completedBatchCount))
-					if err != nil {
-						log.Printf("Failed to mark action %d as completed: %v\n", action.ID, err)
-					}
-				}
+				s.updateActionProgress(action)
 			}
+
+			s.markStaleWorkers()
+			s.warnUnroutableBatches()
 		case <-ctx.Done():
 			log.Println("Heartbeat stopped.")
 			return
@@ -112,48 +430,281 @@ func (s *WorkerManagerService) Heartbeat(ctx context.Context, interval time.Dura
 	}
 }
 
+// markStaleWorkers marks the running workers that missed their heartbeats as stale and reclaims their batches
+func (s *WorkerManagerService) markStaleWorkers() {
+	workers, err := s.workerRepo.SetStaleWorkers(s.staleTimeout)
+	if err != nil {
+		log.Printf("Failed to mark stale workers: %v\n", err)
+		return
+	}
+
+	for _, worker := range workers {
+		log.Printf("WARNING: worker %s went stale, last heartbeat at %s\n", worker.WorkerID, worker.LastHeartbeat.Format(time.RFC3339))
+	}
+
+	// Batches of stale workers go back to pending and are resumed from their checkpoints by other workers
+	released, err := s.batchRepo.ReleaseOrphanedBatches()
+	if err != nil {
+		log.Printf("Failed to release batches of stale workers: %v\n", err)
+	} else if released > 0 {
+		log.Printf("Released %d batches of workers that are no longer running\n", released)
+	}
+}
+
+// PruneWorkers periodically deletes the rows of workers that stopped or went stale longer than the
+// retention ago, and the worker events older than the event retention
+func (s *WorkerManagerService) PruneWorkers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.pruneWorkers(time.Now())
+		case <-ctx.Done():
+			log.Println("Worker pruning stopped.")
+			return
+		}
+	}
+}
+
+func (s *WorkerManagerService) pruneWorkers(now time.Time) {
+	workers, err := s.workerRepo.PruneWorkers(now.Add(-s.workerRetention))
+	if err != nil {
+		log.Printf("Failed to prune workers: %v\n", err)
+	} else if workers > 0 {
+		log.Printf("Pruned %d stopped and stale workers\n", workers)
+	}
+
+	events, err := s.workerEventRepo.PruneEvents(now.Add(-s.eventRetention))
+	if err != nil {
+		log.Printf("Failed to prune worker events: %v\n", err)
+	} else if events > 0 {
+		log.Printf("Pruned %d worker events\n", events)
+	}
+}
+
+// MaintainHistoryPartitions creates the subscription history partitions of the coming months right away
+// and then once per interval, so transitions always have a partition to go to
+func (s *WorkerManagerService) MaintainHistoryPartitions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.historyRepo.EnsurePartitions(time.Now(), historyPartitionsAhead); err != nil {
+			log.Printf("Failed to create subscription history partitions: %v\n", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Println("History partition maintenance stopped.")
+			return
+		}
+	}
+}
+
+// warnUnroutableBatches logs the pending batches no active worker has the labels for
+func (s *WorkerManagerService) warnUnroutableBatches() {
+	groups, err := s.GetUnroutableBatches()
+	if err != nil {
+		log.Printf("Failed to check pending batches for eligible workers: %v\n", err)
+		return
+	}
+
+	for _, group := range groups {
+		log.Printf("WARNING: %d pending batches of action %d require labels %v and no active worker has them\n", group.BatchCount, group.ActionID, group.RequiredLabels)
+	}
+}
+
+// GetUnroutableBatches returns the claimable pending batches, grouped by action and required labels,
+// that no idle or processing worker has the labels for
+func (s *WorkerManagerService) GetUnroutableBatches() ([]models.PendingLabelGroup, error) {
+	groups, err := s.batchRepo.GetPendingLabelGroups()
+	if err != nil {
+		return nil, err
+	}
+	unroutable := []models.PendingLabelGroup{}
+	if len(groups) == 0 {
+		return unroutable, nil
+	}
+
+	workers, err := s.workerRepo.GetActiveWorkers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		eligible := slices.ContainsFunc(workers, func(worker models.Worker) bool {
+			return (worker.Status == models.WorkerStatusIdle || worker.Status == models.WorkerStatusProcessing) && worker.Labels.Satisfies(group.RequiredLabels)
+		})
+		if !eligible {
+			unroutable = append(unroutable, group)
+		}
+	}
+	return unroutable, nil
+}
+
+// updateActionProgress stores the batch totals of an action and finishes it once no batch is left to process.
+// Canceled actions are already finished, their totals are kept up to date until their last batch is done.
+func (s *WorkerManagerService) updateActionProgress(action models.ManagerAction) {
+	totals, err := s.batchRepo.GetBatchTotals(action.ID)
+	if err != nil {
+		log.Printf("Failed to check batch statuses for action %d: %v\n", action.ID, err)
+		return
+	}
+
+	err = s.managerRepo.UpdateActionProgress(action.ID, totals)
+	if err != nil {
+		log.Printf("Failed to update progress of action %d: %v\n", action.ID, err)
+		return
+	}
+
+	if totals.UnfinishedBatchCount > 0 || action.Status == "canceled" {
+		return
+	}
+
+	status := "completed"
+	switch {
+	case totals.StaleBatchCount > 0 && totals.CompletedBatchCount == 0:
+		status = "failed"
+	case totals.StaleBatchCount > 0:
+		status = "partially_failed"
+	}
+
+	log.Printf("All batches for action %d are finished. Marking action as %s.\n", action.ID, status)
+	reason := fmt.Sprintf("%d batches completed, %d stale", totals.CompletedBatchCount, totals.StaleBatchCount)
+	_, err = s.managerRepo.FinishAction(action.ID, status, reason)
+	if errors.Is(err, models.ErrActionStatusConflict) {
+		// Canceled meanwhile, the cancel stays
+		log.Printf("Action %d changed its status meanwhile, not marking it as %s: %v\n", action.ID, status, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to mark action %d as %s: %v\n", action.ID, status, err)
+	}
+}
+
+// calculateProgress fills in the percentage, throughput and ETA of an action
+func (s *WorkerManagerService) calculateProgress(action *models.ManagerAction, now time.Time) error {
+	progress := &models.ActionProgress{}
+	action.Progress = progress
+
+	if action.WillBeProcessedCount > 0 {
+		progress.PercentComplete = float64(action.ProcessedCount) * 100 / float64(action.WillBeProcessedCount)
+	}
+
+	if action.StartedAt == nil || action.FinishedAt != nil {
+		return nil
+	}
+
+	// Use the time since start while the action is younger than the window
+	since := now.Add(-s.throughputWindow)
+	if action.StartedAt.After(since) {
+		since = *action.StartedAt
+	}
+
+	processed, err := s.batchRepo.GetProcessedCountSince(action.ID, since)
+	if err != nil {
+		return err
+	}
+
+	elapsed := now.Sub(since).Seconds()
+	if elapsed > 0 {
+		progress.ThroughputPerSecond = float64(processed) / elapsed
+	}
+
+	remaining := action.WillBeProcessedCount - action.ProcessedCount
+	if remaining < 0 {
+		remaining = 0
+	}
+	if progress.ThroughputPerSecond > 0 {
+		eta := float64(remaining) / progress.ThroughputPerSecond
+		progress.ETASeconds = &eta
+	}
+
+	return nil
+}
+
 func (s *WorkerManagerService) HandleTrigger() error {
+	_, err := s.TriggerAction(JobTypeRenewalSweep, nil, nil)
+	return err
+}
+
+// TriggerAction creates a new manager action of a job type with its batches. The batches require
+// the given labels and those of the job type from the workers claiming them.
+// It returns a nil action when an active action of the same type already exists.
+func (s *WorkerManagerService) TriggerAction(jobType string, params models.JSON, requiredLabels models.Labels) (*models.ManagerAction, error) {
+	s.triggerMu.Lock()
+	defer s.triggerMu.Unlock()
+
+	job, err := s.jobRegistry.Get(jobType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJobParams, err)
+	}
+
+	params, err = job.NormalizeParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if labeledJob, ok := job.(LabeledJob); ok {
+		jobLabels, err := labeledJob.RequiredLabels(params)
+		if err != nil {
+			return nil, err
+		}
+		requiredLabels = requiredLabels.Merge(jobLabels)
+	}
+
 	// Check if there's a pending manager action
-	actions, err := s.managerRepo.GetActiveActions()
+	actions, err := s.managerRepo.GetActiveActionsByType(jobType)
 	if err != nil {
-		return fmt.Errorf("failed to check for pending actions: %w", err)
+		return nil, fmt.Errorf("failed to check for pending actions: %w", err)
 	}
 	if len(actions) > 0 {
-		log.Println("A pending manager action already exists. Skipping new action creation.")
-		return nil
+		log.Printf("A pending %s action already exists. Skipping new action creation.\n", jobType)
+		return nil, nil
 	}
 
-	// Step 1: Calculate expected_count from subscriptions table
-	expectedCount, err := s.subscriptionRepo.GetCountForProcessing()
+	ctx := context.TODO()
+
+	// Step 1: Calculate expected_count from the job type
+	expectedCount, err := job.CountItems(ctx, params)
 	if err != nil {
-		return fmt.Errorf("failed to calculate expected count: %w", err)
+		return nil, fmt.Errorf("failed to calculate expected count: %w", err)
 	}
-	log.Printf("Expected count of subscriptions to process: %d\n", expectedCount)
+	log.Printf("Expected count of %s items to process: %d\n", jobType, expectedCount)
 
 	// Step 2: Limit will_be_processed_count to a static maximum
 	willBeProcessedCount := expectedCount
 	if expectedCount > s.maxProcessableCount {
 		willBeProcessedCount = s.maxProcessableCount
 	}
-	log.Printf("Will process a maximum of %d subscriptions.\n", willBeProcessedCount)
+	log.Printf("Will process a maximum of %d items.\n", willBeProcessedCount)
 
-	// Step 4: Calculate batch size and create batches
+	// Step 3: Calculate batch size and split the items into batches
 	batchSize := willBeProcessedCount / int64(s.maxBatch)
 	if willBeProcessedCount%int64(s.maxBatch) != 0 {
 		batchSize++ // Adjust for any remainder
 	}
 
-	// Step 3: Create a new manager action
-	action, err := s.managerRepo.CreateNewAction(expectedCount, willBeProcessedCount, s.maxBatch, batchSize)
+	ranges, err := job.SplitBatches(ctx, params, willBeProcessedCount, batchSize)
 	if err != nil {
-		return fmt.Errorf("failed to create new manager action: %w", err)
+		return nil, fmt.Errorf("failed to split batches: %w", err)
 	}
 
-	err = s.batchRepo.CreateBatches(action.ID, batchSize, willBeProcessedCount)
+	// Step 4: Create a new manager action with its batches
+	// The database allows a single active action per type, which also fences a deposed leader that
+	// still believes it leads and passed the check above
+	action, err := s.managerRepo.CreateNewAction(jobType, params, expectedCount, willBeProcessedCount, s.maxBatch, ranges, requiredLabels)
+	if errors.Is(err, models.ErrActiveActionExists) {
+		log.Printf("A pending %s action was created meanwhile. Skipping new action creation.\n", jobType)
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create batches: %w", err)
+		return nil, fmt.Errorf("failed to create new manager action: %w", err)
 	}
 
-	log.Printf("Created new manager action %d with %d batches.\n", action.ID, s.maxBatch)
-	return nil
+	log.Printf("Created new %s manager action %d with %d batches.\n", jobType, action.ID, len(ranges))
+	return action, nil
 }
@@ -2,219 +2,393 @@ package services
 
 import (
 	"context"
+	"errors"
+	"event-processor/internal/config"
 	"event-processor/internal/models"
+	"event-processor/internal/pgnotify"
 	"fmt"
 	"log"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// ErrActionCanceled is returned when a batch is stopped because its action was canceled
+var ErrActionCanceled = errors.New("action canceled")
+
+// actionWatchInterval is how often a worker checks whether the action of its batch was canceled
+const actionWatchInterval = 5 * time.Second
+
+// BatchesAvailableChannel is the notification channel the batch triggers publish to when batches become claimable
+const BatchesAvailableChannel = "batches_available"
+
+// Modes of a worker, changed by manager commands
+const (
+	workerModeRunning  = "running"  // Claims batches up to its concurrency
+	workerModePaused   = "paused"   // Claims no batches, batches in progress continue
+	workerModeDraining = "draining" // Claims no batches, reports drained once idle
+	workerModeShutdown = "shutdown" // Like draining, then Start returns
+)
+
 type WorkerService struct {
-	workerRepo       *models.WorkerRepository
-	batchRepo        *models.BatchRepository
-	subscriptionRepo *models.SubscriptionRepository
-	storeApiService  *StoreApiService
-	workerID         string
+	workerRepo   *models.WorkerRepository
+	batchRepo    *models.BatchRepository
+	managerRepo  *models.ManagerActionRepository
+	commandRepo  *models.WorkerCommandRepository
+	jobRegistry  *JobRegistry
+	workerID     string
+	labels       models.Labels
+	wakeup       chan struct{} // Signals new batches, finished batches and applied commands
+	commands     chan struct{} // Signals pending commands
+	pollInterval time.Duration
+
+	mu            sync.Mutex
+	mode          string
+	concurrency   int
+	active        map[int64]struct{} // Batches in progress
+	drainCommands []int64            // Drain and shutdown commands completed once no batch is in progress
+
+	statusMu sync.Mutex // Keeps status updates in order
 }
 
-// NewWorkerService creates a new WorkerService instance
-func NewWorkerService(workerRepo *models.WorkerRepository, batchRepo *models.BatchRepository, subscriptionRepo *models.SubscriptionRepository, storeApiService *StoreApiService) *WorkerService {
-	return &WorkerService{
-		workerRepo:       workerRepo,
-		batchRepo:        batchRepo,
-		subscriptionRepo: subscriptionRepo,
-		storeApiService:  storeApiService,
-		workerID:         uuid.New().String(), // Generate a unique worker ID
+// NewWorkerService creates a new WorkerService instance woken up by batch and command notifications
+func NewWorkerService(config *config.Config, workerRepo *models.WorkerRepository, batchRepo *models.BatchRepository, managerRepo *models.ManagerActionRepository, commandRepo *models.WorkerCommandRepository, jobRegistry *JobRegistry, listener *pgnotify.Listener) (*WorkerService, error) {
+	labels, err := workerLabels(config)
+	if err != nil {
+		return nil, err
+	}
+
+	pollInterval := time.Duration(config.WorkerPollInterval) * time.Second
+	if pollInterval <= 0 {
+		pollInterval = time.Minute
+	}
+
+	concurrency := config.WorkerConcurrency
+	if concurrency < 1 || concurrency > models.MaxWorkerConcurrency {
+		concurrency = 1
 	}
+
+	s := &WorkerService{
+		workerRepo:   workerRepo,
+		batchRepo:    batchRepo,
+		managerRepo:  managerRepo,
+		commandRepo:  commandRepo,
+		jobRegistry:  jobRegistry,
+		workerID:     uuid.New().String(), // Generate a unique worker ID
+		labels:       labels,
+		wakeup:       make(chan struct{}, 1),
+		commands:     make(chan struct{}, 1),
+		pollInterval: pollInterval,
+		mode:         workerModeRunning,
+		concurrency:  concurrency,
+		active:       map[int64]struct{}{},
+	}
+
+	listener.Handle(BatchesAvailableChannel, func(string) { s.Wake() })
+	listener.Handle(WorkerCommandsChannel, func(workerID string) {
+		if workerID == s.workerID {
+			signal(s.commands)
+		}
+	})
+	// Notifications sent while disconnected are lost, look for batches and commands after every reconnect
+	listener.OnConnect(func() {
+		s.Wake()
+		signal(s.commands)
+	})
+
+	return s, nil
 }
 
-// Start initializes the worker and begins processing
+// workerLabels reads the configured labels and adds the hostname and version of the worker
+func workerLabels(config *config.Config) (models.Labels, error) {
+	labels, err := models.ParseLabels(config.WorkerLabels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WORKER_LABELS: %w", err)
+	}
+
+	if hostname, err := os.Hostname(); err == nil && len(labels["hostname"]) == 0 {
+		labels.Add("hostname", hostname)
+	}
+	if len(labels["version"]) == 0 {
+		labels.Add("version", config.WorkerVersion)
+	}
+	return labels, nil
+}
+
+// Wake interrupts the wait of an idle worker so it checks for batches immediately
+func (s *WorkerService) Wake() {
+	signal(s.wakeup)
+}
+
+// signal sends on a channel with a buffer of one without blocking, pending signals are merged
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default: // A signal is already pending
+	}
+}
+
+// Start initializes the worker and processes batches until a shutdown command was drained
 func (s *WorkerService) Start() {
-	log.Printf("Worker started with ID: %s", s.workerID)
+	log.Printf("Worker started with ID: %s and labels %v", s.workerID, s.labels)
 
 	// Register the worker in the database
-	worker, err := s.workerRepo.RegisterWorker(s.workerID)
-	if err != nil {
+	if _, err := s.workerRepo.RegisterWorker(s.workerID, s.concurrency, s.labels); err != nil {
 		log.Fatalf("Failed to register worker: %v", err)
 	}
 
 	// Start heartbeat updater in a separate goroutine
 	go s.startHeartbeat()
+	s.reportStatus("Started")
 
 	// Main processing loop
-	for {
-		// time.Sleep(5 * time.Second)
-
+	for s.waitForSlot() {
 		// Fetch and lock an available batch
 		batch, err := s.fetchAndLockBatch()
 		if err != nil {
 			log.Printf("Error fetching batch: %v", err)
 			time.Sleep(5 * time.Second) // Wait before retrying
 			continue
-		} else {
-			worker, _ = s.workerRepo.UpdateWorkerStatus(s.workerID, "processing", batch.ID)
 		}
 
-		// If no batch is available, wait and try again
+		// If no batch is available, wait until batches are announced
 		if batch == nil {
-			if worker.Status == "running" {
-				worker, _ = s.workerRepo.UpdateWorkerStatus(s.workerID, "idle", 0)
-			}
-
 			log.Println("No available batches. Waiting...")
-			time.Sleep(5 * time.Second)
+			s.waitForBatches()
 			continue
 		}
 
-		// Process the batch
-		log.Printf("Processing batch: %d (Action ID: %d)", batch.ID, batch.ActionID)
-		success, err := s.processBatch(batch)
+		s.mu.Lock()
+		s.active[batch.ID] = struct{}{}
+		s.mu.Unlock()
+		s.reportStatus(fmt.Sprintf("Claimed batch %d", batch.ID))
+
+		go func() {
+			s.runBatch(batch)
+
+			s.mu.Lock()
+			delete(s.active, batch.ID)
+			s.mu.Unlock()
+			s.reportStatus(fmt.Sprintf("Finished batch %d", batch.ID))
+			s.Wake()
+		}()
+	}
+
+	s.reportStatus("Shut down")
+	log.Printf("Worker %s shut down.", s.workerID)
+}
+
+// waitForSlot blocks until the worker may claim another batch, completing drain commands
+// once no batch is in progress. It returns false when a shutdown has been drained.
+func (s *WorkerService) waitForSlot() bool {
+	for {
+		s.mu.Lock()
+		mode, active := s.mode, len(s.active)
+		var drained []int64
+		if (mode == workerModeDraining || mode == workerModeShutdown) && active == 0 {
+			drained, s.drainCommands = s.drainCommands, nil
+		}
+		canClaim := mode == workerModeRunning && active < s.concurrency
+		s.mu.Unlock()
+
+		for _, commandID := range drained {
+			s.finishCommand(commandID, "completed", "No batch in progress")
+		}
+		if mode == workerModeShutdown && active == 0 {
+			return false
+		}
+		if canClaim {
+			return true
+		}
+
+		<-s.wakeup
+	}
+}
+
+// runBatch processes a claimed batch and stores its outcome
+func (s *WorkerService) runBatch(batch *models.Batch) {
+	log.Printf("Processing batch: %d (Action ID: %d)", batch.ID, batch.ActionID)
+	result, success, err := s.processBatch(batch)
+	if errors.Is(err, models.ErrBatchLockLost) {
+		// The batch was reclaimed after this worker went stale, its new owner reports the outcome
+		log.Printf("Abandoning batch %d: %v", batch.ID, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to process batch %d: %v", batch.ID, err)
+	}
+
+	// Store the counters before the status change so progress is complete once the batch finishes
+	if recordErr := s.batchRepo.RecordBatchResult(batch.ID, s.workerID, result); recordErr != nil {
+		if errors.Is(recordErr, models.ErrBatchLockLost) {
+			log.Printf("Abandoning batch %d: %v", batch.ID, recordErr)
+			return
+		}
+		log.Printf("Failed to record result of batch %d: %v", batch.ID, recordErr)
+	}
+
+	// Handle batch completion, cancellation or retry
+	if errors.Is(err, ErrActionCanceled) {
+		err = s.batchRepo.MarkBatchCanceled(batch.ID, s.workerID)
+		if errors.Is(err, models.ErrBatchLockLost) {
+			log.Printf("Abandoning batch %d: %v", batch.ID, err)
+		} else if err != nil {
+			log.Printf("Failed to mark batch %d as canceled: %v", batch.ID, err)
+		}
+	} else if success {
+		// Mark the batch as completed
+		err = s.batchRepo.MarkBatchCompleted(batch.ID, s.workerID)
+		if errors.Is(err, models.ErrBatchLockLost) {
+			log.Printf("Abandoning batch %d: %v", batch.ID, err)
+		} else if err != nil {
+			log.Printf("Failed to mark batch %d as completed: %v", batch.ID, err)
+		}
+	} else {
+		// Put the batch back for a retry from its checkpoint, or mark it stale after too many attempts
+		updatedBatch, err := s.batchRepo.RetryBatch(batch.ID, s.workerID)
 		if err != nil {
-			log.Printf("Failed to process batch %d: %v", batch.ID, err)
+			log.Printf("Failed to release batch %d for a retry: %v", batch.ID, err)
+			return
+		}
+		if updatedBatch.Status == "stale" {
+			log.Printf("Batch %d marked as stale after %d attempts", batch.ID, updatedBatch.TryCount)
 		}
+	}
+}
 
-		// Handle batch completion or retry
-		if success {
-			// Mark the batch as completed
-			err = s.batchRepo.MarkBatchCompleted(batch.ID)
-			if err != nil {
-				log.Printf("Failed to mark batch %d as completed: %v", batch.ID, err)
-			}
-		} else {
-			// Increment the batch try count and get the updated record
-			updatedBatch, err := s.batchRepo.IncrementBatchTryCount(batch.ID)
-			if err != nil {
-				log.Printf("Failed to increment try count for batch %d: %v", batch.ID, err)
-				continue
-			}
+// waitForBatches blocks until batches are announced or the fallback poll interval has passed
+func (s *WorkerService) waitForBatches() {
+	timer := time.NewTimer(s.pollInterval)
+	defer timer.Stop()
 
-			// Check if the batch should be marked as stale
-			if updatedBatch.TryCount > 5 {
-				err = s.batchRepo.MarkBatchAsStale(batch.ID)
-				if err != nil {
-					log.Printf("Failed to mark batch %d as stale: %v", batch.ID, err)
-				}
-			}
+	select {
+	case <-s.wakeup:
+	case <-timer.C:
+	}
+}
+
+// reportStatus stores the status derived from the mode and the batches in progress, failures are only logged.
+// The reason is recorded in the worker history when the status changes.
+func (s *WorkerService) reportStatus(reason string) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	s.mu.Lock()
+	var currentBatchID *int64
+	for batchID := range s.active {
+		if currentBatchID == nil || batchID > *currentBatchID {
+			id := batchID
+			currentBatchID = &id
 		}
+	}
+	status := workerStatus(s.mode, len(s.active))
+	s.mu.Unlock()
 
-		worker, _ = s.workerRepo.UpdateWorkerStatus(s.workerID, "idle", 0)
+	if _, err := s.workerRepo.TransitionWorker(s.workerID, status, currentBatchID, reason); err != nil {
+		log.Printf("Failed to update status of worker %s: %v", s.workerID, err)
 	}
 }
 
-// startHeartbeat periodically updates the worker's heartbeat
+// workerStatus maps a mode and the number of batches in progress to the status shown by the manager
+func workerStatus(mode string, active int) string {
+	switch {
+	case mode == workerModePaused:
+		return models.WorkerStatusPaused
+	case mode == workerModeShutdown && active == 0:
+		return models.WorkerStatusStopped
+	case mode == workerModeDraining && active == 0:
+		return models.WorkerStatusDrained
+	case mode != workerModeRunning:
+		return models.WorkerStatusDraining
+	case active > 0:
+		return models.WorkerStatusProcessing
+	default:
+		return models.WorkerStatusIdle
+	}
+}
+
+// startHeartbeat periodically updates the worker's heartbeat and applies pending commands
+// on every beat, or right away when a command notification arrives
 func (s *WorkerService) startHeartbeat() {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		err := s.workerRepo.UpdateHeartbeat(s.workerID)
-		if err != nil {
-			log.Printf("Failed to update heartbeat for worker %s: %v", s.workerID, err)
+	for {
+		select {
+		case <-ticker.C:
+			err := s.workerRepo.UpdateHeartbeat(s.workerID)
+			if err != nil {
+				log.Printf("Failed to update heartbeat for worker %s: %v", s.workerID, err)
+			}
+			// Restores the status if the manager marked the worker stale while it was unreachable
+			s.reportStatus("Heartbeat resumed")
+		case <-s.commands:
 		}
+
+		s.handleCommands()
 	}
 }
 
-// fetchAndLockBatch fetches and locks an available batch
+// fetchAndLockBatch fetches and locks an available batch the worker's labels allow
 func (s *WorkerService) fetchAndLockBatch() (*models.Batch, error) {
-	return s.batchRepo.LockNextBatch(s.workerID)
+	return s.batchRepo.LockNextBatch(s.workerID, s.labels)
 }
 
-// processBatch processes the records in the batch
-func (s *WorkerService) processBatch(batch *models.Batch) (bool, error) {
-	log.Printf("Processing batch: ID %d, ActionID %d\n", batch.ID, batch.ActionID)
-
-	// Fetch records in the batch
-	subscriptions, err := s.subscriptionRepo.FetchSubscriptionsForBatch(batch.StartIndex, batch.EndIndex)
+// processBatch processes the batch with the job type of its action.
+// It returns ErrActionCanceled when the action was canceled while the batch was processed.
+func (s *WorkerService) processBatch(batch *models.Batch) (models.BatchResult, bool, error) {
+	action, err := s.managerRepo.GetActionByID(batch.ActionID)
 	if err != nil {
-		return false, fmt.Errorf("failed to fetch subscriptions for batch %d: %w", batch.ID, err)
+		return models.BatchResult{}, false, fmt.Errorf("failed to fetch action %d for batch %d: %w", batch.ActionID, batch.ID, err)
 	}
 
-	ctx := context.TODO()
-
-	successCount := 0
-	failureCount := 0
-
-	// Prepare slices for batch updates
-	var activeSubscriptions []models.Subscription
-	var expiredSubscriptions []models.Subscription
+	job, err := s.jobRegistry.Get(action.Type)
+	if err != nil {
+		return models.BatchResult{}, false, err
+	}
 
-	// Process each subscription
-	for _, sub := range subscriptions {
-		// Skip if subscription is canceled
-		if sub.Status == "canceled" {
-			log.Printf("Skipping subscription ID %d: status is canceled", sub.ID)
-			continue
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		// Skip if subscription was updated in the last 30 minutes
-		if time.Since(sub.UpdatedAt) < 30*time.Minute {
-			log.Printf("Skipping subscription ID %d: updated within 30 minutes", sub.ID)
-			continue
-		}
+	// Cancel the job context as soon as the action gets canceled
+	canceled := make(chan struct{})
+	go s.watchAction(ctx, action.ID, func() {
+		close(canceled)
+		cancel()
+	})
 
-		// Check if the subscription is expired and not canceled
-		if time.Now().After(sub.ExpireAt) && sub.Status != "canceled" {
-			// Request the Store API to validate the receipt
-			result, err := s.storeApiService.ValidateReceipt(ctx, sub.Receipt)
-			if err != nil {
-				log.Printf("Failed to validate receipt for subscription ID %d: %v", sub.ID, err)
-				failureCount++
-				continue
-			}
+	result, success, err := job.ProcessBatch(ctx, action, batch)
 
-			// Process the Store API result
-			status, ok := result["status"].(bool)
-			if !ok {
-				log.Printf("Invalid status received for subscription ID %d", sub.ID)
-				failureCount++
-				continue
-			}
+	select {
+	case <-canceled:
+		return result, false, ErrActionCanceled
+	default:
+		return result, success, err
+	}
+}
 
-			expireDate, ok := result["expire_date"].(string)
-			if !ok {
-				log.Printf("Invalid expireDate received for subscription ID %d", sub.ID)
-				failureCount++
-				continue
-			}
+// watchAction polls the status of an action until ctx is done and calls onCanceled once it is canceled
+func (s *WorkerService) watchAction(ctx context.Context, actionID int64, onCanceled func()) {
+	ticker := time.NewTicker(actionWatchInterval)
+	defer ticker.Stop()
 
-			// Parse expireDate
-			expireTime, err := time.Parse("2006-01-02 15:04:05", expireDate)
+	for {
+		select {
+		case <-ticker.C:
+			action, err := s.managerRepo.GetActionByID(actionID)
 			if err != nil {
-				log.Printf("Failed to parse expireDate for subscription ID %d: %v", sub.ID, err)
-				failureCount++
+				log.Printf("Failed to check status of action %d: %v", actionID, err)
 				continue
 			}
-
-			// Add to the appropriate batch
-			if status {
-				sub.ExpireAt = expireTime
-				sub.Status = "active"
-				activeSubscriptions = append(activeSubscriptions, sub)
-				successCount++
-			} else {
-				sub.Status = "expired"
-				expiredSubscriptions = append(expiredSubscriptions, sub)
-				successCount++
+			if action.Status == "canceled" {
+				log.Printf("Action %d was canceled, stopping batch at the next safe point", actionID)
+				onCanceled()
+				return
 			}
+		case <-ctx.Done():
+			return
 		}
 	}
-
-	// Perform batch updates
-	if len(activeSubscriptions) > 0 {
-		if err := s.subscriptionRepo.BulkUpdateSubscriptions(activeSubscriptions); err != nil {
-			log.Printf("Failed to update active subscriptions in batch: %v", err)
-		}
-	}
-
-	if len(expiredSubscriptions) > 0 {
-		if err := s.subscriptionRepo.BulkUpdateSubscriptions(expiredSubscriptions); err != nil {
-			log.Printf("Failed to update expired subscriptions in batch: %v", err)
-		}
-	}
-
-	log.Printf("Completed processing batch: ID %d, Success: %d, Failures: %d\n", batch.ID, successCount, failureCount)
-
-	// If all subscriptions were processed successfully, return true
-	return failureCount == 0, nil
 }
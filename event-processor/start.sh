@@ -1,4 +1,5 @@
 #!/bin/sh
 
 ./worker_manager &
+./outbox_relay &
 ./worker
\ No newline at end of file
@@ -0,0 +1,85 @@
+package workermanager
+
+import (
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestAuthService_KeysAndSessions(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, auditLogRepo *models.AuditLogRepository, revokedSessionRepo *models.RevokedSessionRepository) {
+		// Step 1: Create the service from configured keys
+		cfg := &config.Config{
+			ApiKeys:       "alice:admin:alice-key, bob:viewer:bob-key",
+			SessionSecret: "test-secret",
+			SessionTTL:    60,
+			ManagerHost:   "localhost",
+			ManagerPort:   9090,
+		}
+		authService, err := services.NewAuthService(cfg, auditLogRepo, revokedSessionRepo)
+		assert.NoError(t, err, "NewAuthService should not return an error")
+
+		// Step 2: API keys resolve to their identity and role
+		alice := authService.Authenticate("alice-key")
+		assert.NotNil(t, alice, "A configured key should authenticate")
+		assert.Equal(t, "alice", alice.Name, "The identity should carry the key name")
+		assert.True(t, alice.HasRole(services.RoleOperator), "Admins should have the operator role")
+
+		bob := authService.Authenticate("bob-key")
+		assert.False(t, bob.HasRole(services.RoleOperator), "Viewers should not have the operator role")
+		assert.Nil(t, authService.Authenticate("unknown-key"), "Unknown keys should not authenticate")
+
+		// Step 3: Sessions round trip and reject tampering
+		token, _, err := authService.IssueSession(alice)
+		assert.NoError(t, err, "IssueSession should not return an error")
+		session, err := authService.VerifySession(token)
+		assert.NoError(t, err, "A fresh session should verify")
+		assert.Equal(t, "session", session.AuthMethod, "The identity should come from the session")
+
+		_, err = authService.VerifySession(token + "x")
+		assert.ErrorIs(t, err, services.ErrInvalidSession, "A tampered session should be rejected")
+
+		// Step 4: Sessions of removed keys are rejected
+		cfg.ApiKeys = "bob:viewer:bob-key"
+		restricted, err := services.NewAuthService(cfg, auditLogRepo, revokedSessionRepo)
+		assert.NoError(t, err, "NewAuthService should not return an error")
+		_, err = restricted.VerifySession(token)
+		assert.ErrorIs(t, err, services.ErrInvalidSession, "A session of a removed key should be rejected")
+
+		// Step 5: Logging out revokes only that session
+		other, _, err := authService.IssueSession(alice)
+		assert.NoError(t, err, "IssueSession should not return an error")
+		err = authService.RevokeSession(token)
+		assert.NoError(t, err, "RevokeSession should not return an error")
+		_, err = authService.VerifySession(token)
+		assert.ErrorIs(t, err, services.ErrInvalidSession, "A revoked session should be rejected")
+		_, err = authService.VerifySession(other)
+		assert.NoError(t, err, "Other sessions of the same key should stay valid")
+
+		// Step 6: Invalid roles are rejected at startup
+		cfg.ApiKeys = "carol:root:carol-key"
+		_, err = services.NewAuthService(cfg, auditLogRepo, revokedSessionRepo)
+		assert.Error(t, err, "An unknown role should be rejected")
+
+		cfg.ApiKeys = "carol:admin:REPLACE_ME"
+		_, err = services.NewAuthService(cfg, auditLogRepo, revokedSessionRepo)
+		assert.Error(t, err, "A placeholder key should be rejected")
+
+		// Step 7: Audit records are stored
+		authService.RecordAudit(&models.AuditLog{Actor: "alice", Role: "admin", AuthMethod: "api_key", Method: "POST", Path: "/trigger", StatusCode: 200})
+		entries, total, err := authService.ListAuditLogs("alice", 10, 0)
+		assert.NoError(t, err, "ListAuditLogs should not return an error")
+		assert.Equal(t, int64(1), total, "The audit record should be stored")
+		assert.Equal(t, "/trigger", entries[0].Path, "The audit record should keep the path")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke AuthService: %v", err)
+	}
+}
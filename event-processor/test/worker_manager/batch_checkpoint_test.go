@@ -0,0 +1,117 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestBatchRepository_Checkpoint(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, batchRepo *models.BatchRepository, workerRepo *models.WorkerRepository, subscriptionRepo *models.SubscriptionRepository) {
+		// Step 1: Seed expired subscriptions and a batch locked by a running worker
+		subscriptions := []models.Subscription{
+			{UID: uuid.New().String(), AppID: 1, Receipt: "checkpoint-1", Status: "expired", ExpireAt: time.Now().AddDate(0, 0, -1)},
+			{UID: uuid.New().String(), AppID: 1, Receipt: "checkpoint-2", Status: "expired", ExpireAt: time.Now().AddDate(0, 0, -1)},
+			{UID: uuid.New().String(), AppID: 1, Receipt: "checkpoint-3", Status: "expired", ExpireAt: time.Now().AddDate(0, 0, -1)},
+		}
+		err := db.Create(&subscriptions).Error
+		assert.NoError(t, err, "Failed to seed subscriptions")
+
+		workerID := uuid.New().String()
+		_, err = workerRepo.RegisterWorker(workerID, 1, nil)
+		assert.NoError(t, err, "Failed to register worker")
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusProcessing, nil, "Claimed batch")
+		assert.NoError(t, err, "Failed to start worker")
+
+		action := models.ManagerAction{Type: "checkpoint_test", Status: "running", TriggeredAt: time.Now()}
+		err = db.Create(&action).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+		batch := models.Batch{ActionID: action.ID, StartIndex: 1, EndIndex: 3, Status: "processing", LockedBy: &workerID}
+		err = db.Create(&batch).Error
+		assert.NoError(t, err, "Failed to seed batch")
+
+		// Step 2: A checkpoint writes the updates so far together with the progress
+		read, err := subscriptionRepo.GetSubscriptionByID(subscriptions[0].ID)
+		assert.NoError(t, err, "Failed to read subscription")
+		renewed := *read
+		renewed.Status = "active"
+		renewed.ExpireAt = time.Now().AddDate(0, 1, 0)
+		checkpoint := models.BatchCheckpoint{
+			LastKey:  subscriptions[1].ID,
+			Position: 2,
+			Result:   models.BatchResult{ProcessedCount: 2, RenewedCount: 1, SkippedCount: 1},
+		}
+		saved, err := batchRepo.SaveCheckpoint(batch.ID, workerID, checkpoint, models.CheckpointWrites{ActionID: action.ID, Updates: []models.Subscription{renewed}})
+		assert.NoError(t, err, "SaveCheckpoint should not return an error")
+		assert.Equal(t, int64(0), saved.Result.ConflictCount, "An unchanged subscription should not conflict")
+
+		stored, err := subscriptionRepo.GetSubscriptionByID(subscriptions[0].ID)
+		assert.NoError(t, err, "Failed to fetch subscription")
+		assert.Equal(t, models.SubscriptionStatusActive, stored.Status, "The update should be written with the checkpoint")
+
+		// Step 3: Only the worker holding the lock can save checkpoints
+		_, err = batchRepo.SaveCheckpoint(batch.ID, uuid.New().String(), checkpoint, models.CheckpointWrites{ActionID: action.ID})
+		assert.ErrorIs(t, err, models.ErrBatchLockLost, "Other workers should not write checkpoints")
+		err = batchRepo.RecordBatchResult(batch.ID, uuid.New().String(), checkpoint.Result)
+		assert.ErrorIs(t, err, models.ErrBatchLockLost, "Other workers should not record results")
+		err = batchRepo.MarkBatchCompleted(batch.ID, uuid.New().String())
+		assert.ErrorIs(t, err, models.ErrBatchLockLost, "Other workers should not complete the batch")
+		err = batchRepo.MarkBatchCanceled(batch.ID, uuid.New().String())
+		assert.ErrorIs(t, err, models.ErrBatchLockLost, "Other workers should not cancel the batch")
+
+		// Step 4: A failed batch goes back to pending and keeps its checkpoint
+		retried, err := batchRepo.RetryBatch(batch.ID, workerID)
+		assert.NoError(t, err, "RetryBatch should not return an error")
+		assert.Equal(t, "pending", retried.Status, "The batch should be pending again")
+		assert.Equal(t, 1, retried.TryCount, "The attempt should be counted")
+		assert.Nil(t, retried.LockedBy, "The batch should be unlocked")
+
+		reloaded, err := batchRepo.GetBatchByID(batch.ID)
+		assert.NoError(t, err, "Failed to fetch batch")
+		if assert.NotNil(t, reloaded.Checkpoint, "The checkpoint should be kept") {
+			assert.Equal(t, checkpoint.LastKey, reloaded.Checkpoint.LastKey, "The last key should be kept")
+			assert.Equal(t, checkpoint.Position, reloaded.Checkpoint.Position, "The position should be kept")
+			assert.Equal(t, int64(1), reloaded.Checkpoint.Result.RenewedCount, "The counters should be kept")
+		}
+
+		// Step 5: The next attempt continues after the last key
+		filter := models.SubscriptionFilter{SubscriptionIDs: []int64{subscriptions[0].ID, subscriptions[1].ID, subscriptions[2].ID}, Force: true}
+		remaining, err := subscriptionRepo.FetchSubscriptionsAfterKey(filter, reloaded.Checkpoint.LastKey, reloaded.EndKey, reloaded.Size()-reloaded.Checkpoint.Position)
+		assert.NoError(t, err, "FetchSubscriptionsAfterKey should not return an error")
+		if assert.Len(t, remaining, 1, "Only the item after the checkpoint should be left") {
+			assert.Equal(t, subscriptions[2].ID, remaining[0].ID, "The remaining item should follow the last key")
+		}
+
+		// Step 6: Batches of a worker that went stale are reclaimed
+		err = db.Model(&models.Batch{}).Where("id = ?", batch.ID).Updates(map[string]interface{}{"status": "processing", "locked_by": workerID}).Error
+		assert.NoError(t, err, "Failed to lock batch again")
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusStale, nil, "No heartbeat")
+		assert.NoError(t, err, "Failed to mark worker stale")
+
+		released, err := batchRepo.ReleaseOrphanedBatches()
+		assert.NoError(t, err, "ReleaseOrphanedBatches should not return an error")
+		assert.GreaterOrEqual(t, released, int64(1), "The batch should be released")
+
+		reloaded, err = batchRepo.GetBatchByID(batch.ID)
+		assert.NoError(t, err, "Failed to fetch batch")
+		assert.Equal(t, "pending", reloaded.Status, "The reclaimed batch should be pending")
+		assert.Equal(t, 2, reloaded.TryCount, "The reclaim should count as an attempt")
+
+		// Step 7: The worker that lost the batch can no longer finish it
+		err = batchRepo.MarkBatchCompleted(batch.ID, workerID)
+		assert.ErrorIs(t, err, models.ErrBatchLockLost, "A reclaimed batch should not be completed by its former worker")
+		reloaded, err = batchRepo.GetBatchByID(batch.ID)
+		assert.NoError(t, err, "Failed to fetch batch")
+		assert.Equal(t, "pending", reloaded.Status, "The reclaimed batch should stay pending")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke BatchRepository: %v", err)
+	}
+}
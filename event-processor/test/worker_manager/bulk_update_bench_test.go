@@ -0,0 +1,112 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// caseParamsPerRow is the number of parameters caseBulkUpdate binds per subscription
+const caseParamsPerRow = 5
+
+// maxQueryParams is the Postgres limit of bind parameters in one statement
+const maxQueryParams = 65535
+
+// caseBulkUpdate is the bulk update used before the COPY based one: a single UPDATE with a CASE
+// expression per column and an id list, binding five parameters per subscription plus updated_at.
+// It does not compare updated_at, so it is kept here only to compare against the COPY based update.
+func caseBulkUpdate(db *gorm.DB, subscriptions []models.Subscription) error {
+	var query strings.Builder
+	args := make([]interface{}, 0, len(subscriptions)*caseParamsPerRow+1)
+
+	query.WriteString("UPDATE subscriptions SET status = CASE id ")
+	for _, sub := range subscriptions {
+		query.WriteString("WHEN ? THEN ? ")
+		args = append(args, sub.ID, sub.Status)
+	}
+	query.WriteString("END, expire_at = CASE id ")
+	for _, sub := range subscriptions {
+		query.WriteString("WHEN ? THEN ? ")
+		args = append(args, sub.ID, sub.ExpireAt)
+	}
+	query.WriteString("END, updated_at = ? WHERE id IN (")
+	args = append(args, time.Now().UTC())
+	for i, sub := range subscriptions {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("?")
+		args = append(args, sub.ID)
+	}
+	query.WriteString(");")
+
+	return db.Exec(query.String(), args...).Error
+}
+
+func BenchmarkBulkUpdateSubscriptions(b *testing.B) {
+	ResetDatabase(b)
+
+	err := Container.Invoke(func(db *gorm.DB, subscriptionRepo *models.SubscriptionRepository) {
+		for _, size := range []int{100, 1000, 10000, 20000} {
+			// Step 1: Seed the subscriptions updated by every iteration
+			subscriptions := make([]models.Subscription, size)
+			for i := range subscriptions {
+				subscriptions[i] = models.Subscription{UID: uuid.New().String(), AppID: i%4 + 1, Receipt: fmt.Sprintf("bench-%d", i), Status: models.SubscriptionStatusExpired, ExpireAt: time.Now()}
+			}
+			if err := db.CreateInBatches(&subscriptions, 1000).Error; err != nil {
+				b.Fatalf("Failed to seed subscriptions: %v", err)
+			}
+			ids := make([]int64, size)
+			for i, sub := range subscriptions {
+				ids[i] = sub.ID
+			}
+
+			// Step 2: Every iteration renews subscriptions read right before, so no update conflicts
+			prepare := func(b *testing.B) []models.Subscription {
+				b.StopTimer()
+				defer b.StartTimer()
+				read, err := subscriptionRepo.GetSubscriptionsByIDs(ids)
+				if err != nil {
+					b.Fatalf("Failed to read subscriptions: %v", err)
+				}
+				for i := range read {
+					read[i].Status = models.SubscriptionStatusRenewed
+					read[i].ExpireAt = time.Now().AddDate(0, 1, 0)
+				}
+				return read
+			}
+
+			b.Run(fmt.Sprintf("case/%d", size), func(b *testing.B) {
+				if size*caseParamsPerRow+1 > maxQueryParams {
+					b.Skipf("%d subscriptions exceed the parameter limit of the CASE update", size)
+				}
+				for i := 0; i < b.N; i++ {
+					if err := caseBulkUpdate(db, prepare(b)); err != nil {
+						b.Fatalf("Failed to update subscriptions: %v", err)
+					}
+				}
+			})
+
+			b.Run(fmt.Sprintf("copy/%d", size), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					conflicts, err := subscriptionRepo.BulkUpdateSubscriptions(prepare(b), nil)
+					if err != nil {
+						b.Fatalf("Failed to update subscriptions: %v", err)
+					}
+					if len(conflicts) > 0 {
+						b.Fatalf("Unexpected conflicts: %d", len(conflicts))
+					}
+				}
+			})
+		}
+	})
+
+	if err != nil {
+		b.Fatalf("Failed to invoke SubscriptionRepository: %v", err)
+	}
+}
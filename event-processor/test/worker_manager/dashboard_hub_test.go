@@ -0,0 +1,68 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestDashboardHub_Deltas(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, hub *services.DashboardHub) {
+		// Step 1: Seed two running actions, of different types as only one action of a type can be active
+		actions := []models.ManagerAction{
+			{Type: "dashboard_hub_test", Status: "running", TriggeredAt: time.Now()},
+			{Type: "dashboard_hub_test_other", Status: "running", TriggeredAt: time.Now()},
+		}
+		err := db.Create(&actions).Error
+		assert.NoError(t, err, "Failed to seed manager actions")
+
+		// Step 2: Subscribers receive a snapshot first
+		all, err := hub.Subscribe(0)
+		assert.NoError(t, err, "Subscribe should not return an error")
+		defer hub.Unsubscribe(all)
+		other, err := hub.Subscribe(actions[1].ID)
+		assert.NoError(t, err, "Subscribe should not return an error")
+		defer hub.Unsubscribe(other)
+
+		snapshot := <-all.Messages()
+		assert.Equal(t, "snapshot", snapshot.Type, "The first message should be a snapshot")
+		snapshot = <-other.Messages()
+		assert.Equal(t, "snapshot", snapshot.Type, "The first message should be a snapshot")
+		assert.Len(t, snapshot.Actions, 1, "The snapshot should only contain the subscribed action")
+
+		// Step 3: A batch of the first action changes
+		batch := models.Batch{ActionID: actions[0].ID, StartIndex: 0, EndIndex: 10, Status: "processing"}
+		err = db.Create(&batch).Error
+		assert.NoError(t, err, "Failed to seed batch")
+
+		hub.HandleNotification(fmt.Sprintf(`{"table":"batches","id":%d}`, batch.ID))
+		err = hub.Flush()
+		assert.NoError(t, err, "Flush should not return an error")
+
+		// Step 4: Only the subscriber of all actions receives the batch
+		select {
+		case delta := <-all.Messages():
+			assert.Equal(t, "delta", delta.Type, "A delta should be sent")
+			assert.Len(t, delta.Batches, 1, "The delta should contain the changed batch")
+			assert.Equal(t, batch.ID, delta.Batches[0].ID, "The changed batch should be sent")
+		default:
+			t.Fatal("The subscriber of all actions should receive a delta")
+		}
+		select {
+		case delta := <-other.Messages():
+			t.Fatalf("The subscriber of another action should not receive a delta, got %+v", delta)
+		default:
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke DashboardHub: %v", err)
+	}
+}
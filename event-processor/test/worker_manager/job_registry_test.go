@@ -0,0 +1,84 @@
+package workermanager
+
+import (
+	"context"
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// registryTestJob is a job type with a fixed number of items, split into one batch per item
+type registryTestJob struct {
+	items int64
+}
+
+func (j *registryTestJob) Type() string {
+	return "registry_test"
+}
+
+func (j *registryTestJob) NormalizeParams(params models.JSON) (models.JSON, error) {
+	return params, nil
+}
+
+func (j *registryTestJob) CountItems(ctx context.Context, params models.JSON) (int64, error) {
+	return j.items, nil
+}
+
+func (j *registryTestJob) SplitBatches(ctx context.Context, params models.JSON, total int64, batchSize int64) ([]models.BatchRange, error) {
+	return models.SplitRange(j.items, 1), nil
+}
+
+func (j *registryTestJob) ProcessBatch(ctx context.Context, action *models.ManagerAction, batch *models.Batch) (models.BatchResult, bool, error) {
+	return models.BatchResult{ProcessedCount: batch.EndIndex - batch.StartIndex + 1}, true, nil
+}
+
+func TestJobRegistry_TriggerAction(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, jobRegistry *services.JobRegistry, workerManagerService *services.WorkerManagerService) {
+		// Step 1: The built-in job types are registered, unknown ones are rejected
+		assert.Equal(t, []string{services.JobTypeReconciliation, services.JobTypeRenewalSweep}, jobRegistry.Types(), "The built-in job types should be registered")
+		_, err := jobRegistry.Get("registry_test")
+		assert.Error(t, err, "Unknown job types should not be found")
+		_, err = workerManagerService.TriggerAction("registry_test", nil, nil)
+		assert.ErrorIs(t, err, services.ErrInvalidJobParams, "Triggering an unknown job type should be rejected")
+
+		// Step 2: A registered job type is triggered with the batches it splits
+		job := &registryTestJob{items: 3}
+		jobRegistry.Register(job)
+		registered, err := jobRegistry.Get("registry_test")
+		assert.NoError(t, err, "The registered job type should be found")
+		assert.Same(t, job, registered, "The registered job should be returned")
+
+		action, err := workerManagerService.TriggerAction("registry_test", nil, nil)
+		assert.NoError(t, err, "TriggerAction should not return an error")
+		if assert.NotNil(t, action, "An action should be created") {
+			assert.Equal(t, "registry_test", action.Type, "The action should run the job type")
+			assert.Equal(t, int64(3), action.BatchCount, "The action should count the batches of the job")
+
+			var batchCount int64
+			err = db.Model(&models.Batch{}).Where("action_id = ?", action.ID).Count(&batchCount).Error
+			assert.NoError(t, err, "Failed to count batches")
+			assert.Equal(t, int64(3), batchCount, "The batches of the job should be created")
+		}
+
+		// Step 3: An action whose batches cannot be created is not created either
+		err = db.Model(&models.ManagerAction{}).Where("type = ?", "registry_test").Update("status", "completed").Error
+		assert.NoError(t, err, "Failed to complete the action")
+		job.items = 70000 // More batch parameters than a single insert can bind
+
+		_, err = workerManagerService.TriggerAction("registry_test", nil, nil)
+		assert.Error(t, err, "TriggerAction should fail when the batches cannot be created")
+		var actionCount int64
+		err = db.Model(&models.ManagerAction{}).Where("type = ?", "registry_test").Count(&actionCount).Error
+		assert.NoError(t, err, "Failed to count actions")
+		assert.Equal(t, int64(1), actionCount, "The failed action should be rolled back with its batches")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke JobRegistry: %v", err)
+	}
+}
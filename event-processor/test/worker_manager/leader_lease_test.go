@@ -0,0 +1,124 @@
+package workermanager
+
+import (
+	"context"
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestLeaderLeaseRepository_TryAcquire(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, leaseRepo *models.LeaderLeaseRepository) {
+		// Step 1: The first instance acquires the lease and renews it
+		leader, follower := uuid.New().String(), uuid.New().String()
+		acquired, err := leaseRepo.TryAcquire("lease_test", leader, time.Minute)
+		assert.NoError(t, err, "TryAcquire should not return an error")
+		assert.True(t, acquired, "A free lease should be acquired")
+
+		lease, err := leaseRepo.GetLease("lease_test")
+		assert.NoError(t, err, "GetLease should not return an error")
+		acquiredAt := lease.AcquiredAt
+
+		acquired, err = leaseRepo.TryAcquire("lease_test", leader, time.Minute)
+		assert.NoError(t, err, "TryAcquire should not return an error")
+		assert.True(t, acquired, "The holder should renew its lease")
+		lease, err = leaseRepo.GetLease("lease_test")
+		assert.NoError(t, err, "GetLease should not return an error")
+		assert.True(t, acquiredAt.Equal(lease.AcquiredAt), "Renewals should keep the acquisition time")
+
+		// Step 2: Other instances cannot take over a lease that has not expired
+		acquired, err = leaseRepo.TryAcquire("lease_test", follower, time.Minute)
+		assert.NoError(t, err, "TryAcquire should not return an error")
+		assert.False(t, acquired, "A held lease should not be taken over")
+		lease, err = leaseRepo.GetLease("lease_test")
+		assert.NoError(t, err, "GetLease should not return an error")
+		assert.Equal(t, leader, lease.HolderID, "The lease should stay with its holder")
+
+		// Step 3: An expired lease is taken over
+		err = db.Model(&models.LeaderLease{}).Where("name = ?", "lease_test").Update("expires_at", time.Now().Add(-time.Second)).Error
+		assert.NoError(t, err, "Failed to expire the lease")
+		acquired, err = leaseRepo.TryAcquire("lease_test", follower, time.Minute)
+		assert.NoError(t, err, "TryAcquire should not return an error")
+		assert.True(t, acquired, "An expired lease should be taken over")
+
+		acquired, err = leaseRepo.TryAcquire("lease_test", leader, time.Minute)
+		assert.NoError(t, err, "TryAcquire should not return an error")
+		assert.False(t, acquired, "The deposed holder should not get the lease back")
+
+		// Step 4: Only the holder releases the lease
+		err = leaseRepo.Release("lease_test", leader)
+		assert.NoError(t, err, "Release should not return an error")
+		lease, err = leaseRepo.GetLease("lease_test")
+		assert.NoError(t, err, "GetLease should not return an error")
+		assert.Equal(t, follower, lease.HolderID, "Others should not release the lease")
+
+		err = leaseRepo.Release("lease_test", follower)
+		assert.NoError(t, err, "Release should not return an error")
+		lease, err = leaseRepo.GetLease("lease_test")
+		assert.NoError(t, err, "GetLease should not return an error")
+		assert.Nil(t, lease, "The holder should release the lease")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke LeaderLeaseRepository: %v", err)
+	}
+}
+
+func TestLeaderService_Run(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(leaseRepo *models.LeaderLeaseRepository) {
+		// Step 1: Of two instances only one is elected
+		first := services.NewLeaderService(&config.Config{LeaderLeaseTTL: 1}, leaseRepo)
+		second := services.NewLeaderService(&config.Config{LeaderLeaseTTL: 1}, leaseRepo)
+
+		firstCtx, stopFirst := context.WithCancel(context.Background())
+		defer stopFirst()
+		elected := make(chan context.Context, 1)
+		firstDone := make(chan struct{})
+		go func() {
+			first.Run(firstCtx, func(ctx context.Context) { elected <- ctx })
+			close(firstDone)
+		}()
+
+		var leaderCtx context.Context
+		select {
+		case leaderCtx = <-elected:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The first instance should be elected")
+		}
+
+		secondCtx, stopSecond := context.WithCancel(context.Background())
+		defer stopSecond()
+		go second.Run(secondCtx, func(ctx context.Context) { elected <- ctx })
+
+		time.Sleep(time.Second)
+		assert.True(t, first.IsLeader(), "The first instance should keep leading")
+		assert.False(t, second.IsLeader(), "The second instance should follow while the lease is held")
+
+		// Step 2: A stopping leader releases the lease and the follower takes over
+		stopFirst()
+		<-firstDone
+		assert.Error(t, leaderCtx.Err(), "The leader context should be canceled when stepping down")
+		assert.False(t, first.IsLeader(), "The stopped instance should not lead")
+
+		select {
+		case <-elected:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The second instance should be elected")
+		}
+		assert.True(t, second.IsLeader(), "The follower should take over")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke LeaderService: %v", err)
+	}
+}
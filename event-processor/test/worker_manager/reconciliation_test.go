@@ -0,0 +1,148 @@
+package workermanager
+
+import (
+	"context"
+	"encoding/json"
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestReconciliationJob_ProcessBatch(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, subscriptionRepo *models.SubscriptionRepository, batchRepo *models.BatchRepository, workerRepo *models.WorkerRepository, reconciliationRepo *models.ReconciliationRepository) {
+		// Step 1: Seed subscriptions with access for one app and a store that disagrees with some of them
+		expireAt := time.Now().UTC().AddDate(0, 0, 5).Truncate(time.Second)
+		movedTo := expireAt.AddDate(0, 0, 10)
+		subscriptions := []models.Subscription{
+			{UID: uuid.New().String(), AppID: 4, Receipt: "revoked", Status: models.SubscriptionStatusActive, ExpireAt: expireAt},
+			{UID: uuid.New().String(), AppID: 4, Receipt: "moved", Status: models.SubscriptionStatusActive, ExpireAt: expireAt},
+			{UID: uuid.New().String(), AppID: 4, Receipt: "match", Status: models.SubscriptionStatusActive, ExpireAt: expireAt},
+			{UID: uuid.New().String(), AppID: 4, Receipt: "unknown", Status: models.SubscriptionStatusActive, ExpireAt: expireAt},
+			{UID: uuid.New().String(), AppID: 4, Receipt: "expired", Status: models.SubscriptionStatusExpired, ExpireAt: expireAt.AddDate(0, -1, 0)},
+		}
+		err := db.Create(&subscriptions).Error
+		assert.NoError(t, err, "Failed to seed subscriptions")
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var request struct {
+				Receipt string `json:"receipt"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&request)
+
+			switch request.Receipt {
+			case "revoked":
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": false, "state": "revoked", "expire_date": ""})
+			case "moved":
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": true, "expire_date": movedTo.Format("2006-01-02 15:04:05")})
+			case "match":
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": true, "expire_date": expireAt.Format("2006-01-02 15:04:05")})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer srv.Close()
+
+		storeApiService, err := services.NewStoreApiService(&config.Config{StoreApiHost: srv.URL})
+		assert.NoError(t, err, "Failed to create store API service")
+		job := services.NewReconciliationJob(&config.Config{}, subscriptionRepo, batchRepo, storeApiService)
+
+		// Step 2: Only subscriptions with access are reconciled, params are validated
+		params, err := job.NormalizeParams(models.JSON(`{"app_ids": [4], "auto_fix": ["status_mismatch"]}`))
+		assert.NoError(t, err, "NormalizeParams should not return an error")
+		count, err := job.CountItems(context.Background(), params)
+		assert.NoError(t, err, "CountItems should not return an error")
+		assert.Equal(t, int64(4), count, "Only subscriptions with access should be counted")
+
+		_, err = job.NormalizeParams(models.JSON(`{"auto_fix": ["everything"]}`))
+		assert.ErrorIs(t, err, services.ErrInvalidJobParams, "Unknown discrepancy kinds should be rejected")
+		_, err = job.NormalizeParams(models.JSON(`{"sample_every": 4, "sample_offset": 4}`))
+		assert.ErrorIs(t, err, services.ErrInvalidJobParams, "Offsets outside the sample should be rejected")
+
+		// Step 3: Process the subscriptions in a batch locked by a running worker
+		workerID := uuid.New().String()
+		_, err = workerRepo.RegisterWorker(workerID, 1, nil)
+		assert.NoError(t, err, "Failed to register worker")
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusProcessing, nil, "Claimed batch")
+		assert.NoError(t, err, "Failed to start worker")
+
+		action := models.ManagerAction{Type: services.JobTypeReconciliation, Params: params, Status: "running", TriggeredAt: time.Now()}
+		err = db.Create(&action).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+		batch := models.Batch{ActionID: action.ID, StartIndex: 1, EndIndex: count, Status: "processing", LockedBy: &workerID}
+		err = db.Create(&batch).Error
+		assert.NoError(t, err, "Failed to seed batch")
+
+		result, done, err := job.ProcessBatch(context.Background(), &action, &batch)
+		assert.NoError(t, err, "ProcessBatch should not return an error")
+		assert.True(t, done, "The batch should be done")
+		assert.Equal(t, int64(4), result.ProcessedCount, "Every subscription should be processed")
+		assert.Equal(t, int64(1), result.ExpiredCount, "The revoked subscription should be fixed")
+		assert.Equal(t, int64(2), result.SkippedCount, "Discrepancies outside the policy should only be reported")
+
+		// Step 4: Every discrepancy is reported, only the policy's kinds are fixed
+		discrepancies, total, err := reconciliationRepo.ListDiscrepancies(models.DiscrepancyFilter{ActionID: action.ID, Limit: 10})
+		assert.NoError(t, err, "ListDiscrepancies should not return an error")
+		assert.Equal(t, int64(3), total, "The matching subscription should not be reported")
+		kinds := map[int64]models.ReconciliationDiscrepancy{}
+		for _, discrepancy := range discrepancies {
+			kinds[discrepancy.SubscriptionID] = discrepancy
+		}
+		assert.Equal(t, models.DiscrepancyStatusMismatch, kinds[subscriptions[0].ID].Kind, "The revoked receipt should be a status mismatch")
+		assert.True(t, kinds[subscriptions[0].ID].Fixed, "Status mismatches should be fixed")
+		assert.Equal(t, models.DiscrepancyExpiryMismatch, kinds[subscriptions[1].ID].Kind, "The moved expiry should be an expiry mismatch")
+		assert.False(t, kinds[subscriptions[1].ID].Fixed, "Expiry mismatches should only be reported")
+		if assert.NotNil(t, kinds[subscriptions[1].ID].StoreExpireAt, "The store's expiry should be reported") {
+			assert.True(t, movedTo.Equal(*kinds[subscriptions[1].ID].StoreExpireAt), "The store's expiry should be reported")
+		}
+		assert.Equal(t, models.DiscrepancyUnknownReceipt, kinds[subscriptions[3].ID].Kind, "The unknown receipt should be reported")
+
+		fixed := false
+		_, total, err = reconciliationRepo.ListDiscrepancies(models.DiscrepancyFilter{ActionID: action.ID, Fixed: &fixed, Limit: 10})
+		assert.NoError(t, err, "ListDiscrepancies should not return an error")
+		assert.Equal(t, int64(2), total, "Reported discrepancies should be filtered")
+
+		// Step 5: Fixes are written with a transition of the reconciliation
+		revoked, err := subscriptionRepo.GetSubscriptionByID(subscriptions[0].ID)
+		assert.NoError(t, err, "Failed to fetch subscription")
+		assert.Equal(t, models.SubscriptionStatusRevoked, revoked.Status, "The subscription should take the store's status")
+		assert.False(t, revoked.ExpireAt.After(time.Now()), "Access should end with the revocation")
+
+		moved, err := subscriptionRepo.GetSubscriptionByID(subscriptions[1].ID)
+		assert.NoError(t, err, "Failed to fetch subscription")
+		assert.True(t, expireAt.Equal(moved.ExpireAt), "Reported discrepancies should not be changed")
+
+		var history []models.SubscriptionHistory
+		err = db.Where("subscription_id IN ?", []int64{subscriptions[0].ID, subscriptions[1].ID, subscriptions[3].ID}).Find(&history).Error
+		assert.NoError(t, err, "Failed to fetch history")
+		if assert.Len(t, history, 1, "Only the fix should be recorded") {
+			assert.Equal(t, models.SubscriptionEventSourceReconciliation, history[0].Source, "The fix should be attributed to the reconciliation")
+			assert.Equal(t, models.SubscriptionStatusRevoked, history[0].ToStatus, "The fix should be recorded")
+		}
+
+		// Step 6: Sampling reconciles every n-th subscription by ID
+		offset := subscriptions[2].ID % 2
+		var expected int64
+		for _, sub := range subscriptions[1:4] {
+			if sub.ID%2 == offset {
+				expected++
+			}
+		}
+		sampled, err := subscriptionRepo.GetCountForReconciliation(models.ReconciliationFilter{AppIDs: []int{4}, SampleEvery: 2, SampleOffset: offset})
+		assert.NoError(t, err, "GetCountForReconciliation should not return an error")
+		assert.Equal(t, expected, sampled, "Only subscriptions at the offset should be sampled")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke ReconciliationJob: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package workermanager
+
+import (
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestScalingService_DesiredWorkers(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, batchRepo *models.BatchRepository, workerRepo *models.WorkerRepository) {
+		// Step 1: Seed a running action with 38 pending and 2 processing batches, and a paused one with 40 pending batches
+		running := models.ManagerAction{Type: "scaling_test", Status: "running", TriggeredAt: time.Now()}
+		paused := models.ManagerAction{Type: "scaling_test_paused", Status: "paused", TriggeredAt: time.Now()}
+		err := db.Create(&[]*models.ManagerAction{&running, &paused}).Error
+		assert.NoError(t, err, "Failed to seed manager actions")
+
+		ranges := models.SplitRange(4000, 100)
+		assert.NoError(t, batchRepo.CreateBatches(running.ID, ranges, nil), "Failed to seed batches")
+		assert.NoError(t, batchRepo.CreateBatches(paused.ID, ranges, nil), "Failed to seed batches")
+		err = db.Model(&models.Batch{}).Where("action_id = ? AND start_index <= ?", running.ID, 200).Update("status", "processing").Error
+		assert.NoError(t, err, "Failed to start batches")
+
+		// Step 2: Completed batches of 60 and 120 seconds feed the average duration
+		finished := time.Now()
+		for _, duration := range []time.Duration{time.Minute, 2 * time.Minute} {
+			started := finished.Add(-duration)
+			err = db.Create(&models.Batch{ActionID: paused.ID, Status: "completed", StartedAt: &started, FinishedAt: &finished}).Error
+			assert.NoError(t, err, "Failed to seed completed batch")
+		}
+
+		// Step 3: Seed an idle worker running 2 batches at once, a processing one running 1 and a paused one running 3
+		for _, worker := range []struct {
+			concurrency int
+			statuses    []string
+		}{
+			{2, []string{models.WorkerStatusIdle}},
+			{1, []string{models.WorkerStatusIdle, models.WorkerStatusProcessing}},
+			{3, []string{models.WorkerStatusIdle, models.WorkerStatusPaused}},
+		} {
+			workerID := uuid.New().String()
+			_, err = workerRepo.RegisterWorker(workerID, worker.concurrency, nil)
+			assert.NoError(t, err, "Failed to register worker")
+			for _, status := range worker.statuses {
+				_, err = workerRepo.TransitionWorker(workerID, status, nil, "Scaling test")
+				assert.NoError(t, err, "Failed to transition worker")
+			}
+		}
+
+		// Step 4: 40 batches of 90 seconds on 3 slots take 1200 seconds, meeting 600 seconds takes 6 slots,
+		// which are 3 workers of the average concurrency of 2
+		scaling := services.NewScalingService(&config.Config{ScalingMinWorkers: 0, ScalingMaxWorkers: 10, ScalingTargetSeconds: 600, WorkerConcurrency: 1}, batchRepo, workerRepo)
+		signal, err := scaling.GetScalingSignal()
+		assert.NoError(t, err, "GetScalingSignal should not return an error")
+		assert.Equal(t, int64(38), signal.PendingBatches, "Pending batches of paused actions should not be counted")
+		assert.Equal(t, int64(2), signal.ProcessingBatches, "Processing batches should be counted")
+		assert.Equal(t, 3, signal.ActiveWorkers, "Paused workers should be active")
+		assert.Equal(t, 3, signal.WorkerSlots, "Only idle and processing workers should have slots")
+		assert.Equal(t, int64(2), signal.DurationSamples, "Completed batches should be sampled")
+		assert.InDelta(t, 90, signal.AverageBatchSeconds, 0.001, "The average should be taken over the completed batches")
+		assert.InDelta(t, 1200, signal.BacklogSeconds, 0.001, "The backlog should be spread over the slots")
+		assert.Equal(t, 3, signal.DesiredWorkers, "The desired workers should meet the target")
+
+		// Step 5: The desired worker count stays within the bounds
+		scaling = services.NewScalingService(&config.Config{ScalingMinWorkers: 5, ScalingMaxWorkers: 10, ScalingTargetSeconds: 600, WorkerConcurrency: 1}, batchRepo, workerRepo)
+		signal, err = scaling.GetScalingSignal()
+		assert.NoError(t, err, "GetScalingSignal should not return an error")
+		assert.Equal(t, 5, signal.DesiredWorkers, "Desired workers should not go below the minimum")
+
+		scaling = services.NewScalingService(&config.Config{ScalingMinWorkers: 0, ScalingMaxWorkers: 2, ScalingTargetSeconds: 600, WorkerConcurrency: 1}, batchRepo, workerRepo)
+		signal, err = scaling.GetScalingSignal()
+		assert.NoError(t, err, "GetScalingSignal should not return an error")
+		assert.Equal(t, 2, signal.DesiredWorkers, "Desired workers should not exceed the maximum")
+
+		// Step 6: Without history and workers every batch takes the target time on workers of the configured concurrency
+		err = db.Where("status = ?", "completed").Delete(&models.Batch{}).Error
+		assert.NoError(t, err, "Failed to delete completed batches")
+		err = db.Where("1 = 1").Delete(&models.Worker{}).Error
+		assert.NoError(t, err, "Failed to delete workers")
+
+		scaling = services.NewScalingService(&config.Config{ScalingMinWorkers: 0, ScalingMaxWorkers: 100, ScalingTargetSeconds: 600, WorkerConcurrency: 4}, batchRepo, workerRepo)
+		signal, err = scaling.GetScalingSignal()
+		assert.NoError(t, err, "GetScalingSignal should not return an error")
+		assert.Equal(t, int64(0), signal.DurationSamples, "No batch should be sampled")
+		assert.InDelta(t, 24000, signal.BacklogSeconds, 0.001, "Without slots the backlog should be the whole work")
+		assert.Equal(t, 10, signal.DesiredWorkers, "40 slots should take 10 workers of 4")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke ScalingService: %v", err)
+	}
+}
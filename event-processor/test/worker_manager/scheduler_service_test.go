@@ -0,0 +1,109 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestScheduler_RunDueOneOff(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, schedulerService *services.SchedulerService) {
+		// Step 1: Schedule a one-off run in the past, as if the manager was down
+		schedule, err := schedulerService.ScheduleOnce(services.JobTypeRenewalSweep, time.Now().Add(-time.Hour), nil)
+		assert.NoError(t, err, "ScheduleOnce should not return an error")
+
+		// Step 2: Run due schedules
+		err = schedulerService.RunDue(time.Now())
+		assert.NoError(t, err, "RunDue should not return an error")
+
+		// Step 3: Validate the fire was recorded
+		var runs []models.ScheduleRun
+		err = db.Where("schedule_id = ?", schedule.ID).Find(&runs).Error
+		assert.NoError(t, err, "Schedule runs should be fetched")
+		if assert.Equal(t, 1, len(runs), "The missed one-off run should fire exactly once") {
+			assert.Equal(t, "succeeded", runs[0].Status, "Without an active sweep the run should create one")
+			assert.NotNil(t, runs[0].ActionID, "The created action should be recorded")
+		}
+
+		// Step 4: Validate the one-off schedule is disabled
+		var updated models.Schedule
+		err = db.First(&updated, schedule.ID).Error
+		assert.NoError(t, err, "Schedule should exist")
+		assert.False(t, updated.Enabled, "One-off schedule should be disabled after firing")
+		assert.Nil(t, updated.NextRunAt, "One-off schedule should not have a next run")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke SchedulerService: %v", err)
+	}
+}
+
+func TestScheduler_RunDueCatchUp(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, schedulerService *services.SchedulerService) {
+		// Step 1: Create an hourly schedule that was due 5 hours ago, as if the manager was down
+		schedule, err := schedulerService.CreateCronSchedule("hourly_sweep", services.JobTypeRenewalSweep, "0 * * * *", nil)
+		assert.NoError(t, err, "CreateCronSchedule should not return an error")
+
+		now := time.Now()
+		currentHour := now.Truncate(time.Hour)
+		err = db.Model(&models.Schedule{}).Where("id = ?", schedule.ID).Update("next_run_at", currentHour.Add(-5*time.Hour)).Error
+		assert.NoError(t, err, "Failed to move the next run into the past")
+
+		// Step 2: The 6 due occurrences fire once for the latest, the 5 before it are recorded as missed
+		err = schedulerService.RunDue(now)
+		assert.NoError(t, err, "RunDue should not return an error")
+
+		var runs []models.ScheduleRun
+		err = db.Where("schedule_id = ?", schedule.ID).Order("id ASC").Find(&runs).Error
+		assert.NoError(t, err, "Schedule runs should be fetched")
+		if assert.Equal(t, 2, len(runs), "Missed occurrences should be collapsed into one run") {
+			assert.Equal(t, "missed", runs[0].Status, "The earlier occurrences should be recorded as missed")
+			assert.True(t, runs[0].ScheduledFor.Equal(currentHour.Add(-5*time.Hour)), "The missed run should start at the first missed occurrence")
+			assert.Contains(t, runs[0].Message, "5 runs", "The missed run should count the missed occurrences")
+			assert.Equal(t, "succeeded", runs[1].Status, "The latest occurrence should create an action")
+			assert.True(t, runs[1].ScheduledFor.Equal(currentHour), "The run should fire for the latest occurrence")
+			assert.NotNil(t, runs[1].ActionID, "The created action should be recorded")
+		}
+
+		var updated models.Schedule
+		err = db.First(&updated, schedule.ID).Error
+		assert.NoError(t, err, "Schedule should exist")
+		assert.True(t, updated.Enabled, "Cron schedules should stay enabled")
+		if assert.NotNil(t, updated.NextRunAt, "Cron schedules should have a next run") {
+			assert.True(t, updated.NextRunAt.Equal(currentHour.Add(time.Hour)), "The next run should be the next hour")
+		}
+		if assert.NotNil(t, updated.LastRunAt, "The handled occurrence should be stored") {
+			assert.True(t, updated.LastRunAt.Equal(currentHour), "The last run should be the latest occurrence")
+		}
+
+		// Step 3: Nothing is due until the next hour
+		err = schedulerService.RunDue(now)
+		assert.NoError(t, err, "RunDue should not return an error")
+		var count int64
+		err = db.Model(&models.ScheduleRun{}).Where("schedule_id = ?", schedule.ID).Count(&count).Error
+		assert.NoError(t, err, "Schedule runs should be counted")
+		assert.Equal(t, int64(2), count, "A schedule should not fire before its next run")
+
+		// Step 4: The next occurrence is skipped while the action it would duplicate is still active
+		err = schedulerService.RunDue(currentHour.Add(time.Hour))
+		assert.NoError(t, err, "RunDue should not return an error")
+
+		var last models.ScheduleRun
+		err = db.Where("schedule_id = ?", schedule.ID).Order("id DESC").First(&last).Error
+		assert.NoError(t, err, "The last run should be fetched")
+		assert.Equal(t, "skipped", last.Status, "A run should be skipped while an action of its type is active")
+		assert.True(t, last.ScheduledFor.Equal(currentHour.Add(time.Hour)), "The skipped run should belong to the next hour")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke SchedulerService: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestBatchRepository_SaveCheckpointConflict(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, batchRepo *models.BatchRepository, workerRepo *models.WorkerRepository, subscriptionRepo *models.SubscriptionRepository, historyRepo *models.SubscriptionHistoryRepository) {
+		// Step 1: Seed two expired subscriptions and a batch locked by a running worker
+		subscriptions := []models.Subscription{
+			{UID: uuid.New().String(), AppID: 1, Receipt: "race-1", Status: models.SubscriptionStatusExpired, ExpireAt: time.Now().AddDate(0, 0, -1)},
+			{UID: uuid.New().String(), AppID: 1, Receipt: "race-2", Status: models.SubscriptionStatusExpired, ExpireAt: time.Now().AddDate(0, 0, -1)},
+		}
+		err := db.Create(&subscriptions).Error
+		assert.NoError(t, err, "Failed to seed subscriptions")
+
+		// Their last change came from the purchase API, stamped with the second-precision time of its own clock,
+		// which a renewal within the same second would repeat
+		err = db.Exec("UPDATE subscriptions SET updated_at = date_trunc('second', LOCALTIMESTAMP) WHERE id IN ?", []int64{subscriptions[0].ID, subscriptions[1].ID}).Error
+		assert.NoError(t, err, "Failed to simulate purchases")
+
+		workerID := uuid.New().String()
+		_, err = workerRepo.RegisterWorker(workerID, 1, nil)
+		assert.NoError(t, err, "Failed to register worker")
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusProcessing, nil, "Claimed batch")
+		assert.NoError(t, err, "Failed to start worker")
+
+		action := models.ManagerAction{Type: "conflict_test", Status: "running", TriggeredAt: time.Now()}
+		err = db.Create(&action).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+		batch := models.Batch{ActionID: action.ID, StartIndex: 1, EndIndex: 2, Status: "processing", LockedBy: &workerID}
+		err = db.Create(&batch).Error
+		assert.NoError(t, err, "Failed to seed batch")
+
+		// Step 2: The worker reads the batch and validates the receipts with an older store result
+		read, err := subscriptionRepo.GetSubscriptionsByIDs([]int64{subscriptions[0].ID, subscriptions[1].ID})
+		assert.NoError(t, err, "Failed to read subscriptions")
+
+		staleExpireAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		var updates []models.Subscription
+		var events []models.SubscriptionEvent
+		result := models.BatchResult{ProcessedCount: 2}
+		for _, sub := range read {
+			old := sub
+			sub.Status = models.SubscriptionStatusActive
+			sub.ExpireAt = staleExpireAt
+			result.CountUpdate(sub.Status)
+			updates = append(updates, sub)
+			events = append(events, models.SubscriptionEvent{
+				Status:         "renewed",
+				SubscriptionID: sub.ID,
+				OldStatus:      old.Status,
+				NewStatus:      sub.Status,
+				OldExpireAt:    old.ExpireAt,
+				NewExpireAt:    sub.ExpireAt,
+				Source:         models.SubscriptionEventSourceWorker,
+				ActionID:       action.ID,
+				BatchID:        batch.ID,
+				OccurredAt:     time.Now(),
+			})
+		}
+
+		// Step 3: Meanwhile the user renews the first subscription through the purchase API, which locks the
+		// row and stamps it the same way
+		purchase := db.Begin()
+		defer purchase.Rollback()
+		err = purchase.Exec("SELECT id FROM subscriptions WHERE id = ? FOR UPDATE", subscriptions[0].ID).Error
+		assert.NoError(t, err, "Failed to lock subscription")
+
+		// Step 4: The checkpoint is saved while the purchase holds the row and waits for it
+		type saveResult struct {
+			checkpoint models.BatchCheckpoint
+			err        error
+		}
+		checkpoint := models.BatchCheckpoint{LastKey: subscriptions[1].ID, Position: 2, Result: result}
+		done := make(chan saveResult, 1)
+		go func() {
+			saved, err := batchRepo.SaveCheckpoint(batch.ID, workerID, checkpoint, models.CheckpointWrites{ActionID: action.ID, Updates: updates, Events: events})
+			done <- saveResult{saved, err}
+		}()
+		assert.Eventually(t, func() bool {
+			var waiting int64
+			db.Raw("SELECT COUNT(*) FROM pg_stat_activity WHERE wait_event_type = 'Lock' AND query LIKE 'UPDATE subscriptions%'").Scan(&waiting)
+			return waiting > 0
+		}, 5*time.Second, 20*time.Millisecond, "The checkpoint should wait for the purchase")
+
+		purchaseExpireAt := time.Now().AddDate(1, 0, 0).Truncate(time.Second)
+		err = purchase.Exec("UPDATE subscriptions SET status = ?, expire_at = ?, updated_at = date_trunc('second', LOCALTIMESTAMP) WHERE id = ?",
+			models.SubscriptionStatusRenewed, purchaseExpireAt, subscriptions[0].ID).Error
+		assert.NoError(t, err, "Failed to renew subscription")
+		assert.NoError(t, purchase.Commit().Error, "Failed to commit purchase")
+
+		// Step 5: The checkpoint skips the changed subscription and counts the conflict
+		var saved models.BatchCheckpoint
+		select {
+		case res := <-done:
+			saved, err = res.checkpoint, res.err
+		case <-time.After(10 * time.Second):
+			t.Fatal("The checkpoint should be saved once the purchase commits")
+		}
+		assert.NoError(t, err, "SaveCheckpoint should not return an error")
+		assert.Equal(t, int64(1), saved.Result.ConflictCount, "The purchase should be counted as a conflict")
+		assert.Equal(t, int64(1), saved.Result.RenewedCount, "Only the written update should count as renewed")
+
+		reloaded, err := batchRepo.GetBatchByID(batch.ID)
+		assert.NoError(t, err, "Failed to fetch batch")
+		if assert.NotNil(t, reloaded.Checkpoint, "The checkpoint should be saved") {
+			assert.Equal(t, int64(1), reloaded.Checkpoint.Result.ConflictCount, "The stored counters should include the conflict")
+		}
+
+		// Step 6: The purchase is kept with a database stamp, and the untouched subscription is written
+		purchased, err := subscriptionRepo.GetSubscriptionByID(subscriptions[0].ID)
+		assert.NoError(t, err, "Failed to fetch subscription")
+		assert.Equal(t, models.SubscriptionStatusRenewed, purchased.Status, "The purchase should not be overwritten")
+		assert.True(t, purchased.ExpireAt.Equal(purchaseExpireAt), "The purchased expiry should be kept")
+		assert.True(t, purchased.UpdatedAt.After(read[0].UpdatedAt), "The purchase should be stamped by the database")
+
+		swept, err := subscriptionRepo.GetSubscriptionByID(subscriptions[1].ID)
+		assert.NoError(t, err, "Failed to fetch subscription")
+		assert.Equal(t, models.SubscriptionStatusActive, swept.Status, "The unchanged subscription should be renewed")
+
+		// Step 7: Only the written update is recorded in the history
+		history, _, err := historyRepo.ListHistory(models.SubscriptionHistoryFilter{SubscriptionID: subscriptions[0].ID, Limit: 10})
+		assert.NoError(t, err, "ListHistory should not return an error")
+		assert.Empty(t, history, "The skipped update should not be recorded")
+
+		history, _, err = historyRepo.ListHistory(models.SubscriptionHistoryFilter{SubscriptionID: subscriptions[1].ID, Limit: 10})
+		assert.NoError(t, err, "ListHistory should not return an error")
+		assert.Len(t, history, 1, "The written update should be recorded")
+
+		// Step 8: A bulk update with the stale read reports the conflict as well
+		conflicts, err := subscriptionRepo.BulkUpdateSubscriptions([]models.Subscription{updates[0]}, nil)
+		assert.NoError(t, err, "BulkUpdateSubscriptions should not return an error")
+		if assert.Len(t, conflicts, 1, "The stale update should conflict") {
+			assert.Equal(t, subscriptions[0].ID, conflicts[0].ID, "The changed subscription should be reported")
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke BatchRepository: %v", err)
+	}
+}
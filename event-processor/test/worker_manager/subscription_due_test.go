@@ -0,0 +1,126 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSubscriptionRepository_DueQueue(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, subscriptionRepo *models.SubscriptionRepository, batchRepo *models.BatchRepository, windowRepo *models.ValidationWindowRepository) {
+		// Step 1: Seed expired subscriptions for one app checked long ago, they are due from their expiry
+		var subscriptions []models.Subscription
+		for i := 0; i < 6; i++ {
+			subscriptions = append(subscriptions, models.Subscription{UID: uuid.New().String(), AppID: 2, Receipt: "due", Status: models.SubscriptionStatusExpired, ExpireAt: time.Now().AddDate(0, 0, -i-1).Truncate(time.Second), UpdatedAt: time.Now().AddDate(0, 0, -10)})
+		}
+		err := db.Create(&subscriptions).Error
+		assert.NoError(t, err, "Failed to seed subscriptions")
+
+		var due models.SubscriptionDue
+		err = db.First(&due, "subscription_id = ?", subscriptions[0].ID).Error
+		assert.NoError(t, err, "The subscription should be in the due queue")
+		assert.True(t, due.DueAt.Equal(subscriptions[0].ExpireAt), "The due time should be the expiry")
+		assert.True(t, due.ExpireAt.Equal(subscriptions[0].ExpireAt), "The expiry should be copied")
+
+		filter := models.SubscriptionFilter{AppIDs: []int{2}}
+		count, err := subscriptionRepo.GetCountForProcessing(filter)
+		assert.NoError(t, err, "GetCountForProcessing should not return an error")
+		assert.Equal(t, int64(6), count, "Every expired subscription should be due")
+
+		// Step 2: Renewals move the due time, deletions leave the queue
+		err = db.Model(&models.Subscription{}).Where("id = ?", subscriptions[5].ID).Update("expire_at", time.Now().AddDate(0, 1, 0)).Error
+		assert.NoError(t, err, "Failed to renew subscription")
+		err = db.Delete(&models.Subscription{}, subscriptions[4].ID).Error
+		assert.NoError(t, err, "Failed to delete subscription")
+
+		err = db.First(&models.SubscriptionDue{}, "subscription_id = ?", subscriptions[4].ID).Error
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "Deleted subscriptions should leave the due queue")
+
+		count, err = subscriptionRepo.GetCountForProcessing(filter)
+		assert.NoError(t, err, "GetCountForProcessing should not return an error")
+		assert.Equal(t, int64(4), count, "Renewed and deleted subscriptions should not be due")
+
+		// Step 3: Batches are split by key and read after the key of the previous batch
+		keys, err := subscriptionRepo.FetchBatchKeys(filter, count, 3)
+		assert.NoError(t, err, "FetchBatchKeys should not return an error")
+		if assert.Len(t, keys, 1, "Two batches should need one key") {
+			assert.Equal(t, subscriptions[2].ID, keys[0], "The first batch should end with the third subscription")
+			ranges := models.SplitKeyedRange(count, 3, keys)
+			assert.Equal(t, []models.BatchRange{{Start: 1, End: 3, EndKey: keys[0]}, {Start: 4, End: 4, AfterKey: keys[0]}}, ranges, "Batches should be bounded by the keys")
+
+			batch, err := subscriptionRepo.FetchSubscriptionsAfterKey(filter, ranges[1].AfterKey, ranges[1].EndKey, 3)
+			assert.NoError(t, err, "FetchSubscriptionsAfterKey should not return an error")
+			if assert.Len(t, batch, 1, "The second batch should hold the remaining subscription") {
+				assert.Equal(t, subscriptions[3].ID, batch[0].ID, "The second batch should start after the key")
+			}
+
+			// Step 4: A batch that shrank since it was split stops at its end key instead of reading into the next one
+			err = db.Model(&models.Subscription{}).Where("id = ?", subscriptions[1].ID).Update("expire_at", time.Now().AddDate(0, 1, 0)).Error
+			assert.NoError(t, err, "Failed to renew subscription")
+
+			batch, err = subscriptionRepo.FetchSubscriptionsAfterKey(filter, ranges[0].AfterKey, ranges[0].EndKey, 3)
+			assert.NoError(t, err, "FetchSubscriptionsAfterKey should not return an error")
+			if assert.Len(t, batch, 2, "The first batch should only hold its remaining subscriptions") {
+				assert.Equal(t, subscriptions[0].ID, batch[0].ID, "The first batch should start with the first subscription")
+				assert.Equal(t, subscriptions[2].ID, batch[1].ID, "The first batch should end with its key")
+			}
+		}
+
+		// Step 5: Checked subscriptions are due again after the re-check interval, canceled ones leave the queue
+		err = db.Model(&models.Subscription{}).Where("id = ?", subscriptions[0].ID).Update("status", models.SubscriptionStatusExpired).Error
+		assert.NoError(t, err, "Failed to check subscription")
+		err = db.First(&due, "subscription_id = ?", subscriptions[0].ID).Error
+		assert.NoError(t, err, "The checked subscription should stay in the due queue")
+		assert.WithinDuration(t, time.Now().Add(30*time.Minute), due.DueAt, time.Minute, "The checked subscription should be due after the re-check interval")
+
+		err = db.Model(&models.Subscription{}).Where("id = ?", subscriptions[3].ID).Update("status", models.SubscriptionStatusCanceled).Error
+		assert.NoError(t, err, "Failed to cancel subscription")
+		err = db.First(&models.SubscriptionDue{}, "subscription_id = ?", subscriptions[3].ID).Error
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "Canceled subscriptions should leave the due queue")
+
+		count, err = subscriptionRepo.GetCountForProcessing(filter)
+		assert.NoError(t, err, "GetCountForProcessing should not return an error")
+		assert.Equal(t, int64(1), count, "Only the subscriptions due now should be counted")
+
+		// Step 6: Subscriptions a sweep skipped are moved to their next check
+		err = db.Model(&models.SubscriptionDue{}).Where("subscription_id = ?", subscriptions[0].ID).Update("due_at", time.Now().Add(-time.Hour)).Error
+		assert.NoError(t, err, "Failed to make the subscription due")
+
+		workerID := uuid.New().String()
+		action := models.ManagerAction{Type: "due_test", Status: "running", TriggeredAt: time.Now()}
+		err = db.Create(&action).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+		batch := models.Batch{ActionID: action.ID, StartIndex: 1, EndIndex: 1, Status: "processing", LockedBy: &workerID}
+		err = db.Create(&batch).Error
+		assert.NoError(t, err, "Failed to seed batch")
+
+		checkpoint := models.BatchCheckpoint{LastKey: subscriptions[0].ID, Position: 1, Result: models.BatchResult{ProcessedCount: 1, SkippedCount: 1}}
+		_, err = batchRepo.SaveCheckpoint(batch.ID, workerID, checkpoint, models.CheckpointWrites{ActionID: action.ID, Skipped: []int64{subscriptions[0].ID}})
+		assert.NoError(t, err, "SaveCheckpoint should not return an error")
+		err = db.First(&due, "subscription_id = ?", subscriptions[0].ID).Error
+		assert.NoError(t, err, "The skipped subscription should stay in the due queue")
+		assert.True(t, due.DueAt.After(time.Now()), "The skipped subscription should be due at its next check")
+
+		// Step 7: Subscriptions the window gave up on leave the queue, once the window changes or as time passes
+		_, err = windowRepo.SaveWindow(models.ValidationWindow{AppID: 2, RecheckInterval: 1800, GraceRecheckInterval: 1800, GiveUpAfter: 2 * 86400})
+		assert.NoError(t, err, "SaveWindow should not return an error")
+		err = db.First(&models.SubscriptionDue{}, "subscription_id = ?", subscriptions[2].ID).Error
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "Subscriptions past the window should leave the due queue")
+
+		err = db.Model(&models.SubscriptionDue{}).Where("subscription_id = ?", subscriptions[0].ID).Update("expire_at", time.Now().AddDate(0, 0, -3)).Error
+		assert.NoError(t, err, "Failed to age the due queue row")
+		pruned, err := subscriptionRepo.PruneDueQueue()
+		assert.NoError(t, err, "PruneDueQueue should not return an error")
+		assert.Equal(t, int64(1), pruned, "The row past the window should be pruned")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke SubscriptionRepository: %v", err)
+	}
+}
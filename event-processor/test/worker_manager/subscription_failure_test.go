@@ -0,0 +1,103 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSubscriptionFailureRepository_Quarantine(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, batchRepo *models.BatchRepository, workerRepo *models.WorkerRepository, subscriptionRepo *models.SubscriptionRepository, failureRepo *models.SubscriptionFailureRepository) {
+		// Step 1: Seed an expired subscription and a batch locked by a running worker
+		subscription := models.Subscription{UID: uuid.New().String(), AppID: 1, Receipt: "poison-" + uuid.New().String()[:8], Status: "expired", ExpireAt: time.Now().AddDate(0, 0, -1)}
+		err := db.Create(&subscription).Error
+		assert.NoError(t, err, "Failed to seed subscription")
+
+		workerID := uuid.New().String()
+		_, err = workerRepo.RegisterWorker(workerID, 1, nil)
+		assert.NoError(t, err, "Failed to register worker")
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusProcessing, nil, "Claimed batch")
+		assert.NoError(t, err, "Failed to start worker")
+
+		action := models.ManagerAction{Type: "quarantine_test", Status: "running", TriggeredAt: time.Now()}
+		err = db.Create(&action).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+		batch := models.Batch{ActionID: action.ID, StartIndex: 1, EndIndex: 1, Status: "processing", LockedBy: &workerID}
+		err = db.Create(&batch).Error
+		assert.NoError(t, err, "Failed to seed batch")
+
+		policy := models.RetryPolicy{MaxAttempts: 2, Backoff: time.Minute, MaxBackoff: time.Hour}
+		writes := models.CheckpointWrites{
+			ActionID: action.ID,
+			Failures: []models.ItemFailure{{SubscriptionID: subscription.ID, Error: "invalid receipt"}},
+			Retry:    policy,
+		}
+		checkpoint := models.BatchCheckpoint{LastKey: subscription.ID, Position: 1, Result: models.BatchResult{ProcessedCount: 1, FailedCount: 1}}
+		filter := models.SubscriptionFilter{SubscriptionIDs: []int64{subscription.ID}}
+
+		// Step 2: The first failure schedules a retry and sweeps skip the subscription until then
+		_, err = batchRepo.SaveCheckpoint(batch.ID, workerID, checkpoint, writes)
+		assert.NoError(t, err, "SaveCheckpoint should not return an error")
+
+		var failure models.SubscriptionFailure
+		err = db.First(&failure, "subscription_id = ?", subscription.ID).Error
+		assert.NoError(t, err, "The failure should be recorded")
+		assert.Equal(t, 1, failure.Attempts, "The first attempt should be counted")
+		assert.Equal(t, "invalid receipt", failure.LastError, "The error should be kept")
+		assert.True(t, failure.NextRetryAt.After(time.Now()), "The retry should be scheduled")
+
+		due, err := subscriptionRepo.FetchSubscriptionsAfterKey(filter, 0, 0, 10)
+		assert.NoError(t, err, "FetchSubscriptionsAfterKey should not return an error")
+		assert.Empty(t, due, "The subscription should wait for its retry")
+
+		// Step 3: Reaching the maximum attempts quarantines the receipt
+		_, err = batchRepo.SaveCheckpoint(batch.ID, workerID, checkpoint, writes)
+		assert.NoError(t, err, "SaveCheckpoint should not return an error")
+
+		err = db.First(&models.SubscriptionFailure{}, "subscription_id = ?", subscription.ID).Error
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "The failure should move to the quarantine")
+
+		var receipt models.QuarantinedReceipt
+		err = db.First(&receipt, "subscription_id = ?", subscription.ID).Error
+		assert.NoError(t, err, "The receipt should be quarantined")
+		assert.Equal(t, models.QuarantineStatusQuarantined, receipt.Status, "The receipt should wait for a review")
+		assert.Equal(t, 2, receipt.Attempts, "The attempts should be kept")
+		assert.Equal(t, subscription.Receipt, receipt.Receipt, "The receipt should be copied")
+
+		filter.Force = true
+		due, err = subscriptionRepo.FetchSubscriptionsAfterKey(filter, 0, 0, 10)
+		assert.NoError(t, err, "FetchSubscriptionsAfterKey should not return an error")
+		assert.Empty(t, due, "Even forced sweeps should skip quarantined receipts")
+
+		// Step 4: A re-queued receipt is picked up again, a second review conflicts
+		reviewed, err := failureRepo.ReviewQuarantined(receipt.ID, models.QuarantineStatusRequeued, "operator", "store fixed")
+		assert.NoError(t, err, "ReviewQuarantined should not return an error")
+		assert.Equal(t, models.QuarantineStatusRequeued, reviewed.Status, "The receipt should be re-queued")
+		if assert.NotNil(t, reviewed.ReviewedBy, "The reviewer should be recorded") {
+			assert.Equal(t, "operator", *reviewed.ReviewedBy, "The reviewer should be recorded")
+		}
+
+		due, err = subscriptionRepo.FetchSubscriptionsAfterKey(filter, 0, 0, 10)
+		assert.NoError(t, err, "FetchSubscriptionsAfterKey should not return an error")
+		assert.Len(t, due, 1, "The re-queued subscription should be swept again")
+
+		_, err = failureRepo.ReviewQuarantined(receipt.ID, models.QuarantineStatusDismissed, "operator", "")
+		assert.ErrorIs(t, err, models.ErrQuarantineConflict, "A reviewed receipt should not be reviewed again")
+
+		// Step 5: The quarantine can be listed by status
+		receipts, total, err := failureRepo.ListQuarantine([]string{models.QuarantineStatusRequeued}, 10, 0)
+		assert.NoError(t, err, "ListQuarantine should not return an error")
+		assert.GreaterOrEqual(t, total, int64(1), "The re-queued receipt should be counted")
+		assert.NotEmpty(t, receipts, "The re-queued receipt should be listed")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke SubscriptionFailureRepository: %v", err)
+	}
+}
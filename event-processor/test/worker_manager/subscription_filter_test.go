@@ -0,0 +1,59 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSubscriptionRepository_FilteredCount(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, subscriptionRepo *models.SubscriptionRepository) {
+		// Step 1: Seed expired subscriptions for an iOS app and an Android app, last checked past the re-check interval
+		uid := uuid.New().String()
+		checkedAt := time.Now().Add(-time.Hour)
+		subscriptions := []models.Subscription{
+			{Status: "active", AppID: 1, UID: uid, ExpireAt: time.Now().AddDate(0, 0, -1), UpdatedAt: checkedAt},
+			{Status: "expired", AppID: 1, UID: uuid.New().String(), ExpireAt: time.Now().AddDate(0, 0, -10), UpdatedAt: checkedAt},
+			{Status: "canceled", AppID: 1, UID: uuid.New().String(), ExpireAt: time.Now().AddDate(0, 0, -1), UpdatedAt: checkedAt},
+			{Status: "active", AppID: 1, UID: uuid.New().String(), ExpireAt: time.Now().AddDate(0, 0, 10), UpdatedAt: checkedAt},
+			{Status: "expired", AppID: 3, UID: uuid.New().String(), ExpireAt: time.Now().AddDate(0, 0, -1), UpdatedAt: checkedAt},
+		}
+		err := db.Create(&subscriptions).Error
+		assert.NoError(t, err, "Failed to seed subscriptions")
+
+		// Step 2: Filter by app only skips canceled and not yet expired subscriptions
+		count, err := subscriptionRepo.GetCountForProcessing(models.SubscriptionFilter{AppIDs: []int{1}})
+		assert.NoError(t, err, "GetCountForProcessing should not return an error")
+		assert.Equal(t, int64(2), count, "Only expired, non-canceled subscriptions of the app should match")
+
+		// Step 3: Narrow down by expiry window
+		from := time.Now().AddDate(0, 0, -5)
+		count, err = subscriptionRepo.GetCountForProcessing(models.SubscriptionFilter{AppIDs: []int{1}, ExpireFrom: &from})
+		assert.NoError(t, err, "GetCountForProcessing should not return an error")
+		assert.Equal(t, int64(1), count, "Only subscriptions expired within the window should match")
+
+		// Step 4: Narrow down by user
+		count, err = subscriptionRepo.GetCountForProcessing(models.SubscriptionFilter{UIDs: []string{uid}})
+		assert.NoError(t, err, "GetCountForProcessing should not return an error")
+		assert.Equal(t, int64(1), count, "Only the subscription of the user should match")
+
+		// Step 5: Narrow down by the store of the apps
+		count, err = subscriptionRepo.GetCountForProcessing(models.SubscriptionFilter{Store: "ios"})
+		assert.NoError(t, err, "GetCountForProcessing should not return an error")
+		assert.Equal(t, int64(2), count, "Only subscriptions of iOS apps should match")
+
+		count, err = subscriptionRepo.GetCountForProcessing(models.SubscriptionFilter{Store: "android"})
+		assert.NoError(t, err, "GetCountForProcessing should not return an error")
+		assert.Equal(t, int64(1), count, "Only subscriptions of Android apps should match")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke SubscriptionRepository: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSubscriptionHistoryRepository_ListHistory(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, historyRepo *models.SubscriptionHistoryRepository, subscriptionRepo *models.SubscriptionRepository) {
+		// Step 1: Seed a subscription and let it lapse and renew
+		subscription := models.Subscription{UID: uuid.New().String(), AppID: 1, Receipt: "history-" + uuid.New().String()[:8], Status: "active", ExpireAt: time.Now().AddDate(0, 0, -1)}
+		err := db.Create(&subscription).Error
+		assert.NoError(t, err, "Failed to seed subscription")
+
+		read, err := subscriptionRepo.GetSubscriptionByID(subscription.ID)
+		assert.NoError(t, err, "Failed to read subscription")
+		expired := *read
+		expired.Status = "expired"
+		_, err = subscriptionRepo.BulkUpdateSubscriptions([]models.Subscription{expired}, []models.SubscriptionEvent{{
+			Status:         "expired",
+			SubscriptionID: subscription.ID,
+			AppID:          subscription.AppID,
+			OldStatus:      subscription.Status,
+			NewStatus:      expired.Status,
+			OldExpireAt:    subscription.ExpireAt,
+			NewExpireAt:    expired.ExpireAt,
+			Source:         models.SubscriptionEventSourceWorker,
+			ActionID:       7,
+			BatchID:        11,
+			ResponseHash:   "abc123",
+			OccurredAt:     time.Now().Add(-time.Hour),
+		}})
+		assert.NoError(t, err, "BulkUpdateSubscriptions should not return an error")
+
+		read, err = subscriptionRepo.GetSubscriptionByID(subscription.ID)
+		assert.NoError(t, err, "Failed to read subscription")
+		renewed := *read
+		renewed.Status = "active"
+		renewed.ExpireAt = time.Now().AddDate(0, 1, 0)
+		_, err = subscriptionRepo.BulkUpdateSubscriptions([]models.Subscription{renewed}, []models.SubscriptionEvent{{
+			Status:         "renewed",
+			SubscriptionID: subscription.ID,
+			AppID:          subscription.AppID,
+			OldStatus:      expired.Status,
+			NewStatus:      renewed.Status,
+			OldExpireAt:    expired.ExpireAt,
+			NewExpireAt:    renewed.ExpireAt,
+			Source:         models.SubscriptionEventSourceManual,
+			OccurredAt:     time.Now(),
+		}})
+		assert.NoError(t, err, "BulkUpdateSubscriptions should not return an error")
+
+		// Step 2: Both transitions are listed newest first
+		history, total, err := historyRepo.ListHistory(models.SubscriptionHistoryFilter{SubscriptionID: subscription.ID, Limit: 10})
+		assert.NoError(t, err, "ListHistory should not return an error")
+		assert.Equal(t, int64(2), total, "Both transitions should be counted")
+		if assert.Len(t, history, 2, "Both transitions should be listed") {
+			assert.Equal(t, "renewed", history[0].Reason, "The newest transition should come first")
+			assert.Nil(t, history[0].ActionID, "Transitions outside of sweeps should have no action")
+
+			lapse := history[1]
+			assert.Equal(t, models.SubscriptionStatusActive, lapse.FromStatus, "The previous status should be recorded")
+			assert.Equal(t, models.SubscriptionStatusExpired, lapse.ToStatus, "The new status should be recorded")
+			assert.Equal(t, models.SubscriptionEventSourceWorker, lapse.Source, "The source should be recorded")
+			if assert.NotNil(t, lapse.ActionID, "The action should be recorded") && assert.NotNil(t, lapse.BatchID, "The batch should be recorded") {
+				assert.Equal(t, int64(7), *lapse.ActionID, "The action should be recorded")
+				assert.Equal(t, int64(11), *lapse.BatchID, "The batch should be recorded")
+			}
+			assert.Equal(t, "abc123", lapse.ResponseHash, "The response hash should be recorded")
+		}
+
+		// Step 3: Transitions can be looked up by user and narrowed by source and time
+		history, _, err = historyRepo.ListHistory(models.SubscriptionHistoryFilter{UID: subscription.UID, Sources: []string{models.SubscriptionEventSourceWorker}, Limit: 10})
+		assert.NoError(t, err, "ListHistory should not return an error")
+		if assert.Len(t, history, 1, "Only the worker transition should be listed") {
+			assert.Equal(t, "expired", history[0].Reason, "The lapse should be listed")
+		}
+
+		from := time.Now().Add(-30 * time.Minute)
+		history, _, err = historyRepo.ListHistory(models.SubscriptionHistoryFilter{UID: subscription.UID, From: &from, Limit: 10})
+		assert.NoError(t, err, "ListHistory should not return an error")
+		assert.Len(t, history, 1, "Only the transition after from should be listed")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke SubscriptionHistoryRepository: %v", err)
+	}
+}
+
+func TestSubscriptionHistoryRepository_EnsurePartitions(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, historyRepo *models.SubscriptionHistoryRepository, subscriptionRepo *models.SubscriptionRepository) {
+		// Step 1: A transition of a month without a partition lands in the default partition
+		occurredAt := time.Date(time.Now().Year()-2, time.March, 15, 12, 0, 0, 0, time.UTC)
+		partition := "subscription_history_" + occurredAt.Format("2006_01")
+		err := db.Exec("DROP TABLE IF EXISTS " + partition).Error
+		assert.NoError(t, err, "Failed to drop partition")
+
+		subscription := models.Subscription{UID: uuid.New().String(), AppID: 1, Receipt: "partition-" + uuid.New().String()[:8], Status: "active", ExpireAt: time.Now().AddDate(0, 0, -1)}
+		err = db.Create(&subscription).Error
+		assert.NoError(t, err, "Failed to seed subscription")
+
+		read, err := subscriptionRepo.GetSubscriptionByID(subscription.ID)
+		assert.NoError(t, err, "Failed to read subscription")
+		expired := *read
+		expired.Status = "expired"
+		_, err = subscriptionRepo.BulkUpdateSubscriptions([]models.Subscription{expired}, []models.SubscriptionEvent{{
+			Status:         "expired",
+			SubscriptionID: subscription.ID,
+			AppID:          subscription.AppID,
+			OldStatus:      subscription.Status,
+			NewStatus:      expired.Status,
+			OldExpireAt:    subscription.ExpireAt,
+			NewExpireAt:    expired.ExpireAt,
+			Source:         models.SubscriptionEventSourceWorker,
+			OccurredAt:     occurredAt,
+		}})
+		assert.NoError(t, err, "BulkUpdateSubscriptions should not fail without a partition for the month")
+
+		var count int64
+		err = db.Table("subscription_history_default").Count(&count).Error
+		assert.NoError(t, err, "Failed to count the default partition")
+		assert.Equal(t, int64(1), count, "The transition should be in the default partition")
+
+		// Step 2: Creating the partition of the month moves the transition into it
+		err = historyRepo.EnsurePartitions(occurredAt, 0)
+		assert.NoError(t, err, "EnsurePartitions should not return an error")
+		err = historyRepo.EnsurePartitions(occurredAt, 0)
+		assert.NoError(t, err, "EnsurePartitions should leave existing partitions alone")
+
+		err = db.Table("subscription_history_default").Count(&count).Error
+		assert.NoError(t, err, "Failed to count the default partition")
+		assert.Equal(t, int64(0), count, "The default partition should be emptied")
+		err = db.Table(partition).Count(&count).Error
+		assert.NoError(t, err, "The partition of the month should exist")
+		assert.Equal(t, int64(1), count, "The transition should be moved into the partition of its month")
+
+		history, _, err := historyRepo.ListHistory(models.SubscriptionHistoryFilter{SubscriptionID: subscription.ID, Limit: 10})
+		assert.NoError(t, err, "ListHistory should not return an error")
+		if assert.Len(t, history, 1, "The moved transition should be listed") {
+			assert.True(t, occurredAt.Equal(history[0].CreatedAt), "The transition should keep its time")
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke SubscriptionHistoryRepository: %v", err)
+	}
+}
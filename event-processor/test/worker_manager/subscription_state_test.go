@@ -0,0 +1,164 @@
+package workermanager
+
+import (
+	"context"
+	"encoding/json"
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSubscriptionStatus_Transitions(t *testing.T) {
+	ResetDatabase(t)
+
+	// Step 1: Every status has entitlement semantics
+	assert.True(t, models.SubscriptionStatusGracePeriod.Entitled(), "Grace periods should keep access")
+	assert.False(t, models.SubscriptionStatusBillingRetry.Entitled(), "Billing retries should not grant access")
+	assert.False(t, models.SubscriptionStatusOnHold.Entitled(), "Holds should not grant access")
+	assert.False(t, models.SubscriptionStatusRevoked.Entitled(), "Revoked subscriptions should not grant access")
+
+	// Step 2: Transitions are validated and errors name both statuses
+	assert.NoError(t, models.ValidateSubscriptionTransition(models.SubscriptionStatusActive, models.SubscriptionStatusGracePeriod))
+	assert.NoError(t, models.ValidateSubscriptionTransition(models.SubscriptionStatusOnHold, models.SubscriptionStatusActive))
+	assert.NoError(t, models.ValidateSubscriptionTransition(models.SubscriptionStatusActive, models.SubscriptionStatusActive), "Extending the expiry should be allowed")
+
+	err := models.ValidateSubscriptionTransition(models.SubscriptionStatusRevoked, models.SubscriptionStatusActive)
+	assert.ErrorIs(t, err, models.ErrInvalidSubscriptionTransition, "Revoked subscriptions should only start again")
+	assert.ErrorContains(t, err, "revoked to active", "The error should name both statuses")
+
+	err = models.ValidateSubscriptionTransition("trial", models.SubscriptionStatusActive)
+	assert.ErrorIs(t, err, models.ErrUnknownSubscriptionStatus, "Unknown statuses should be rejected")
+
+	legacy, err := models.ParseSubscriptionStatus("pending")
+	assert.NoError(t, err, "The legacy pending status should be known")
+	assert.Equal(t, models.SubscriptionStatusStarted, legacy, "The legacy pending status should map to started")
+	assert.NoError(t, models.ValidateSubscriptionTransition("pending", models.SubscriptionStatusActive), "Legacy pending subscriptions should move like started ones")
+
+	// Step 3: Store results map into the state model
+	status, err := models.StoreStatus(true, "")
+	assert.NoError(t, err)
+	assert.Equal(t, models.SubscriptionStatusActive, status, "A valid receipt should be active")
+
+	status, err = models.StoreStatus(false, "")
+	assert.NoError(t, err)
+	assert.Equal(t, models.SubscriptionStatusExpired, status, "An invalid receipt should be expired")
+
+	status, err = models.StoreStatus(true, "grace_period")
+	assert.NoError(t, err)
+	assert.Equal(t, models.SubscriptionStatusGracePeriod, status, "Reported states should be taken")
+
+	_, err = models.StoreStatus(false, "grace_period")
+	assert.Error(t, err, "States should match the reported entitlement")
+	_, err = models.StoreStatus(true, "canceled")
+	assert.ErrorIs(t, err, models.ErrUnknownSubscriptionStatus, "Stores should not report purchase states")
+}
+
+func TestSubscriptionRepository_TransitionSubscription(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, subscriptionRepo *models.SubscriptionRepository, historyRepo *models.SubscriptionHistoryRepository) {
+		// Step 1: Seed a revoked subscription
+		subscription := models.Subscription{UID: uuid.New().String(), AppID: 1, Receipt: "state-" + uuid.New().String()[:8], Status: models.SubscriptionStatusRevoked, ExpireAt: time.Now().AddDate(0, 0, -1)}
+		err := db.Create(&subscription).Error
+		assert.NoError(t, err, "Failed to seed subscription")
+
+		// Step 2: Invalid transitions are rejected and leave the subscription alone
+		_, err = subscriptionRepo.TransitionSubscription(subscription.ID, models.SubscriptionStatusGracePeriod, models.SubscriptionEventSourceManual)
+		assert.ErrorIs(t, err, models.ErrInvalidSubscriptionTransition, "Revoked subscriptions should not enter a grace period")
+
+		stored, err := subscriptionRepo.GetSubscriptionByID(subscription.ID)
+		assert.NoError(t, err, "Failed to fetch subscription")
+		assert.Equal(t, models.SubscriptionStatusRevoked, stored.Status, "The status should not change")
+
+		// Step 3: Valid transitions are written with their history
+		updated, err := subscriptionRepo.TransitionSubscription(subscription.ID, models.SubscriptionStatusStarted, models.SubscriptionEventSourceManual)
+		assert.NoError(t, err, "TransitionSubscription should not return an error")
+		assert.Equal(t, models.SubscriptionStatusStarted, updated.Status, "The subscription should be started again")
+
+		history, _, err := historyRepo.ListHistory(models.SubscriptionHistoryFilter{SubscriptionID: subscription.ID, Limit: 10})
+		assert.NoError(t, err, "ListHistory should not return an error")
+		if assert.Len(t, history, 1, "Only the valid transition should be recorded") {
+			assert.Equal(t, models.SubscriptionStatusRevoked, history[0].FromStatus, "The previous status should be recorded")
+			assert.Equal(t, models.SubscriptionEventSourceManual, history[0].Source, "The source should be recorded")
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke SubscriptionRepository: %v", err)
+	}
+}
+
+func TestRenewalJob_LegacyPendingStatus(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, subscriptionRepo *models.SubscriptionRepository, batchRepo *models.BatchRepository, workerRepo *models.WorkerRepository, windowRepo *models.ValidationWindowRepository, failureRepo *models.SubscriptionFailureRepository) {
+		// Step 1: A writer from before the state model stores a pending subscription, it is stored as started
+		uid := uuid.New().String()
+		err := db.Exec("INSERT INTO subscriptions (uid, app_id, receipt, status, expire_at, updated_at) VALUES (?, 1, 'legacy-pending', 'pending', ?, ?)",
+			uid, time.Now().AddDate(0, 0, -1), time.Now().Add(-time.Hour)).Error
+		assert.NoError(t, err, "Failed to seed subscription")
+
+		var subscription models.Subscription
+		err = db.Where("uid = ?", uid).First(&subscription).Error
+		assert.NoError(t, err, "Failed to fetch subscription")
+		assert.Equal(t, models.SubscriptionStatusStarted, subscription.Status, "The legacy status should be stored as started")
+
+		// Step 2: A sweep renews it instead of failing on its status
+		renewedUntil := time.Now().UTC().AddDate(0, 1, 0).Truncate(time.Second)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": true, "expire_date": renewedUntil.Format("2006-01-02 15:04:05")})
+		}))
+		defer srv.Close()
+
+		storeApiService, err := services.NewStoreApiService(&config.Config{StoreApiHost: srv.URL})
+		assert.NoError(t, err, "Failed to create store API service")
+		job := services.NewRenewalJob(&config.Config{}, subscriptionRepo, batchRepo, windowRepo, storeApiService)
+
+		params, err := job.NormalizeParams(models.JSON(`{"subscription_ids": [` + strconv.FormatInt(subscription.ID, 10) + `]}`))
+		assert.NoError(t, err, "NormalizeParams should not return an error")
+		count, err := job.CountItems(context.Background(), params)
+		assert.NoError(t, err, "CountItems should not return an error")
+		assert.Equal(t, int64(1), count, "The legacy subscription should be due")
+
+		workerID := uuid.New().String()
+		_, err = workerRepo.RegisterWorker(workerID, 1, nil)
+		assert.NoError(t, err, "Failed to register worker")
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusProcessing, nil, "Claimed batch")
+		assert.NoError(t, err, "Failed to start worker")
+
+		action := models.ManagerAction{Type: services.JobTypeRenewalSweep, Params: params, Status: "running", TriggeredAt: time.Now()}
+		err = db.Create(&action).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+		batch := models.Batch{ActionID: action.ID, StartIndex: 1, EndIndex: count, Status: "processing", LockedBy: &workerID}
+		err = db.Create(&batch).Error
+		assert.NoError(t, err, "Failed to seed batch")
+
+		result, done, err := job.ProcessBatch(context.Background(), &action, &batch)
+		assert.NoError(t, err, "ProcessBatch should not return an error")
+		assert.True(t, done, "The batch should be done")
+		assert.Equal(t, int64(1), result.RenewedCount, "The legacy subscription should be renewed")
+		assert.Equal(t, int64(0), result.FailedCount, "The legacy status should not fail validation")
+
+		renewed, err := subscriptionRepo.GetSubscriptionByID(subscription.ID)
+		assert.NoError(t, err, "Failed to fetch subscription")
+		assert.Equal(t, models.SubscriptionStatusActive, renewed.Status, "The subscription should be active")
+		assert.True(t, renewedUntil.Equal(renewed.ExpireAt), "The store's expiry should be taken")
+
+		_, total, err := failureRepo.ListQuarantine(nil, 10, 0)
+		assert.NoError(t, err, "ListQuarantine should not return an error")
+		assert.Equal(t, int64(0), total, "Nothing should be quarantined")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke RenewalJob: %v", err)
+	}
+}
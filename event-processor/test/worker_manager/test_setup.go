@@ -4,9 +4,15 @@ import (
 	"context"
 	"event-processor/internal/config"
 	"event-processor/internal/models"
+	"event-processor/internal/pgnotify"
+	"event-processor/internal/rabbitmq"
 	"event-processor/internal/services"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
 
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -18,8 +24,12 @@ import (
 var (
 	Container   *dig.Container
 	pgContainer testcontainers.Container
+	databaseDSN string // DSN of the test database, for connections outside of gorm such as LISTEN
 )
 
+// schemaDir holds the SQL files the Postgres service of docker-compose.yaml is initialized with
+const schemaDir = "../../../init/postgresql"
+
 func SetupTest() {
 	Container = dig.New()
 
@@ -55,16 +65,16 @@ func SetupTest() {
 	}
 
 	// Connect to the PostgreSQL database
-	dsn := fmt.Sprintf("host=%s port=%s user=test password=test dbname=testdb sslmode=disable", host, port.Port())
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	databaseDSN = fmt.Sprintf("host=%s port=%s user=test password=test dbname=testdb sslmode=disable", host, port.Port())
+	db, err := gorm.Open(postgres.Open(databaseDSN), &gorm.Config{})
 	if err != nil {
 		log.Fatalf("Failed to connect to PostgreSQL test database: %v", err)
 	}
 
-	// Auto-migrate the database schema
-	err = db.AutoMigrate(&models.Subscription{}, &models.ManagerAction{}, &models.Batch{}, &models.Subscription{}, &models.Webhook{})
-	if err != nil {
-		log.Fatalf("Failed to auto-migrate database: %v", err)
+	// Create the schema Postgres is initialized with instead of migrating the models, so tests run
+	// against the real tables, partitions and triggers
+	if err := execSQLFile(db, "00_init.sql"); err != nil {
+		log.Fatalf("Failed to create the database schema: %v", err)
 	}
 
 	// Provide dependencies to the DI container
@@ -76,12 +86,66 @@ func SetupTest() {
 	Container.Provide(models.NewWebhookRepository)
 	Container.Provide(services.NewWebhookService)
 	Container.Provide(models.NewManagerActionRepository)
+	Container.Provide(models.NewActionEventRepository)
 	Container.Provide(models.NewBatchRepository)
 	Container.Provide(services.NewWorkerManagerService)
 	Container.Provide(models.NewSubscriptionRepository)
 	Container.Provide(models.NewWorkerRepository)
+	Container.Provide(models.NewWorkerCommandRepository)
+	Container.Provide(models.NewWorkerEventRepository)
+	Container.Provide(models.NewSubscriptionFailureRepository)
+	Container.Provide(models.NewSubscriptionHistoryRepository)
+	Container.Provide(models.NewValidationWindowRepository)
+	Container.Provide(models.NewReconciliationRepository)
 	Container.Provide(services.NewWorkerService)
 	Container.Provide(services.NewStoreApiService)
+	Container.Provide(services.NewRenewalJob)
+	Container.Provide(services.NewReconciliationJob)
+	Container.Provide(models.NewOutboxRepository)
+	Container.Provide(rabbitmq.NewPublisher)
+	Container.Provide(services.NewOutboxRelay)
+	Container.Provide(services.NewJobRegistry)
+	Container.Provide(models.NewScheduleRepository)
+	Container.Provide(services.NewSchedulerService)
+	Container.Provide(models.NewAuditLogRepository)
+	Container.Provide(models.NewRevokedSessionRepository)
+	Container.Provide(pgnotify.NewListener)
+	Container.Provide(services.NewDashboardHub)
+	Container.Provide(services.NewScalingService)
+	Container.Provide(models.NewLeaderLeaseRepository)
+}
+
+// ResetDatabase empties every table, restarts its IDs and seeds the apps 1 to 4 subscriptions refer to.
+// Tests share one database, so each test calls it first to start from the seeded schema instead of the
+// rows of the tests that ran before it.
+func ResetDatabase(tb testing.TB) {
+	tb.Helper()
+
+	err := Container.Invoke(func(db *gorm.DB) error {
+		var tables []string
+		err := db.Raw(`SELECT quote_ident(c.relname) FROM pg_class c
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE n.nspname = 'public' AND c.relkind IN ('r', 'p') AND NOT c.relispartition`).Scan(&tables).Error
+		if err != nil {
+			return err
+		}
+		if err := db.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE").Error; err != nil {
+			return err
+		}
+		return execSQLFile(db, "01_apps_seeds.sql")
+	})
+	if err != nil {
+		tb.Fatalf("Failed to reset the test database: %v", err)
+	}
+}
+
+// execSQLFile runs the statements of a file in schemaDir
+func execSQLFile(db *gorm.DB, name string) error {
+	sql, err := os.ReadFile(filepath.Join(schemaDir, name))
+	if err != nil {
+		return err
+	}
+	return db.Exec(string(sql)).Error
 }
 
 func TearDownTest() {
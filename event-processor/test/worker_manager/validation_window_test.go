@@ -0,0 +1,91 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestValidationWindow_Due(t *testing.T) {
+	ResetDatabase(t)
+
+	now := time.Now()
+	window := models.ValidationWindow{ValidateBefore: 3600, RecheckInterval: 1800, GraceRecheckInterval: 300, GiveUpAfter: 86400}
+
+	// Step 1: Subscriptions are due from ValidateBefore ahead of their expiry until GiveUpAfter past it
+	assert.True(t, window.Due(now.Add(30*time.Minute), now), "Subscriptions expiring within the lead time should be due")
+	assert.False(t, window.Due(now.Add(2*time.Hour), now), "Subscriptions expiring later should not be due")
+	assert.False(t, window.Due(now.AddDate(0, 0, -2), now), "Subscriptions expired too long ago should be given up")
+	assert.True(t, models.DefaultValidationWindow.Due(now.AddDate(-1, 0, 0), now), "The default window should never give up")
+
+	// Step 2: Payments being retried are checked more often
+	assert.Equal(t, 5*time.Minute, window.RecheckAfter(models.SubscriptionStatusBillingRetry), "Grace states should use the grace interval")
+	assert.Equal(t, 30*time.Minute, window.RecheckAfter(models.SubscriptionStatusActive), "Other states should use the re-check interval")
+
+	// Step 3: An explicit expire_to replaces the window
+	to := now.Add(2 * time.Hour)
+	assert.True(t, models.SubscriptionFilter{ExpireTo: &to}.Due(window, now.Add(90*time.Minute), now), "The filter bound should win over the window")
+}
+
+func TestValidationWindowRepository_Windows(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, subscriptionRepo *models.SubscriptionRepository, windowRepo *models.ValidationWindowRepository) {
+		// Step 1: Seed subscriptions of one app around their expiry, last checked past the re-check interval
+		checkedAt := time.Now().Add(-time.Hour)
+		subscriptions := []models.Subscription{
+			{UID: uuid.New().String(), AppID: 3, Receipt: "window", Status: models.SubscriptionStatusActive, ExpireAt: time.Now().Add(2 * time.Hour), UpdatedAt: checkedAt},
+			{UID: uuid.New().String(), AppID: 3, Receipt: "window", Status: models.SubscriptionStatusActive, ExpireAt: time.Now().Add(-time.Hour), UpdatedAt: checkedAt},
+			{UID: uuid.New().String(), AppID: 3, Receipt: "window", Status: models.SubscriptionStatusExpired, ExpireAt: time.Now().AddDate(0, 0, -10), UpdatedAt: checkedAt},
+		}
+		err := db.Create(&subscriptions).Error
+		assert.NoError(t, err, "Failed to seed subscriptions")
+
+		filter := models.SubscriptionFilter{AppIDs: []int{3}}
+		count, err := subscriptionRepo.GetCountForProcessing(filter)
+		assert.NoError(t, err, "GetCountForProcessing should not return an error")
+		assert.Equal(t, int64(2), count, "The default window should only match expired subscriptions")
+
+		// Step 2: A window of the app validates ahead of the expiry and gives up on old ones
+		_, err = windowRepo.SaveWindow(models.ValidationWindow{AppID: 3, ValidateBefore: 3 * 3600, RecheckInterval: 600, GraceRecheckInterval: 60, GiveUpAfter: 5 * 86400})
+		assert.NoError(t, err, "SaveWindow should not return an error")
+
+		due, err := subscriptionRepo.FetchSubscriptionsAfterKey(filter, 0, 0, 10)
+		assert.NoError(t, err, "FetchSubscriptionsAfterKey should not return an error")
+		if assert.Len(t, due, 2, "The window should decide which subscriptions are due") {
+			assert.Equal(t, subscriptions[0].ID, due[0].ID, "The subscription about to expire should be due")
+			assert.Equal(t, subscriptions[1].ID, due[1].ID, "The recently expired subscription should be due")
+		}
+
+		err = db.First(&models.SubscriptionDue{}, "subscription_id = ?", subscriptions[2].ID).Error
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "The subscription the window gave up on should leave the due queue")
+
+		// Step 3: Windows are looked up per app, apps without one get the default
+		windows, err := windowRepo.GetWindows([]int{3, 4})
+		assert.NoError(t, err, "GetWindows should not return an error")
+		assert.Equal(t, int64(600), windows[3].RecheckInterval, "The saved window should be returned")
+		assert.Equal(t, models.DefaultValidationWindow.RecheckInterval, windows[4].RecheckInterval, "Other apps should get the default")
+		assert.Equal(t, 4, windows[4].AppID, "The default should carry the app")
+
+		// Step 4: Invalid windows are rejected, deleted ones fall back to the default
+		_, err = windowRepo.SaveWindow(models.ValidationWindow{AppID: 3, RecheckInterval: 0, GraceRecheckInterval: 60})
+		assert.ErrorIs(t, err, models.ErrInvalidValidationWindow, "A zero re-check interval should be rejected")
+
+		err = windowRepo.DeleteWindow(3)
+		assert.NoError(t, err, "DeleteWindow should not return an error")
+		count, err = subscriptionRepo.GetCountForProcessing(filter)
+		assert.NoError(t, err, "GetCountForProcessing should not return an error")
+		assert.Equal(t, int64(2), count, "The default window should apply again")
+
+		err = db.First(&models.SubscriptionDue{}, "subscription_id = ?", subscriptions[2].ID).Error
+		assert.NoError(t, err, "The default window should put the subscription back in the due queue")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke ValidationWindowRepository: %v", err)
+	}
+}
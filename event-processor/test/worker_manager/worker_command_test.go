@@ -0,0 +1,113 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestWorkerManager_SendWorkerCommand(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(workerRepo *models.WorkerRepository, commandRepo *models.WorkerCommandRepository, workerManagerService *services.WorkerManagerService) {
+		// Step 1: Register a worker
+		workerID := uuid.New().String()
+		_, err := workerRepo.RegisterWorker(workerID, 1, nil)
+		assert.NoError(t, err, "Failed to register worker")
+
+		// Step 2: Invalid commands are rejected
+		_, err = workerManagerService.SendWorkerCommand(workerID, "explode", nil, "tester")
+		assert.ErrorIs(t, err, services.ErrInvalidWorkerCommand, "Unknown commands should be rejected")
+		_, err = workerManagerService.SendWorkerCommand(workerID, models.WorkerCommandSetConcurrency, nil, "tester")
+		assert.ErrorIs(t, err, services.ErrInvalidWorkerCommand, "set_concurrency without a value should be rejected")
+		_, err = workerManagerService.SendWorkerCommand(uuid.New().String(), models.WorkerCommandDrain, nil, "tester")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "Commands to unknown workers should be rejected")
+
+		// Step 3: A valid command is queued for the worker
+		concurrency := 4
+		command, err := workerManagerService.SendWorkerCommand(workerID, models.WorkerCommandSetConcurrency, &concurrency, "tester")
+		assert.NoError(t, err, "SendWorkerCommand should not return an error")
+		assert.Equal(t, "pending", command.Status, "The command should be pending")
+
+		pending, err := commandRepo.GetPendingCommands(workerID)
+		assert.NoError(t, err, "GetPendingCommands should not return an error")
+		assert.Len(t, pending, 1, "The worker should see one pending command")
+
+		// Step 4: The worker acknowledges and finishes the command
+		assert.NoError(t, commandRepo.AcknowledgeCommand(command.ID), "AcknowledgeCommand should not return an error")
+		assert.NoError(t, commandRepo.FinishCommand(command.ID, "completed", "Concurrency set to 4"), "FinishCommand should not return an error")
+
+		worker, err := workerManagerService.GetWorker(workerID, 10)
+		assert.NoError(t, err, "GetWorker should not return an error")
+		assert.Len(t, worker.RecentCommands, 1, "The worker should list its command")
+		assert.Equal(t, "completed", worker.RecentCommands[0].Status, "The command should be completed")
+		assert.NotNil(t, worker.RecentCommands[0].AcknowledgedAt, "The acknowledgement should be recorded")
+
+		// Step 5: Stopped workers take no commands
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusStopped, nil, "Shut down")
+		assert.NoError(t, err, "Failed to stop worker")
+		_, err = workerManagerService.SendWorkerCommand(workerID, models.WorkerCommandResume, nil, "tester")
+		assert.ErrorIs(t, err, services.ErrWorkerNotRunning, "Commands to stopped workers should be rejected")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke WorkerManagerService: %v", err)
+	}
+}
+
+func TestWorkerManager_FailCommandsOfDeadWorkers(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, workerRepo *models.WorkerRepository, commandRepo *models.WorkerCommandRepository, workerManagerService *services.WorkerManagerService) {
+		// Step 1: Register two workers with a completed, an acknowledged and a pending command each
+		commands := map[string][]*models.WorkerCommand{}
+		staleWorkerID, stoppedWorkerID := uuid.New().String(), uuid.New().String()
+		for _, workerID := range []string{staleWorkerID, stoppedWorkerID} {
+			_, err := workerRepo.RegisterWorker(workerID, 1, nil)
+			assert.NoError(t, err, "Failed to register worker")
+
+			for _, command := range []string{models.WorkerCommandPause, models.WorkerCommandResume, models.WorkerCommandDrain} {
+				sent, err := workerManagerService.SendWorkerCommand(workerID, command, nil, "tester")
+				assert.NoError(t, err, "SendWorkerCommand should not return an error")
+				commands[workerID] = append(commands[workerID], sent)
+			}
+			assert.NoError(t, commandRepo.AcknowledgeCommand(commands[workerID][0].ID), "AcknowledgeCommand should not return an error")
+			assert.NoError(t, commandRepo.FinishCommand(commands[workerID][0].ID, "completed", "Paused"), "FinishCommand should not return an error")
+			assert.NoError(t, commandRepo.AcknowledgeCommand(commands[workerID][1].ID), "AcknowledgeCommand should not return an error")
+		}
+
+		// Step 2: The manager marks a worker without heartbeats stale and another one stops
+		err := db.Model(&models.Worker{}).Where("worker_id = ?", staleWorkerID).Update("last_heartbeat", time.Now().Add(-2*time.Hour)).Error
+		assert.NoError(t, err, "Failed to age the heartbeat")
+		_, err = workerRepo.SetStaleWorkers(time.Hour)
+		assert.NoError(t, err, "SetStaleWorkers should not return an error")
+		_, err = workerRepo.TransitionWorker(stoppedWorkerID, models.WorkerStatusStopped, nil, "Shut down")
+		assert.NoError(t, err, "Failed to stop worker")
+
+		// Step 3: Open commands of both workers failed, finished ones are kept
+		for _, workerID := range []string{staleWorkerID, stoppedWorkerID} {
+			ids := []int64{commands[workerID][0].ID, commands[workerID][1].ID, commands[workerID][2].ID}
+			stored, err := commandRepo.GetCommandsByIDs(ids)
+			assert.NoError(t, err, "GetCommandsByIDs should not return an error")
+			if assert.Len(t, stored, 3, "Every command should be stored") {
+				assert.Equal(t, "completed", stored[0].Status, "Finished commands should be kept")
+				assert.Equal(t, "failed", stored[1].Status, "Acknowledged commands should fail")
+				assert.Equal(t, "failed", stored[2].Status, "Pending commands should fail")
+				assert.NotNil(t, stored[2].FinishedAt, "Failed commands should be finished")
+			}
+
+			pending, err := commandRepo.GetPendingCommands(workerID)
+			assert.NoError(t, err, "GetPendingCommands should not return an error")
+			assert.Empty(t, pending, "No command should be left pending")
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke WorkerManagerService: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestWorkerManager_UnroutableBatches(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, batchRepo *models.BatchRepository, workerRepo *models.WorkerRepository, workerManagerService *services.WorkerManagerService) {
+		// Step 1: Labels are parsed from the worker configuration
+		labels, err := models.ParseLabels("store=label_test, apps=2|1")
+		assert.NoError(t, err, "ParseLabels should not return an error")
+		assert.Equal(t, models.Labels{"store": {"label_test"}, "apps": {"1", "2"}}, labels, "Values should be split and sorted")
+		assert.True(t, labels.Satisfies(models.Labels{"apps": {"1"}}), "A subset of the values should be satisfied")
+		assert.False(t, labels.Satisfies(models.Labels{"region": {"eu"}}), "Missing labels should not be satisfied")
+		_, err = models.ParseLabels("store")
+		assert.Error(t, err, "Labels without a value should be rejected")
+
+		// Step 2: Seed pending batches that need a label no worker has
+		action := models.ManagerAction{Type: "labels_test", Status: "running", TriggeredAt: time.Now()}
+		err = db.Create(&action).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+		err = batchRepo.CreateBatches(action.ID, models.SplitRange(4, 2), models.Labels{"store": {"label_test"}})
+		assert.NoError(t, err, "Failed to seed batches")
+
+		groups, err := workerManagerService.GetUnroutableBatches()
+		assert.NoError(t, err, "GetUnroutableBatches should not return an error")
+		assert.Contains(t, groups, models.PendingLabelGroup{ActionID: action.ID, RequiredLabels: models.Labels{"store": {"label_test"}}, BatchCount: 2}, "The batches should have no eligible worker")
+
+		// Step 3: A started worker with the label makes them routable
+		workerID := uuid.New().String()
+		_, err = workerRepo.RegisterWorker(workerID, 1, labels)
+		assert.NoError(t, err, "Failed to register worker")
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusIdle, nil, "Started")
+		assert.NoError(t, err, "Failed to start worker")
+
+		groups, err = workerManagerService.GetUnroutableBatches()
+		assert.NoError(t, err, "GetUnroutableBatches should not return an error")
+		for _, group := range groups {
+			assert.NotEqual(t, action.ID, group.ActionID, "The batches should have an eligible worker")
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke WorkerManagerService: %v", err)
+	}
+}
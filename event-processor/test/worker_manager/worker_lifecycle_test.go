@@ -0,0 +1,85 @@
+package workermanager
+
+import (
+	"event-processor/internal/models"
+	"event-processor/internal/services"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestWorkerManager_WorkerLifecycle(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, workerRepo *models.WorkerRepository, workerManagerService *services.WorkerManagerService) {
+		// Step 1: A new worker starts as registering and moves to idle
+		workerID := uuid.New().String()
+		worker, err := workerRepo.RegisterWorker(workerID, 1, nil)
+		assert.NoError(t, err, "Failed to register worker")
+		assert.Equal(t, models.WorkerStatusRegistering, worker.Status, "A new worker should be registering")
+
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusIdle, nil, "Started")
+		assert.NoError(t, err, "registering to idle should be allowed")
+
+		// Step 2: Transitions outside the lifecycle are rejected
+		_, err = workerRepo.TransitionWorker(workerID, "exploded", nil, "Invalid")
+		assert.ErrorIs(t, err, models.ErrInvalidWorkerTransition, "Unknown statuses should be rejected")
+		assert.False(t, models.CanTransitionWorker(models.WorkerStatusProcessing, models.WorkerStatusDrained), "A worker should drain before it is drained")
+		assert.True(t, models.CanTransitionWorker(models.WorkerStatusStale, models.WorkerStatusIdle), "A stale worker that reports again should recover")
+
+		// Step 3: A worker without heartbeats is marked stale
+		err = db.Model(&models.Worker{}).Where("worker_id = ?", workerID).Update("last_heartbeat", time.Now().Add(-2*time.Hour)).Error
+		assert.NoError(t, err, "Failed to age the heartbeat")
+
+		staleWorkers, err := workerRepo.SetStaleWorkers(time.Hour)
+		assert.NoError(t, err, "SetStaleWorkers should not return an error")
+		assert.True(t, containsWorker(staleWorkers, workerID), "The worker should be marked stale")
+
+		// Step 4: Stopped workers stay stopped
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusStopped, nil, "Shut down")
+		assert.NoError(t, err, "stale to stopped should be allowed")
+		_, err = workerRepo.TransitionWorker(workerID, models.WorkerStatusIdle, nil, "Restarted")
+		assert.ErrorIs(t, err, models.ErrInvalidWorkerTransition, "A stopped worker should not come back")
+
+		staleWorkers, err = workerRepo.SetStaleWorkers(time.Hour)
+		assert.NoError(t, err, "SetStaleWorkers should not return an error")
+		assert.False(t, containsWorker(staleWorkers, workerID), "Stopped workers should not be marked stale")
+
+		// Step 5: Every status change is in the history, newest first
+		events, err := workerManagerService.ListWorkerEvents(workerID, 10)
+		assert.NoError(t, err, "ListWorkerEvents should not return an error")
+		statuses := []string{}
+		for _, event := range events {
+			statuses = append(statuses, event.ToStatus)
+		}
+		assert.Equal(t, []string{models.WorkerStatusStopped, models.WorkerStatusStale, models.WorkerStatusIdle, models.WorkerStatusRegistering}, statuses, "The history should list each transition")
+
+		// Step 6: Dead workers are pruned, their history is kept
+		pruned, err := workerRepo.PruneWorkers(time.Now().Add(-time.Hour))
+		assert.NoError(t, err, "PruneWorkers should not return an error")
+		assert.GreaterOrEqual(t, pruned, int64(1), "The stopped worker should be pruned")
+
+		_, err = workerRepo.GetWorkerByWorkerID(workerID)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "The worker row should be deleted")
+
+		events, err = workerManagerService.ListWorkerEvents(workerID, 10)
+		assert.NoError(t, err, "ListWorkerEvents should not return an error")
+		assert.Len(t, events, 4, "The history should outlive the worker")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke WorkerManagerService: %v", err)
+	}
+}
+
+func containsWorker(workers []models.Worker, workerID string) bool {
+	for _, worker := range workers {
+		if worker.WorkerID == workerID {
+			return true
+		}
+	}
+	return false
+}
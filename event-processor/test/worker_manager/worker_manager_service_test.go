@@ -17,12 +17,15 @@ func init() {
 }
 
 func TestWorkerManager_HandleTrigger(t *testing.T) {
+	ResetDatabase(t)
+
 	err := Container.Invoke(func(db *gorm.DB, workerManagerService *services.WorkerManagerService, subscriptionRepo *models.SubscriptionRepository, managerRepo *models.ManagerActionRepository, batchRepo *models.BatchRepository) {
-		// Step 1: Seed test data for subscriptions
+		// Step 1: Seed test data for subscriptions, last checked past the re-check interval
+		checkedAt := time.Now().Add(-time.Hour)
 		subscriptions := []models.Subscription{
-			{ID: 1, Status: "active", UID: uuid.New().String(), ExpireAt: time.Now().AddDate(0, -1, 0)},
-			{ID: 2, Status: "active", UID: uuid.New().String(), ExpireAt: time.Now().AddDate(0, -1, 0)},
-			{ID: 3, Status: "active", UID: uuid.New().String(), ExpireAt: time.Now().AddDate(0, -1, 0)},
+			{ID: 1, AppID: 1, Status: "active", UID: uuid.New().String(), ExpireAt: time.Now().AddDate(0, -1, 0), UpdatedAt: checkedAt},
+			{ID: 2, AppID: 1, Status: "active", UID: uuid.New().String(), ExpireAt: time.Now().AddDate(0, -1, 0), UpdatedAt: checkedAt},
+			{ID: 3, AppID: 1, Status: "active", UID: uuid.New().String(), ExpireAt: time.Now().AddDate(0, -1, 0), UpdatedAt: checkedAt},
 		}
 		err := db.Create(&subscriptions).Error
 		assert.NoError(t, err, "Failed to seed subscriptions")
@@ -33,7 +36,7 @@ func TestWorkerManager_HandleTrigger(t *testing.T) {
 
 		// Step 3: Validate results in the manager actions table
 		var managerAction models.ManagerAction
-		err = db.First(&managerAction).Error
+		err = db.Where("type = ?", services.JobTypeRenewalSweep).First(&managerAction).Error
 		assert.NoError(t, err, "Manager action should be created")
 		assert.Equal(t, int64(3), managerAction.ExpectedCount, "ExpectedCount should match the number of subscriptions")
 		assert.Equal(t, int64(3), managerAction.WillBeProcessedCount, "WillBeProcessedCount should match the number of subscriptions")
@@ -50,6 +53,10 @@ func TestWorkerManager_HandleTrigger(t *testing.T) {
 		var managerActionCount int64
 		db.Model(&models.ManagerAction{}).Count(&managerActionCount)
 		assert.Equal(t, int64(1), managerActionCount, "There should still only be one manager action")
+
+		// Step 6: The database rejects a second active action of the type, e.g. from a deposed leader
+		_, err = managerRepo.CreateNewAction(services.JobTypeRenewalSweep, nil, 3, 3, 3, models.SplitRange(3, 1), nil)
+		assert.ErrorIs(t, err, models.ErrActiveActionExists, "A second active action should be rejected")
 	})
 
 	if err != nil {
@@ -58,6 +65,8 @@ func TestWorkerManager_HandleTrigger(t *testing.T) {
 }
 
 func TestWorkerManager_Heartbeat(t *testing.T) {
+	ResetDatabase(t)
+
 	err := Container.Invoke(func(db *gorm.DB, workerManagerService *services.WorkerManagerService, managerRepo *models.ManagerActionRepository, batchRepo *models.BatchRepository) {
 		// Step 1: Seed test data for manager actions and batches
 		managerAction := models.ManagerAction{
@@ -95,3 +104,263 @@ func TestWorkerManager_Heartbeat(t *testing.T) {
 		t.Fatalf("Failed to invoke WorkerManagerService: %v", err)
 	}
 }
+
+func TestWorkerManager_CancelAction(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, workerManagerService *services.WorkerManagerService, batchRepo *models.BatchRepository) {
+		// Step 1: Seed a paused action with pending batches
+		managerAction := models.ManagerAction{
+			Status:        "paused",
+			ExpectedCount: 2,
+			TriggeredAt:   time.Now(),
+		}
+		err := db.Create(&managerAction).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+
+		err = batchRepo.CreateBatches(managerAction.ID, models.SplitRange(2, 1), nil)
+		assert.NoError(t, err, "Failed to seed batches")
+
+		// Step 2: Paused actions cannot be paused again
+		_, err = workerManagerService.PauseAction(managerAction.ID, "tester", "already paused")
+		assert.ErrorIs(t, err, models.ErrActionStatusConflict, "Pausing a paused action should conflict")
+
+		// Step 3: Cancel the action
+		action, err := workerManagerService.CancelAction(managerAction.ID, "tester", "store incident")
+		assert.NoError(t, err, "CancelAction should not return an error")
+		assert.Equal(t, "canceled", action.Status, "Action should be canceled")
+
+		// Step 4: Validate pending batches were canceled
+		var pendingCount int64
+		db.Model(&models.Batch{}).Where("action_id = ? AND status = ?", managerAction.ID, "pending").Count(&pendingCount)
+		assert.Equal(t, int64(0), pendingCount, "No pending batches should be left")
+
+		// Step 5: Validate the change was recorded
+		events, err := workerManagerService.GetActionEvents(managerAction.ID)
+		assert.NoError(t, err, "Action events should be fetched")
+		assert.Equal(t, 1, len(events), "One status change should be recorded")
+		assert.Equal(t, "tester", events[0].Actor, "Actor should be recorded")
+		assert.Equal(t, "store incident", events[0].Reason, "Reason should be recorded")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke WorkerManagerService: %v", err)
+	}
+}
+
+func TestWorkerManager_HeartbeatProgress(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, workerManagerService *services.WorkerManagerService, batchRepo *models.BatchRepository) {
+		// Step 1: Seed a running action with one completed and one stale batch
+		managerAction := models.ManagerAction{
+			Status:               "running",
+			ExpectedCount:        4,
+			WillBeProcessedCount: 4,
+			BatchCount:           2,
+			TriggeredAt:          time.Now(),
+		}
+		err := db.Create(&managerAction).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+
+		err = batchRepo.CreateBatches(managerAction.ID, models.SplitRange(4, 2), nil)
+		assert.NoError(t, err, "Failed to seed batches")
+
+		// Results are only recorded by the worker holding the batch
+		workerID := "progress-worker"
+		err = db.Model(&models.Batch{}).Where("action_id = ?", managerAction.ID).
+			Updates(map[string]interface{}{"status": "processing", "locked_by": workerID}).Error
+		assert.NoError(t, err, "Failed to lock batches")
+
+		var batches []models.Batch
+		err = db.Where("action_id = ?", managerAction.ID).Order("id").Find(&batches).Error
+		assert.NoError(t, err, "Failed to fetch batches")
+
+		err = batchRepo.RecordBatchResult(batches[0].ID, workerID, models.BatchResult{ProcessedCount: 2, RenewedCount: 1, ExpiredCount: 1})
+		assert.NoError(t, err, "Failed to record batch result")
+		assert.NoError(t, batchRepo.MarkBatchCompleted(batches[0].ID, workerID))
+
+		err = batchRepo.RecordBatchResult(batches[1].ID, workerID, models.BatchResult{ProcessedCount: 2, FailedCount: 2})
+		assert.NoError(t, err, "Failed to record batch result")
+		assert.NoError(t, batchRepo.MarkBatchAsStale(batches[1].ID))
+
+		// Step 2: Run Heartbeat for a few ticks
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		go workerManagerService.Heartbeat(ctx, 100*time.Millisecond)
+		<-ctx.Done()
+
+		// Step 3: Validate the counters and the final status
+		var updatedAction models.ManagerAction
+		err = db.First(&updatedAction, managerAction.ID).Error
+		assert.NoError(t, err, "Manager action should exist")
+		assert.Equal(t, "partially_failed", updatedAction.Status, "Action with a stale batch should be partially failed")
+		assert.Equal(t, 1, updatedAction.CompletedBatchCount, "One batch should be completed")
+		assert.Equal(t, 1, updatedAction.FailedBatchCount, "One batch should be stale")
+		assert.Equal(t, int64(4), updatedAction.ProcessedCount, "All items should be processed")
+		assert.Equal(t, int64(2), updatedAction.FailedCount, "Failures should be summed")
+		assert.NotNil(t, updatedAction.FinishedAt, "Finished time should be set")
+
+		// Step 4: Validate the manager recorded the final status change
+		events, err := workerManagerService.GetActionEvents(managerAction.ID)
+		assert.NoError(t, err, "Action events should be fetched")
+		if assert.Equal(t, 1, len(events), "The final status change should be recorded") {
+			assert.Equal(t, "running", events[0].FromStatus, "The change should start from running")
+			assert.Equal(t, "partially_failed", events[0].ToStatus, "The change should end in the final status")
+			assert.Equal(t, models.ActionActorManager, events[0].Actor, "The manager should be the actor")
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke WorkerManagerService: %v", err)
+	}
+}
+
+func TestWorkerManager_CanceledActionProgress(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, workerManagerService *services.WorkerManagerService, batchRepo *models.BatchRepository) {
+		// Step 1: Seed a running action with a processing and a pending batch, and cancel it
+		managerAction := models.ManagerAction{
+			Status:               "running",
+			ExpectedCount:        4,
+			WillBeProcessedCount: 4,
+			BatchCount:           2,
+			TriggeredAt:          time.Now(),
+		}
+		err := db.Create(&managerAction).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+
+		err = batchRepo.CreateBatches(managerAction.ID, models.SplitRange(4, 2), nil)
+		assert.NoError(t, err, "Failed to seed batches")
+
+		var batches []models.Batch
+		err = db.Where("action_id = ?", managerAction.ID).Order("id").Find(&batches).Error
+		assert.NoError(t, err, "Failed to fetch batches")
+		err = db.Model(&batches[0]).Updates(map[string]interface{}{"status": "processing", "locked_by": "canceled-progress-worker"}).Error
+		assert.NoError(t, err, "Failed to start batch")
+
+		_, err = workerManagerService.CancelAction(managerAction.ID, "tester", "store incident")
+		assert.NoError(t, err, "CancelAction should not return an error")
+
+		// Step 2: The processing batch finishes after the cancel
+		err = db.Model(&batches[0]).Updates(map[string]interface{}{"status": "completed", "processed_count": 2, "renewed_count": 2}).Error
+		assert.NoError(t, err, "Failed to complete batch")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		go workerManagerService.Heartbeat(ctx, 100*time.Millisecond)
+		<-ctx.Done()
+
+		// Step 3: Validate the progress of the finished batch was counted and the action stays canceled
+		var updatedAction models.ManagerAction
+		err = db.First(&updatedAction, managerAction.ID).Error
+		assert.NoError(t, err, "Manager action should exist")
+		assert.Equal(t, "canceled", updatedAction.Status, "The action should stay canceled")
+		assert.Equal(t, 1, updatedAction.CompletedBatchCount, "The batch finished after the cancel should be counted")
+		assert.Equal(t, int64(2), updatedAction.ProcessedCount, "The items processed after the cancel should be counted")
+
+		events, err := workerManagerService.GetActionEvents(managerAction.ID)
+		assert.NoError(t, err, "Action events should be fetched")
+		assert.Equal(t, 1, len(events), "Only the cancel should be recorded")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke WorkerManagerService: %v", err)
+	}
+}
+
+func TestWorkerManager_ListActions(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, workerManagerService *services.WorkerManagerService) {
+		// Step 1: Seed finished actions of a dedicated job type
+		jobType := "list_actions_test"
+		actions := []models.ManagerAction{
+			{Type: jobType, Status: "completed", TriggeredAt: time.Now().Add(-2 * time.Hour)},
+			{Type: jobType, Status: "failed", TriggeredAt: time.Now().Add(-1 * time.Hour)},
+			{Type: jobType, Status: "completed", TriggeredAt: time.Now()},
+		}
+		err := db.Create(&actions).Error
+		assert.NoError(t, err, "Failed to seed manager actions")
+
+		// Step 2: Completed actions are listed newest first and paged
+		page, total, err := workerManagerService.ListActions(models.ActionListFilter{
+			Type:     jobType,
+			Statuses: []string{"completed"},
+			Limit:    1,
+		})
+		assert.NoError(t, err, "ListActions should not return an error")
+		assert.Equal(t, int64(2), total, "Total should count every matching action")
+		assert.Len(t, page, 1, "Only one action should be returned")
+		assert.Equal(t, actions[2].ID, page[0].ID, "The newest action should come first")
+		assert.NotNil(t, page[0].Progress, "Progress should be calculated")
+
+		// Step 3: Filter by trigger time
+		from := time.Now().Add(-90 * time.Minute)
+		to := time.Now().Add(-30 * time.Minute)
+		page, total, err = workerManagerService.ListActions(models.ActionListFilter{
+			Type:          jobType,
+			TriggeredFrom: &from,
+			TriggeredTo:   &to,
+			Limit:         10,
+		})
+		assert.NoError(t, err, "ListActions should not return an error")
+		assert.Equal(t, int64(1), total, "Only the action triggered within the window should match")
+		assert.Equal(t, "failed", page[0].Status, "The failed action should be returned")
+
+		// Step 4: Unknown actions are not found
+		_, err = workerManagerService.GetAction(-1)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "GetAction should return a not found error")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke WorkerManagerService: %v", err)
+	}
+}
+
+func TestWorkerManager_ResumeAction(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, workerManagerService *services.WorkerManagerService, batchRepo *models.BatchRepository) {
+		// Step 1: Seed a paused action with pending batches
+		managerAction := models.ManagerAction{
+			Status:        "paused",
+			ExpectedCount: 2,
+			TriggeredAt:   time.Now(),
+		}
+		err := db.Create(&managerAction).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+
+		err = batchRepo.CreateBatches(managerAction.ID, models.SplitRange(2, 1), nil)
+		assert.NoError(t, err, "Failed to seed batches")
+
+		// Step 2: An action paused before any batch was claimed goes back to pending
+		action, err := workerManagerService.ResumeAction(managerAction.ID, "tester", "store is back")
+		assert.NoError(t, err, "ResumeAction should not return an error")
+		assert.Equal(t, "pending", action.Status, "Action without started batches should be pending")
+
+		_, err = workerManagerService.ResumeAction(managerAction.ID, "tester", "store is back")
+		assert.ErrorIs(t, err, models.ErrActionStatusConflict, "Resuming an action that is not paused should conflict")
+
+		// Step 3: An action paused after a batch was claimed goes back to running
+		_, err = workerManagerService.PauseAction(managerAction.ID, "tester", "store incident")
+		assert.NoError(t, err, "PauseAction should not return an error")
+		err = db.Model(&models.Batch{}).Where("action_id = ? AND start_index = ?", managerAction.ID, 1).Update("status", "completed").Error
+		assert.NoError(t, err, "Failed to complete batch")
+
+		action, err = workerManagerService.ResumeAction(managerAction.ID, "tester", "store is back")
+		assert.NoError(t, err, "ResumeAction should not return an error")
+		assert.Equal(t, "running", action.Status, "Action with started batches should be running")
+
+		events, err := workerManagerService.GetActionEvents(managerAction.ID)
+		assert.NoError(t, err, "Action events should be fetched")
+		assert.Equal(t, 3, len(events), "Every status change should be recorded")
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke WorkerManagerService: %v", err)
+	}
+}
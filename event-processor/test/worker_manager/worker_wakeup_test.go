@@ -0,0 +1,147 @@
+package workermanager
+
+import (
+	"context"
+	"event-processor/internal/config"
+	"event-processor/internal/models"
+	"event-processor/internal/pgnotify"
+	"event-processor/internal/services"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// wakeupTestJob is registryTestJob under its own job type
+type wakeupTestJob struct {
+	registryTestJob
+}
+
+func (j *wakeupTestJob) Type() string {
+	return "wakeup_test"
+}
+
+func TestWorkerService_WakesOnNotify(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, workerRepo *models.WorkerRepository, batchRepo *models.BatchRepository, managerRepo *models.ManagerActionRepository, commandRepo *models.WorkerCommandRepository, jobRegistry *services.JobRegistry, workerManagerService *services.WorkerManagerService) {
+		// Step 1: Start an idle worker that would only poll for batches after a minute
+		jobRegistry.Register(&wakeupTestJob{})
+		listener := pgnotify.NewListener(&config.Config{DatabaseDSN: databaseDSN})
+		worker, err := services.NewWorkerService(&config.Config{WorkerPollInterval: 60, WorkerConcurrency: 1}, workerRepo, batchRepo, managerRepo, commandRepo, jobRegistry, listener)
+		assert.NoError(t, err, "Failed to create worker")
+
+		var once sync.Once
+		connected := make(chan struct{})
+		listener.OnConnect(func() { once.Do(func() { close(connected) }) })
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go listener.Run(ctx)
+		select {
+		case <-connected:
+		case <-time.After(10 * time.Second):
+			t.Fatal("The listener should connect")
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			worker.Start()
+			close(stopped)
+		}()
+
+		var registered models.Worker
+		assert.Eventually(t, func() bool {
+			return db.Where("status = ?", "idle").First(&registered).Error == nil
+		}, 10*time.Second, 50*time.Millisecond, "The worker should become idle")
+		time.Sleep(500 * time.Millisecond) // Let the worker find no batch and start waiting
+
+		// Step 2: New batches are announced with NOTIFY batches_available and picked up long before the poll interval
+		action := models.ManagerAction{Type: "wakeup_test", Status: "pending", TriggeredAt: time.Now()}
+		err = db.Create(&action).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+		createdAt := time.Now()
+		err = batchRepo.CreateBatches(action.ID, models.SplitRange(1, 1), nil)
+		assert.NoError(t, err, "Failed to seed batches")
+
+		assert.Eventually(t, func() bool {
+			var batch models.Batch
+			return db.Where("action_id = ? AND status = ?", action.ID, "completed").First(&batch).Error == nil
+		}, 5*time.Second, 50*time.Millisecond, "The worker should process the batch once notified")
+		assert.Less(t, time.Since(createdAt), 10*time.Second, "The worker should not wait for the poll interval")
+
+		// Step 3: Shut the worker down through a command, which is announced the same way
+		_, err = workerManagerService.SendWorkerCommand(registered.WorkerID, models.WorkerCommandShutdown, nil, "tester")
+		assert.NoError(t, err, "SendWorkerCommand should not return an error")
+		select {
+		case <-stopped:
+		case <-time.After(10 * time.Second):
+			t.Fatal("The worker should shut down")
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke WorkerService: %v", err)
+	}
+}
+
+func TestBatchesAvailable_Notify(t *testing.T) {
+	ResetDatabase(t)
+
+	err := Container.Invoke(func(db *gorm.DB, batchRepo *models.BatchRepository, workerManagerService *services.WorkerManagerService) {
+		// Step 1: Listen on batches_available like idle workers do
+		listener := pgnotify.NewListener(&config.Config{DatabaseDSN: databaseDSN})
+		payloads := make(chan string, 10)
+		listener.Handle(services.BatchesAvailableChannel, func(payload string) { payloads <- payload })
+
+		var once sync.Once
+		connected := make(chan struct{})
+		listener.OnConnect(func() { once.Do(func() { close(connected) }) })
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go listener.Run(ctx)
+		select {
+		case <-connected:
+		case <-time.After(10 * time.Second):
+			t.Fatal("The listener should connect")
+		}
+
+		// Step 2: New pending batches are announced once per action
+		action := models.ManagerAction{Type: "wakeup_test", Status: "pending", TriggeredAt: time.Now()}
+		err := db.Create(&action).Error
+		assert.NoError(t, err, "Failed to seed manager action")
+		err = batchRepo.CreateBatches(action.ID, models.SplitRange(3, 1), nil)
+		assert.NoError(t, err, "Failed to seed batches")
+
+		select {
+		case payload := <-payloads:
+			assert.Equal(t, strconv.FormatInt(action.ID, 10), payload, "The payload should be the action ID")
+		case <-time.After(5 * time.Second):
+			t.Fatal("New batches should be announced")
+		}
+		select {
+		case payload := <-payloads:
+			t.Fatalf("The batches of one insert should be announced once, got another %s", payload)
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		// Step 3: Resuming a paused action announces its batches again
+		_, err = workerManagerService.PauseAction(action.ID, "tester", "store incident")
+		assert.NoError(t, err, "PauseAction should not return an error")
+		_, err = workerManagerService.ResumeAction(action.ID, "tester", "store is back")
+		assert.NoError(t, err, "ResumeAction should not return an error")
+
+		select {
+		case payload := <-payloads:
+			assert.Equal(t, strconv.FormatInt(action.ID, 10), payload, "The payload should be the action ID")
+		case <-time.After(5 * time.Second):
+			t.Fatal("The batches of a resumed action should be announced")
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to invoke BatchRepository: %v", err)
+	}
+}
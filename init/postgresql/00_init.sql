@@ -38,6 +38,30 @@ CREATE TABLE subscriptions (
 CREATE TABLE subscriptions_p0 PARTITION OF subscriptions FOR VALUES WITH (MODULUS 2, REMAINDER 0);
 CREATE TABLE subscriptions_p1 PARTITION OF subscriptions FOR VALUES WITH (MODULUS 2, REMAINDER 1);
 
+-- Create subscriptions_due table, the next check of every subscription a sweep can still check, kept
+-- in sync by triggers. Sweeps count and read the due subscriptions through it instead of scanning
+-- subscriptions. Canceled subscriptions and the ones their app's window gave up on have no row.
+CREATE TABLE subscriptions_due (
+    subscription_id BIGINT PRIMARY KEY,
+    app_id INTEGER NOT NULL,
+    expire_at TIMESTAMPTZ NOT NULL,
+    due_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX subscriptions_due_due_at_idx ON subscriptions_due (due_at, subscription_id);
+CREATE INDEX subscriptions_due_expire_at_idx ON subscriptions_due (expire_at, subscription_id);
+
+-- Create app_validation_windows table, when sweeps re-validate the subscriptions of an app in seconds.
+-- Apps without a row re-validate once expired, at most every 30 minutes and without giving up
+CREATE TABLE app_validation_windows (
+    app_id INTEGER PRIMARY KEY REFERENCES apps (id) ON DELETE CASCADE,
+    validate_before BIGINT NOT NULL DEFAULT 0,
+    recheck_interval BIGINT NOT NULL DEFAULT 1800,
+    grace_recheck_interval BIGINT NOT NULL DEFAULT 1800,
+    give_up_after BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
 -- Create webhooks table
 CREATE TABLE webhooks (
     id BIGSERIAL PRIMARY KEY,
@@ -54,39 +78,461 @@ CREATE TABLE webhooks (
 -- Create manager_actions table
 CREATE TABLE manager_actions (
     id BIGSERIAL PRIMARY KEY,
+    type VARCHAR(50) NOT NULL DEFAULT 'renewal_sweep',
+    params JSONB DEFAULT NULL,
     expected_count BIGINT NOT NULL,
     will_be_processed_count BIGINT NOT NULL,
     max_batch INT NOT NULL,
     batch_count INT NOT NULL DEFAULT 0,
     completed_batch_count INT NOT NULL DEFAULT 0,
+    failed_batch_count INT NOT NULL DEFAULT 0,
+    processed_count BIGINT NOT NULL DEFAULT 0,
+    renewed_count BIGINT NOT NULL DEFAULT 0,
+    expired_count BIGINT NOT NULL DEFAULT 0,
+    failed_count BIGINT NOT NULL DEFAULT 0,
+    skipped_count BIGINT NOT NULL DEFAULT 0,
+    conflict_count BIGINT NOT NULL DEFAULT 0,
     triggered_at TIMESTAMPTZ NOT NULL,
+    started_at TIMESTAMPTZ DEFAULT NULL,
+    finished_at TIMESTAMPTZ DEFAULT NULL,
     status VARCHAR(20) DEFAULT 'pending',
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
 
+-- Only one action of a type can be active, so manager replicas racing to trigger it cannot both create one
+CREATE UNIQUE INDEX manager_actions_active_type_idx ON manager_actions (type) WHERE status IN ('pending', 'running', 'paused');
+
+-- Create action_events table
+CREATE TABLE action_events (
+    id BIGSERIAL PRIMARY KEY,
+    action_id BIGINT NOT NULL REFERENCES manager_actions (id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX action_events_action_id_idx ON action_events (action_id);
+
 -- Create batches table
 CREATE TABLE batches (
     id BIGSERIAL PRIMARY KEY,
     action_id BIGINT NOT NULL REFERENCES manager_actions (id) ON DELETE CASCADE,
     start_index BIGINT NOT NULL,
     end_index BIGINT NOT NULL,
+    after_key BIGINT NOT NULL DEFAULT 0,
+    end_key BIGINT NOT NULL DEFAULT 0,
     status VARCHAR(20) DEFAULT 'pending',
     try_count INT NOT NULL DEFAULT 0,
     locked_by UUID DEFAULT NULL,
     locked_at TIMESTAMPTZ DEFAULT NULL,
+    worker_id UUID DEFAULT NULL,
+    required_labels JSONB NOT NULL DEFAULT '{}',
+    processed_count BIGINT NOT NULL DEFAULT 0,
+    renewed_count BIGINT NOT NULL DEFAULT 0,
+    expired_count BIGINT NOT NULL DEFAULT 0,
+    failed_count BIGINT NOT NULL DEFAULT 0,
+    skipped_count BIGINT NOT NULL DEFAULT 0,
+    conflict_count BIGINT NOT NULL DEFAULT 0,
+    started_at TIMESTAMPTZ DEFAULT NULL,
+    finished_at TIMESTAMPTZ DEFAULT NULL,
+    checkpoint JSONB DEFAULT NULL,
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
 
+CREATE INDEX batches_worker_id_idx ON batches (worker_id);
+
 -- Create workers table
 CREATE TABLE workers (
     id BIGSERIAL PRIMARY KEY,
     worker_id UUID NOT NULL UNIQUE,
     status VARCHAR(20) NOT NULL,
+    concurrency INT NOT NULL DEFAULT 1,
+    labels JSONB NOT NULL DEFAULT '{}',
     last_heartbeat TIMESTAMPTZ,
     action_id BIGINT DEFAULT NULL REFERENCES manager_actions (id) ON DELETE SET NULL,
     current_batch_id BIGINT DEFAULT NULL REFERENCES batches (id) ON DELETE SET NULL,
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
+
+-- Create worker_commands table, commands sent from the manager to a single worker
+CREATE TABLE worker_commands (
+    id BIGSERIAL PRIMARY KEY,
+    worker_id UUID NOT NULL REFERENCES workers (worker_id) ON DELETE CASCADE,
+    command VARCHAR(20) NOT NULL,
+    concurrency INT DEFAULT NULL,
+    status VARCHAR(20) DEFAULT 'pending',
+    result TEXT NOT NULL DEFAULT '',
+    issued_by VARCHAR(255) NOT NULL,
+    acknowledged_at TIMESTAMPTZ DEFAULT NULL,
+    finished_at TIMESTAMPTZ DEFAULT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX worker_commands_worker_id_status_idx ON worker_commands (worker_id, status);
+
+-- Create worker_events table, the status history of workers. It has no foreign key so the
+-- history outlives pruned workers.
+CREATE TABLE worker_events (
+    id BIGSERIAL PRIMARY KEY,
+    worker_id UUID NOT NULL,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX worker_events_worker_id_idx ON worker_events (worker_id, id);
+CREATE INDEX worker_events_created_at_idx ON worker_events (created_at);
+
+-- Create subscription_failures table, subscriptions whose last attempt failed. Sweeps skip them
+-- until next_retry_at.
+CREATE TABLE subscription_failures (
+    subscription_id BIGINT PRIMARY KEY,
+    action_id BIGINT NOT NULL,
+    attempts INT NOT NULL DEFAULT 1,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_retry_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX subscription_failures_next_retry_at_idx ON subscription_failures (next_retry_at);
+
+-- Create quarantined_receipts table, receipts that failed too often and wait for a review
+CREATE TABLE quarantined_receipts (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL,
+    receipt VARCHAR(255) NOT NULL,
+    attempts INT NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL,
+    reviewed_by VARCHAR(255) DEFAULT NULL,
+    reviewed_at TIMESTAMPTZ DEFAULT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX quarantined_receipts_subscription_id_idx ON quarantined_receipts (subscription_id, status);
+CREATE INDEX quarantined_receipts_status_idx ON quarantined_receipts (status);
+
+-- Create subscription_history table, every status and expiry transition of a subscription. It is
+-- partitioned by month, the manager creates the partitions of the coming months.
+CREATE TABLE subscription_history (
+    id BIGSERIAL,
+    subscription_id BIGINT NOT NULL,
+    from_status VARCHAR(50) NOT NULL,
+    to_status VARCHAR(50) NOT NULL,
+    from_expire_at TIMESTAMPTZ NOT NULL,
+    to_expire_at TIMESTAMPTZ NOT NULL,
+    reason VARCHAR(50) NOT NULL DEFAULT '',
+    source VARCHAR(50) NOT NULL,
+    action_id BIGINT DEFAULT NULL,
+    batch_id BIGINT DEFAULT NULL,
+    response_hash VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX subscription_history_subscription_id_idx ON subscription_history (subscription_id, created_at);
+
+DO $$
+DECLARE
+    month DATE := date_trunc('month', NOW());
+BEGIN
+    FOR i IN 0..2 LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS subscription_history_%s PARTITION OF subscription_history FOR VALUES FROM (%L) TO (%L)',
+            to_char(month + make_interval(months => i), 'YYYY_MM'),
+            month + make_interval(months => i),
+            month + make_interval(months => i + 1)
+        );
+    END LOOP;
+END;
+$$;
+
+-- Transitions outside the monthly partitions, e.g. when the manager fell behind creating them. They are
+-- moved into the partition of their month once the manager creates it.
+CREATE TABLE subscription_history_default PARTITION OF subscription_history DEFAULT;
+
+-- Create reconciliation_discrepancies table, differences to the store found by reconciliations.
+-- Fixed rows were corrected according to the auto-fix policy of the action, the others are only reported.
+CREATE TABLE reconciliation_discrepancies (
+    id BIGSERIAL PRIMARY KEY,
+    action_id BIGINT NOT NULL,
+    batch_id BIGINT NOT NULL,
+    subscription_id BIGINT NOT NULL,
+    app_id INTEGER NOT NULL,
+    kind VARCHAR(30) NOT NULL,
+    local_status VARCHAR(20) NOT NULL,
+    store_status VARCHAR(20) NOT NULL DEFAULT '',
+    local_expire_at TIMESTAMPTZ NOT NULL,
+    store_expire_at TIMESTAMPTZ DEFAULT NULL,
+    fixed BOOLEAN NOT NULL DEFAULT FALSE,
+    response_hash VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX reconciliation_discrepancies_action_id_idx ON reconciliation_discrepancies (action_id);
+CREATE INDEX reconciliation_discrepancies_subscription_id_idx ON reconciliation_discrepancies (subscription_id);
+
+-- Create outbox table, messages written in the same transaction as the changes they announce
+-- and published by the outbox relay
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    queue VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ DEFAULT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX outbox_sent_at_idx ON outbox (sent_at) WHERE status = 'sent';
+
+-- Create schedules table
+CREATE TABLE schedules (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    job_type VARCHAR(50) NOT NULL,
+    cron_expr VARCHAR(100) DEFAULT NULL,
+    params JSONB DEFAULT NULL,
+    run_at TIMESTAMPTZ DEFAULT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMPTZ DEFAULT NULL,
+    last_run_at TIMESTAMPTZ DEFAULT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX schedules_next_run_at_idx ON schedules (next_run_at) WHERE enabled;
+
+-- Create schedule_runs table
+CREATE TABLE schedule_runs (
+    id BIGSERIAL PRIMARY KEY,
+    schedule_id BIGINT NOT NULL REFERENCES schedules (id) ON DELETE CASCADE,
+    scheduled_for TIMESTAMPTZ NOT NULL,
+    fired_at TIMESTAMPTZ NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    action_id BIGINT DEFAULT NULL REFERENCES manager_actions (id) ON DELETE SET NULL,
+    message TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Create leader_leases table
+CREATE TABLE leader_leases (
+    name VARCHAR(100) PRIMARY KEY,
+    holder_id VARCHAR(100) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    acquired_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Create audit_logs table
+CREATE TABLE audit_logs (
+    id BIGSERIAL PRIMARY KEY,
+    actor VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL,
+    auth_method VARCHAR(20) NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    path VARCHAR(1024) NOT NULL,
+    status_code INT NOT NULL,
+    remote_addr VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX audit_logs_actor_idx ON audit_logs (actor);
+
+-- Create revoked_sessions table, dashboard sessions logged out before they expired. Rows of expired
+-- sessions are deleted when the next session is revoked.
+CREATE TABLE revoked_sessions (
+    session_id VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Publish batch, action and worker changes to the worker manager dashboards
+CREATE FUNCTION notify_manager_change() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('manager_changes', json_build_object('table', TG_TABLE_NAME, 'id', NEW.id)::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER manager_actions_notify_change AFTER INSERT OR UPDATE ON manager_actions
+    FOR EACH ROW EXECUTE FUNCTION notify_manager_change();
+
+CREATE TRIGGER batches_notify_change AFTER INSERT OR UPDATE ON batches
+    FOR EACH ROW EXECUTE FUNCTION notify_manager_change();
+
+CREATE TRIGGER workers_notify_change AFTER INSERT OR UPDATE ON workers
+    FOR EACH ROW EXECUTE FUNCTION notify_manager_change();
+
+CREATE TRIGGER worker_commands_notify_change AFTER INSERT OR UPDATE ON worker_commands
+    FOR EACH ROW EXECUTE FUNCTION notify_manager_change();
+
+-- Wake idle workers when batches become claimable, the payload is the action ID so
+-- Postgres folds the notifications of one transaction into a single one per action
+CREATE FUNCTION notify_batches_available() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'batches' THEN
+        PERFORM pg_notify('batches_available', NEW.action_id::text);
+    ELSE
+        PERFORM pg_notify('batches_available', NEW.id::text);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER batches_notify_available AFTER INSERT OR UPDATE OF status ON batches
+    FOR EACH ROW WHEN (NEW.status = 'pending') EXECUTE FUNCTION notify_batches_available();
+
+CREATE TRIGGER manager_actions_notify_resumed AFTER UPDATE OF status ON manager_actions
+    FOR EACH ROW WHEN (OLD.status = 'paused' AND NEW.status IN ('pending', 'running'))
+    EXECUTE FUNCTION notify_batches_available();
+
+-- Tell a worker it has a new command, the payload is the worker ID
+CREATE FUNCTION notify_worker_command() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('worker_commands', NEW.worker_id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER worker_commands_notify_worker AFTER INSERT ON worker_commands
+    FOR EACH ROW EXECUTE FUNCTION notify_worker_command();
+
+-- Wake outbox relays when messages are written, once per statement
+CREATE FUNCTION notify_outbox_pending() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('outbox_pending', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_notify_pending AFTER INSERT ON outbox
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_outbox_pending();
+
+-- Next time a sweep checks a subscription: once it is within validate_before of its expiry and the re-check
+-- interval of its status passed since it was last written. NULL when no sweep checks it again, because it is
+-- canceled, has no expiry or expired longer than give_up_after ago. Mirrors ValidationWindow and
+-- DefaultValidationWindow.
+CREATE FUNCTION subscription_due_at(p_app_id INTEGER, p_status VARCHAR, p_expire_at TIMESTAMPTZ, p_updated_at TIMESTAMPTZ)
+RETURNS TIMESTAMPTZ AS $$
+DECLARE
+    lead_time BIGINT;
+    recheck BIGINT;
+    grace_recheck BIGINT;
+    give_up BIGINT;
+BEGIN
+    IF p_expire_at IS NULL OR p_status = 'canceled' THEN
+        RETURN NULL;
+    END IF;
+
+    -- The aggregates return the defaults for apps without a window
+    SELECT COALESCE(MAX(w.validate_before), 0), COALESCE(MAX(w.recheck_interval), 1800),
+        COALESCE(MAX(w.grace_recheck_interval), 1800), COALESCE(MAX(w.give_up_after), 0)
+    INTO lead_time, recheck, grace_recheck, give_up
+    FROM app_validation_windows w
+    WHERE w.app_id = p_app_id;
+
+    IF give_up > 0 AND p_expire_at < NOW() - give_up * INTERVAL '1 second' THEN
+        RETURN NULL;
+    END IF;
+
+    IF p_status IN ('grace_period', 'billing_retry', 'on_hold') THEN
+        recheck := grace_recheck;
+    END IF;
+    RETURN GREATEST(p_expire_at - lead_time * INTERVAL '1 second', p_updated_at + recheck * INTERVAL '1 second');
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- Recompute the due queue rows of subscriptions, used by sweeps for the subscriptions they skip and when
+-- the validation window of an app changes
+CREATE FUNCTION refresh_subscription_due(p_ids BIGINT[]) RETURNS void AS $$
+BEGIN
+    DELETE FROM subscriptions_due WHERE subscription_id = ANY (p_ids);
+    INSERT INTO subscriptions_due (subscription_id, app_id, expire_at, due_at)
+    SELECT id, app_id, expire_at, due_at FROM (
+        SELECT s.id, s.app_id, s.expire_at, subscription_due_at(s.app_id, s.status, s.expire_at, s.updated_at) AS due_at
+        FROM subscriptions s
+        WHERE s.id = ANY (p_ids)
+    ) recomputed
+    WHERE due_at IS NOT NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Keep subscriptions_due in sync with the subscriptions, whoever writes them
+CREATE FUNCTION sync_subscription_due() RETURNS trigger AS $$
+DECLARE
+    next_due TIMESTAMPTZ;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM subscriptions_due WHERE subscription_id = OLD.id;
+        RETURN NULL;
+    END IF;
+
+    next_due := subscription_due_at(NEW.app_id, NEW.status, NEW.expire_at, NEW.updated_at);
+    IF next_due IS NULL THEN
+        DELETE FROM subscriptions_due WHERE subscription_id = NEW.id;
+    ELSE
+        INSERT INTO subscriptions_due (subscription_id, app_id, expire_at, due_at)
+        VALUES (NEW.id, NEW.app_id, NEW.expire_at, next_due)
+        ON CONFLICT (subscription_id) DO UPDATE
+            SET app_id = EXCLUDED.app_id, expire_at = EXCLUDED.expire_at, due_at = EXCLUDED.due_at;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER subscriptions_sync_due AFTER INSERT OR UPDATE OR DELETE ON subscriptions
+    FOR EACH ROW EXECUTE FUNCTION sync_subscription_due();
+
+-- Recompute the due queue of an app when its validation window changes
+CREATE FUNCTION refresh_app_subscription_due() RETURNS trigger AS $$
+BEGIN
+    PERFORM refresh_subscription_due(ARRAY(
+        SELECT id FROM subscriptions WHERE app_id = COALESCE(NEW.app_id, OLD.app_id)
+    ));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER app_validation_windows_refresh_due AFTER INSERT OR UPDATE OR DELETE ON app_validation_windows
+    FOR EACH ROW EXECUTE FUNCTION refresh_app_subscription_due();
+
+-- Rewrite the statuses written before the subscription state model to their successor, so rows of
+-- writers and dumps from before it validate like every other row. Mirrors legacySubscriptionStatuses.
+CREATE FUNCTION normalize_subscription_status() RETURNS trigger AS $$
+BEGIN
+    IF NEW.status = 'pending' THEN
+        NEW.status := 'started';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER subscriptions_normalize_status BEFORE INSERT OR UPDATE OF status ON subscriptions
+    FOR EACH ROW EXECUTE FUNCTION normalize_subscription_status();
+
+-- Stamp every change of a subscription with the database clock, whoever writes it. Sweeps only write
+-- subscriptions whose updated_at is unchanged since they were read, which a writer setting its own
+-- second-precision or local time could otherwise repeat.
+CREATE FUNCTION touch_subscription_updated_at() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at := clock_timestamp();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER subscriptions_touch_updated_at BEFORE UPDATE ON subscriptions
+    FOR EACH ROW EXECUTE FUNCTION touch_subscription_updated_at();